@@ -23,13 +23,197 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
-// CustomIngressManagerSpec defines the desired state of CustomIngressManager
+// CustomIngressManagerSpec defines the certificate policy for the labelled services in its namespace
 type CustomIngressManagerSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ServiceSelector selects the services the policy applies to. All services of the namespace if empty.
+	// +optional
+	ServiceSelector *metav1.LabelSelector `json:"serviceSelector,omitempty"`
+
+	// Issuer selects the certificate backend of the services. The issuance mode annotation or
+	// environment label of the service is used if empty.
+	// +optional
+	Issuer *IssuerPolicy `json:"issuer,omitempty"`
+
+	// Route selects how the services are exposed. An Ingress is created if empty.
+	// +optional
+	Route *RoutePolicy `json:"route,omitempty"`
+
+	// DefaultIngressAnnotations are set on the Ingresses of the services, over the default
+	// annotations of the operator. The annotations passed through from a service override them.
+	// +optional
+	DefaultIngressAnnotations map[string]string `json:"defaultIngressAnnotations,omitempty"`
+}
+
+// RoutePolicy configures the object exposing the services.
+type RoutePolicy struct {
+	// Gateway exposes the services with a Gateway API HTTPRoute attached to the Gateway instead
+	// of an Ingress.
+	// +optional
+	Gateway *GatewayRoutePolicy `json:"gateway,omitempty"`
+
+	// Istio exposes the services with an Istio Gateway and VirtualService instead of an Ingress.
+	// +optional
+	Istio *IstioRoutePolicy `json:"istio,omitempty"`
+
+	// Traefik exposes the services with a Traefik IngressRoute instead of an Ingress.
+	// +optional
+	Traefik *TraefikRoutePolicy `json:"traefik,omitempty"`
+
+	// OpenShift exposes the services with an OpenShift Route instead of an Ingress.
+	// +optional
+	OpenShift *OpenShiftRoutePolicy `json:"openShift,omitempty"`
+}
+
+// GatewayRoutePolicy attaches the HTTPRoutes of the services to a Gateway.
+type GatewayRoutePolicy struct {
+	// Name of the Gateway.
+	Name string `json:"name"`
+
+	// Namespace of the Gateway. The namespace of the service if empty.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SectionName is the listener the routes attach to when ManageListener is not set. All
+	// listeners of the Gateway if empty.
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+
+	// ManageListener adds an HTTPS listener for the domain of every service to the Gateway,
+	// terminating TLS with the certificate secret of the service. Otherwise the Gateway must
+	// already terminate TLS for the domain.
+	// +optional
+	ManageListener bool `json:"manageListener,omitempty"`
+}
+
+// IstioRoutePolicy exposes the services through the Istio ingress gateway. The Istio Gateway
+// and the certificate of every service are created in the namespace of the ingress gateway,
+// which only reads secrets of its own namespace.
+type IstioRoutePolicy struct {
+	// Namespace of the Istio ingress gateway. istio-system if empty.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Selector selects the ingress gateway pods. istio: ingressgateway if empty.
+	// +optional
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// TraefikRoutePolicy configures the Traefik IngressRoutes of the services.
+type TraefikRoutePolicy struct {
+	// EntryPoints the routes are served on. websecure if empty.
+	// +optional
+	EntryPoints []string `json:"entryPoints,omitempty"`
+}
+
+// OpenShiftRoutePolicy configures the OpenShift Routes of the services. Routes cannot reference
+// secrets, the certificate and key of the service are copied into the Route once issued.
+type OpenShiftRoutePolicy struct {
+	// InsecureEdgeTerminationPolicy handles plain HTTP requests. Redirect if empty.
+	// +kubebuilder:validation:Enum=None;Allow;Redirect
+	// +optional
+	InsecureEdgeTerminationPolicy string `json:"insecureEdgeTerminationPolicy,omitempty"`
+}
+
+// IssuerPolicy configures the cert-manager issuer created for the services. Exactly one backend must be set.
+type IssuerPolicy struct {
+	// Vault issues certificates from a HashiCorp Vault PKI secrets engine.
+	// +optional
+	Vault *VaultIssuerPolicy `json:"vault,omitempty"`
+
+	// Venafi issues certificates from Venafi Trust Protection Platform or Venafi Cloud.
+	// +optional
+	Venafi *VenafiIssuerPolicy `json:"venafi,omitempty"`
+}
+
+// VaultIssuerPolicy configures a cert-manager Vault issuer. The credentials and the CA bundle
+// of the server come from the issuer servers of the operator config.
+type VaultIssuerPolicy struct {
+	// Server is the address of the Vault server, e.g. https://vault.example.com:8200. It must be
+	// one of the issuer servers of the operator config.
+	Server string `json:"server"`
+
+	// Path is the signing endpoint of the PKI role, e.g. pki_int/sign/example-dot-com.
+	Path string `json:"path"`
+}
+
+// VaultAuthPolicy configures the Vault authentication of an issuer server in the operator
+// config. Exactly one method must be set.
+type VaultAuthPolicy struct {
+	// TokenSecretRef authenticates with a token stored in a secret.
+	// +optional
+	TokenSecretRef *SecretKeyReference `json:"tokenSecretRef,omitempty"`
+
+	// AppRole authenticates with the AppRole auth method.
+	// +optional
+	AppRole *VaultAppRolePolicy `json:"appRole,omitempty"`
+
+	// Kubernetes authenticates with a service account token.
+	// +optional
+	Kubernetes *VaultKubernetesAuthPolicy `json:"kubernetes,omitempty"`
+}
+
+// VaultAppRolePolicy configures the Vault AppRole auth method.
+type VaultAppRolePolicy struct {
+	// Path where the AppRole auth method is mounted, e.g. approle.
+	Path string `json:"path"`
+
+	// RoleID of the AppRole.
+	RoleID string `json:"roleId"`
+
+	// SecretRef references the secret holding the AppRole secret ID.
+	SecretRef SecretKeyReference `json:"secretRef"`
+}
+
+// VaultKubernetesAuthPolicy configures the Vault Kubernetes auth method.
+type VaultKubernetesAuthPolicy struct {
+	// MountPath of the Kubernetes auth method. Defaults to /v1/auth/kubernetes.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// Role is the Vault role to authenticate as.
+	Role string `json:"role"`
+
+	// SecretRef references the secret holding the service account token.
+	SecretRef SecretKeyReference `json:"secretRef"`
+}
+
+// VenafiIssuerPolicy configures a cert-manager Venafi issuer. Exactly one of TPP and Cloud must be set.
+type VenafiIssuerPolicy struct {
+	// Zone is the Venafi policy zone certificates are requested in.
+	Zone string `json:"zone"`
+
+	// TPP configures Venafi Trust Protection Platform.
+	// +optional
+	TPP *VenafiTPPPolicy `json:"tpp,omitempty"`
+
+	// Cloud configures Venafi Cloud.
+	// +optional
+	Cloud *VenafiCloudPolicy `json:"cloud,omitempty"`
+}
+
+// VenafiTPPPolicy configures Venafi Trust Protection Platform.
+type VenafiTPPPolicy struct {
+	// URL of the TPP instance, e.g. https://tpp.example.com/vedsdk. It must be one of the issuer
+	// servers of the operator config.
+	URL string `json:"url"`
+}
+
+// VenafiCloudPolicy configures Venafi Cloud.
+type VenafiCloudPolicy struct {
+	// URL of the Venafi Cloud API. Defaults to the public endpoint. It must be one of the issuer
+	// servers of the operator config.
+	// +optional
+	URL string `json:"url,omitempty"`
+}
+
+// SecretKeyReference references a key of a secret.
+type SecretKeyReference struct {
+	// Name of the secret.
+	Name string `json:"name"`
 
-	// Foo is an example field of CustomIngressManager. Edit CustomIngressManager_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Key of the secret. The default key of the issuer is used if empty.
+	// +optional
+	Key string `json:"key,omitempty"`
 }
 
 // CustomIngressManagerStatus defines the observed state of CustomIngressManager
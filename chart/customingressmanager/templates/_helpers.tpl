@@ -73,3 +73,171 @@ Create the name of the service account to use
     {{ default "default" .Values.serviceAccount.name }}
 {{- end -}}
 {{- end -}}
+
+{{/*
+Cluster resource namespace of cert-manager, holding the rate limit ledger and the root CA.
+*/}}
+{{- define "customingressmanager.clusterResourceNamespace" -}}
+{{- .Values.config.clusterResourceNamespace | default "cert-manager" -}}
+{{- end -}}
+
+{{/*
+Namespaces granted the namespaced permissions with a Role, as a JSON array: the watched
+namespaces, the route namespaces and the namespace of the Traefik Service. Empty unless
+watchNamespaces is set, the ClusterRole grants them otherwise.
+*/}}
+{{- define "customingressmanager.roleNamespaces" -}}
+{{- $namespaces := list -}}
+{{- if .Values.watchNamespaces -}}
+{{- $namespaces = concat .Values.watchNamespaces .Values.routeNamespaces -}}
+{{- with .Values.config.traefikService -}}
+{{- $namespaces = append $namespaces (first (splitList "/" .)) -}}
+{{- end -}}
+{{- end -}}
+{{- $namespaces | uniq | toJson -}}
+{{- end -}}
+
+{{/*
+RBAC rules for the namespaced resources managed by the operator. Rendered into the
+ClusterRole, or into a Role per namespace when watchNamespaces is set. The Gateway API rules
+are only granted with gatewayAPI.enabled.
+*/}}
+{{- define "customingressmanager.namespacedRules" }}
+  - apiGroups:
+      - ""
+      - extensions
+    resources:
+      - ingresses
+      - services
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  # conditions recorded on the services
+  - apiGroups:
+      - ""
+    resources:
+      - services
+    verbs:
+      - patch
+  - apiGroups:
+      - cert-manager.io
+    resources:
+      - certificates
+      - certificaterequests
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  - apiGroups:
+      - cert-manager.io
+    resources:
+      - certificates/status
+    verbs:
+      - update
+  - apiGroups:
+      - ""
+    resources:
+      - secrets
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  - apiGroups:
+      - ""
+    resources:
+      - pods
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - watch
+{{- if .Values.gatewayAPI.enabled }}
+  - apiGroups:
+      - gateway.networking.k8s.io
+    resources:
+      - httproutes
+      - referencegrants
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  # managed listeners are added to the Gateways
+  - apiGroups:
+      - gateway.networking.k8s.io
+    resources:
+      - gateways
+    verbs:
+      - get
+      - list
+      - update
+      - watch
+{{- end }}
+  - apiGroups:
+      - networking.istio.io
+    resources:
+      - gateways
+      - virtualservices
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  - apiGroups:
+      - traefik.io
+      - traefik.containo.us
+    resources:
+      - ingressroutes
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  - apiGroups:
+      - route.openshift.io
+    resources:
+      - routes
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+  - apiGroups:
+      - route.openshift.io
+    resources:
+      # the certificate of the Route is set by the manager
+      - routes/custom-host
+    verbs:
+      - create
+      - update
+  - apiGroups:
+      - externaldns.k8s.io
+    resources:
+      - dnsendpoints
+    verbs:
+      - create
+      - delete
+      - get
+      - list
+      - update
+      - watch
+{{- end -}}
@@ -0,0 +1,775 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+const (
+	// ACMESolverLabel marks the temporary HTTP-01 solver objects, the value is the solver name.
+	ACMESolverLabel = "feladat.banzaicloud.io/acme-solver"
+	// ACMESolverTimeout bounds waiting for the solver pod to become ready.
+	ACMESolverTimeout = 2 * time.Minute
+	// ACMERequestTimeout bounds the requests to the ACME server within a reconcile.
+	ACMERequestTimeout = 30 * time.Second
+	// ACMEOrderRequeue is the interval for checking an ACME order in progress.
+	ACMEOrderRequeue = 5 * time.Second
+	// ACMEOrderAnnotation records the URL of the order in progress on the order secret.
+	ACMEOrderAnnotation = "feladat.banzaicloud.io/acme-order"
+	// ACMEOrderSecretType is the type of the secrets recording an ACME order and its private key.
+	ACMEOrderSecretType corev1.SecretType = "feladat.banzaicloud.io/acme-order"
+	// ACMEServerAnnotation records the directory URL of the ACME server that issued the TLS secret.
+	ACMEServerAnnotation = "feladat.banzaicloud.io/acme-server"
+	// ACMEAccountSecretType is the type of the secrets holding the per namespace ACME account key.
+	ACMEAccountSecretType corev1.SecretType = "feladat.banzaicloud.io/acme-account"
+	// ACMEAccountsKey of the account secret maps the directory URLs of the ACME servers the
+	// account is registered with to the account URLs, as JSON.
+	ACMEAccountsKey = "accounts.json"
+
+	acmeSolverPort = 8089
+	// acmeSolverScript serves the key authorization with busybox httpd.
+	acmeSolverScript = `mkdir -p /www/.well-known/acme-challenge && printf '%s' "$KEY_AUTHORIZATION" > "/www/.well-known/acme-challenge/$TOKEN" && exec httpd -f -p 8089 -h /www`
+)
+
+// HasProductionTLSSecret reports whether the TLS secret of the service holds a certificate of
+// the production ACME server. Secrets issued before the server was recorded are taken as such.
+func (r *CustomIngressManagerReconciler) HasProductionTLSSecret(service corev1.Service) (bool, error) {
+	secret := corev1.Secret{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: r.config().ServiceTLSSecretName(service), Namespace: service.Namespace}, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	server := secret.Annotations[ACMEServerAnnotation]
+
+	return server == "" || server == r.config().ACMEProductionURL, nil
+}
+
+// EnsureACMECertificate issues the TLS secret of the service with the built-in ACME client
+// unless it already holds a certificate for the domain that is not due for renewal. The order
+// is recorded in a secret and taken a step further on every call, so the reconciler never waits
+// for the ACME server. It returns the time left until the renewal, until the rate limit budget
+// allows the order, or until the order in progress is checked again.
+func (r *CustomIngressManagerReconciler) EnsureACMECertificate(service corev1.Service, policy *webappv1.CustomIngressManager) (time.Duration, error) {
+	ctx := context.Background()
+	cfg := r.config()
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	options, _ := cfg.CertificateOptions(service.ObjectMeta.Annotations)
+	renewBefore := options.RenewBeforeDuration()
+
+	secretName := cfg.ServiceTLSSecretName(service)
+	existingSecret := corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: service.Namespace}, &existingSecret)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+
+	found := err == nil
+	server := cfg.ACMEServerURL(service.ObjectMeta.Labels[cfg.EnvironmentLabel])
+	// certificates of the other server are replaced after a switch between staging and production
+	sameServer := existingSecret.Annotations[ACMEServerAnnotation] == "" || existingSecret.Annotations[ACMEServerAnnotation] == server
+	if found && sameServer {
+		if renewIn := CertificateRenewIn(existingSecret.Data[corev1.TLSCertKey], domain, renewBefore, time.Now()); renewIn > 0 {
+			return renewIn, nil
+		}
+	}
+
+	order, err := r.getACMEOrder(ctx, service)
+	if err != nil {
+		return 0, err
+	}
+
+	// an order of the other server is abandoned after a switch between staging and production
+	if order != nil && order.Annotations[ACMEServerAnnotation] != server {
+		if err := r.DeleteACMEOrder(service); err != nil {
+			return 0, err
+		}
+		order = nil
+	}
+
+	if order == nil {
+		if waiting, err := r.WaitForCAA(service, nil); err != nil {
+			return 0, err
+		} else if waiting {
+			return CAARequeue, nil
+		}
+
+		// a certificate valid for the domain is renewed, anything else is a new certificate
+		_, valid := CertificateRenewalTime(existingSecret.Data[corev1.TLSCertKey], domain, renewBefore)
+		if delay, err := r.ReserveRateLimitBudget(service, nil, found && sameServer && valid); err != nil || delay > 0 {
+			return delay, err
+		}
+
+		r.Log.Info("ordering certificate for " + domain + " with the built-in ACME client")
+		if order, err = r.CreateACMEOrder(ctx, service, domain, options); err != nil {
+			// the ACME server did not count an order it refused
+			if err := r.ReleaseRateLimitBudget(service, nil, true); err != nil {
+				r.Log.Error(err, "unable to release the rate limit budget of "+service.Name)
+			}
+
+			return 0, err
+		}
+	}
+
+	certPEM, err := r.AdvanceACMEOrder(ctx, service, policy, domain, order)
+	if _, failed := err.(*ACMEOrderFailedError); failed {
+		if found && r.Recorder != nil {
+			r.Recorder.Event(&service, corev1.EventTypeWarning, "CertificateNotRenewed", "renewal of the certificate for "+config.DisplayDomain(domain)+" failed: "+err.Error())
+		}
+
+		// the next reconcile places a new order
+		if err := r.DeleteACMEOrder(service); err != nil {
+			r.Log.Error(err, "unable to delete acme order of "+service.Name)
+		}
+
+		// no certificate was issued, the order itself counted against the account
+		if err := r.ReleaseRateLimitBudget(service, nil, false); err != nil {
+			r.Log.Error(err, "unable to release the rate limit budget of "+service.Name)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if certPEM == nil {
+		return ACMEOrderRequeue, nil
+	}
+
+	data := map[string][]byte{
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: order.Data[corev1.TLSPrivateKeyKey],
+	}
+
+	if found {
+		desired := existingSecret.DeepCopy()
+		desired.Labels = MergeLabels(desired.Labels, CreateManagedLabels(service))
+		desired.Annotations = MergeLabels(desired.Annotations, map[string]string{ACMEServerAnnotation: server})
+		desired.Data = data
+
+		r.Log.Info("updating tls secret")
+		if err := r.Update(ctx, desired); err != nil {
+			return 0, err
+		}
+	} else {
+		secret := corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        secretName,
+				Namespace:   service.Namespace,
+				Labels:      CreateManagedLabels(service),
+				Annotations: map[string]string{ACMEServerAnnotation: server},
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+
+		r.Log.Info("creating tls secret")
+		if err := r.Create(ctx, &secret); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := r.DeleteACMEOrder(service); err != nil {
+		return 0, err
+	}
+
+	return CertificateRenewIn(certPEM, domain, renewBefore, time.Now()), nil
+}
+
+// CreateACMEOrder places an order for the domain and records it in the order secret of the
+// service, together with the private key of the certificate.
+func (r *CustomIngressManagerReconciler) CreateACMEOrder(ctx context.Context, service corev1.Service, domain string, options config.CertificateOptions) (*corev1.Secret, error) {
+	ctx, cancel := context.WithTimeout(ctx, ACMERequestTimeout)
+	defer cancel()
+
+	acmeClient, err := r.acmeClient(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	// the duration is not sent as notAfter, Let's Encrypt rejects orders with it
+	order, err := acmeClient.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, err
+	}
+
+	_, keyPEM, err := GeneratePrivateKey(options)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ACMEOrderSecretName(service),
+			Namespace: service.Namespace,
+			Labels:    CreateManagedLabels(service),
+			Annotations: map[string]string{
+				ACMEServerAnnotation: acmeClient.DirectoryURL,
+				ACMEOrderAnnotation:  order.URI,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))},
+		},
+		Type: ACMEOrderSecretType,
+		Data: map[string][]byte{corev1.TLSPrivateKeyKey: keyPEM},
+	}
+
+	r.Log.Info("creating acme order secret for " + order.URI)
+	if err := r.Create(ctx, secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// AdvanceACMEOrder takes the order recorded in the secret one step further without waiting for
+// the ACME server: it serves the HTTP-01 challenges, finalizes the ready order and fetches the
+// certificate. It returns the PEM encoded certificate chain once the order is valid, and nil
+// while it is in progress. Orders that can not be completed anymore fail with an
+// ACMEOrderFailedError, other errors leave the order to be checked again.
+func (r *CustomIngressManagerReconciler) AdvanceACMEOrder(ctx context.Context, service corev1.Service, policy *webappv1.CustomIngressManager, domain string, secret *corev1.Secret) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ACMERequestTimeout)
+	defer cancel()
+
+	acmeClient, err := r.acmeClient(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := acmeClient.GetOrder(ctx, secret.Annotations[ACMEOrderAnnotation])
+	if acmeErr, ok := err.(*acme.Error); ok && acmeErr.StatusCode == http.StatusNotFound {
+		return nil, &ACMEOrderFailedError{Reason: "acme order for " + domain + " is gone"}
+	} else if err != nil {
+		return nil, err
+	}
+
+	var chain [][]byte
+	switch order.Status {
+	case acme.StatusPending:
+		for _, authzURL := range order.AuthzURLs {
+			authz, err := acmeClient.GetAuthorization(ctx, authzURL)
+			if err != nil {
+				return nil, err
+			}
+
+			switch authz.Status {
+			case acme.StatusValid:
+			case acme.StatusPending:
+				if err := r.SolveHTTP01(ctx, acmeClient, service, policy, domain, authz); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, &ACMEOrderFailedError{Reason: "authorization of " + domain + " is " + authz.Status}
+			}
+		}
+
+		return nil, nil
+	case acme.StatusProcessing:
+		return nil, nil
+	case acme.StatusReady:
+		block, _ := pem.Decode(secret.Data[corev1.TLSPrivateKeyKey])
+		if block == nil {
+			return nil, &ACMEOrderFailedError{Reason: "no private key in acme order secret " + secret.Name}
+		}
+
+		key, err := ParsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, &ACMEOrderFailedError{Reason: err.Error()}
+		}
+
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: domain},
+			DNSNames: []string{domain},
+		}, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if chain, _, err = acmeClient.CreateOrderCert(ctx, order.FinalizeURL, csr, true); err != nil {
+			return nil, err
+		}
+	case acme.StatusValid:
+		if chain, err = acmeClient.FetchCert(ctx, order.CertURL, true); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &ACMEOrderFailedError{Reason: "acme order for " + domain + " is " + order.Status}
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	return certPEM, nil
+}
+
+// SolveHTTP01 serves the HTTP-01 challenge of the authorization from a solver pod exposed by a
+// service and an ingress for the challenge path, and accepts the challenge once the pod is
+// ready. It does not wait for either, the order is checked again on the next reconcile.
+func (r *CustomIngressManagerReconciler) SolveHTTP01(ctx context.Context, acmeClient *acme.Client, service corev1.Service, policy *webappv1.CustomIngressManager, domain string, authz *acme.Authorization) error {
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+
+			break
+		}
+	}
+
+	if challenge == nil {
+		return &ACMEOrderFailedError{Reason: "no http-01 challenge offered for " + domain}
+	}
+
+	keyAuthorization, err := acmeClient.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+
+	objects := r.CreateHTTP01SolverObjects(service, policy, domain, challenge.Token, acmeClient.HTTP01ChallengePath(challenge.Token), keyAuthorization)
+	pod := objects[0].(*corev1.Pod)
+
+	current := corev1.Pod{}
+	err = r.Get(ctx, types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, &current)
+	if errors.IsNotFound(err) {
+		// leftovers of an earlier order
+		if err := r.DeleteACMESolvers(service); err != nil {
+			return err
+		}
+
+		r.Log.Info("creating acme solver " + pod.Name)
+		for _, object := range objects {
+			if err := r.Create(ctx, object); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !IsPodReady(&current) {
+		if !current.CreationTimestamp.IsZero() && time.Since(current.CreationTimestamp.Time) > ACMESolverTimeout {
+			return &ACMEOrderFailedError{Reason: "acme solver pod " + pod.Name + " is not ready after " + ACMESolverTimeout.String()}
+		}
+
+		return nil
+	}
+
+	if challenge.Status == acme.StatusPending {
+		r.Log.Info("accepting http-01 challenge for " + domain)
+		if _, err := acmeClient.Accept(ctx, challenge); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ACMEOrderFailedError is returned for ACME orders that can not be completed anymore.
+type ACMEOrderFailedError struct {
+	Reason string
+}
+
+func (e *ACMEOrderFailedError) Error() string {
+	return e.Reason
+}
+
+// getACMEOrder returns the order secret of the service, nil if there is no order in progress.
+func (r *CustomIngressManagerReconciler) getACMEOrder(ctx context.Context, service corev1.Service) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ACMEOrderSecretName(service), Namespace: service.Namespace}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if !IsManagedByUs(secret.ObjectMeta) || secret.Type != ACMEOrderSecretType {
+		return nil, fmt.Errorf("secret %s/%s is not an acme order secret managed by us", secret.Namespace, secret.Name)
+	}
+
+	return secret, nil
+}
+
+// DeleteACMEOrder deletes the order secret and the HTTP-01 solver objects of the service.
+func (r *CustomIngressManagerReconciler) DeleteACMEOrder(service corev1.Service) error {
+	if err := r.DeleteACMESolvers(service); err != nil {
+		return err
+	}
+
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ACMEOrderSecretName(service), Namespace: service.Namespace},
+	}
+
+	return client.IgnoreNotFound(r.Delete(context.Background(), &secret))
+}
+
+// ACMEOrderSecretName returns the name of the secret recording the ACME order of the service.
+func ACMEOrderSecretName(service corev1.Service) string {
+	return service.Name + "-acme-order"
+}
+
+// IngressClassAnnotation selects the ingress controller serving an ingress.
+const IngressClassAnnotation = "kubernetes.io/ingress.class"
+
+// CreateHTTP01SolverObjects returns the solver pod, service and ingress serving the key
+// authorization on the challenge path of the domain, the pod first.
+func (r *CustomIngressManagerReconciler) CreateHTTP01SolverObjects(service corev1.Service, policy *webappv1.CustomIngressManager, domain, token, path, keyAuthorization string) []runtime.Object {
+	cfg := r.config()
+
+	sum := sha256.Sum256([]byte(token))
+	name := service.Name + "-acme-solver-" + hex.EncodeToString(sum[:4])
+	labels := MergeLabels(CreateManagedLabels(service), map[string]string{ACMESolverLabel: name})
+	objectMeta := metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       service.Namespace,
+		Labels:          labels,
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: objectMeta,
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:    "acme-solver",
+					Image:   cfg.ACMESolverImage,
+					Command: []string{"sh", "-c", acmeSolverScript},
+					Env: []corev1.EnvVar{
+						{Name: "TOKEN", Value: token},
+						{Name: "KEY_AUTHORIZATION", Value: keyAuthorization},
+					},
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: acmeSolverPort}},
+					ReadinessProbe: &corev1.Probe{
+						Handler: corev1.Handler{
+							HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt(acmeSolverPort)},
+						},
+						PeriodSeconds: 2,
+					},
+				},
+			},
+		},
+	}
+
+	solverService := &corev1.Service{
+		ObjectMeta: *objectMeta.DeepCopy(),
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{ACMESolverLabel: name},
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: acmeSolverPort, TargetPort: intstr.FromInt(acmeSolverPort)},
+			},
+		},
+	}
+
+	// the annotations of the service ingress, like ssl-redirect or auth, would answer the plain
+	// HTTP request of the ACME server with a redirect or 401, only its class is kept
+	ingressMeta := *objectMeta.DeepCopy()
+	if class, ok := cfg.IngressAnnotations(service.ObjectMeta.Annotations, policy)[IngressClassAnnotation]; ok {
+		ingressMeta.Annotations = map[string]string{IngressClassAnnotation: class}
+	}
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: ingressMeta,
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{
+				{
+					Host: domain,
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{
+								{
+									Path: path,
+									Backend: v1beta1.IngressBackend{
+										ServiceName: name,
+										ServicePort: intstr.FromInt(acmeSolverPort),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return []runtime.Object{pod, solverService, ingress}
+}
+
+// DeleteACMESolvers deletes the HTTP-01 solver objects of the service.
+func (r *CustomIngressManagerReconciler) DeleteACMESolvers(service corev1.Service) error {
+	ctx := context.Background()
+	selector := client.MatchingLabels{config.ServiceNameLabel: service.Name, config.ServiceNamespaceLabel: service.Namespace}
+
+	var objects []runtime.Object
+
+	var ingresses v1beta1.IngressList
+	if err := r.List(ctx, &ingresses, client.InNamespace(service.Namespace), selector); err != nil {
+		return err
+	}
+	for i := range ingresses.Items {
+		if _, ok := ingresses.Items[i].Labels[ACMESolverLabel]; ok {
+			objects = append(objects, &ingresses.Items[i])
+		}
+	}
+
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services, client.InNamespace(service.Namespace), selector); err != nil {
+		return err
+	}
+	for i := range services.Items {
+		if _, ok := services.Items[i].Labels[ACMESolverLabel]; ok {
+			objects = append(objects, &services.Items[i])
+		}
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(service.Namespace), selector); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		if _, ok := pods.Items[i].Labels[ACMESolverLabel]; ok {
+			objects = append(objects, &pods.Items[i])
+		}
+	}
+
+	for _, object := range objects {
+		if err := r.Delete(ctx, object); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsPodReady reports whether the Ready condition of the pod is true.
+func IsPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// acmeClient returns an ACME client for the server of the service environment, with the account
+// key stored per namespace. The account is registered with a server once, its URL is recorded in
+// the account secret so later clients only look it up.
+func (r *CustomIngressManagerReconciler) acmeClient(ctx context.Context, service corev1.Service) (*acme.Client, error) {
+	cfg := r.config()
+
+	key, secret, err := r.acmeAccountKey(ctx, cfg.CreateACMEAccountSecretName(service.Namespace), service.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	acmeClient := &acme.Client{
+		Key:          key,
+		DirectoryURL: cfg.ACMEServerURL(service.ObjectMeta.Labels[cfg.EnvironmentLabel]),
+		HTTPClient:   r.ACMEHTTPClient,
+	}
+
+	accounts := map[string]string{}
+	if data, ok := secret.Data[ACMEAccountsKey]; ok {
+		if err := json.Unmarshal(data, &accounts); err != nil {
+			return nil, fmt.Errorf("acme account secret %s: %v", secret.Name, err)
+		}
+	}
+	if _, ok := accounts[acmeClient.DirectoryURL]; ok {
+		return acmeClient, nil
+	}
+
+	email, _ := cfg.Email(service.ObjectMeta.Annotations)
+	account, err := acmeClient.Register(ctx, &acme.Account{Contact: []string{"mailto:" + email}}, acme.AcceptTOS)
+	if err == acme.ErrAccountAlreadyExists {
+		account, err = acmeClient.GetReg(ctx, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accounts[acmeClient.DirectoryURL] = account.URI
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Log.Info("registered acme account " + account.URI)
+	desired := secret.DeepCopy()
+	desired.Data[ACMEAccountsKey] = data
+	if err := r.Update(ctx, desired); err != nil {
+		return nil, err
+	}
+
+	return acmeClient, nil
+}
+
+// acmeAccountKey returns the ACME account key stored in the secret, generating it on first use,
+// and the secret. A secret of that name which we did not create is never used.
+func (r *CustomIngressManagerReconciler) acmeAccountKey(ctx context.Context, secretName, namespace string) (crypto.Signer, *corev1.Secret, error) {
+	secret := corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: namespace}, &secret)
+	if err == nil {
+		if !IsManagedByUs(secret.ObjectMeta) || secret.Type != ACMEAccountSecretType {
+			return nil, nil, fmt.Errorf("secret %s/%s is not an acme account secret managed by us", namespace, secretName)
+		}
+
+		block, _ := pem.Decode(secret.Data[corev1.TLSPrivateKeyKey])
+		if block == nil {
+			return nil, nil, fmt.Errorf("no private key in acme account secret %s", secretName)
+		}
+
+		key, err := ParsePrivateKey(block.Bytes)
+
+		return key, &secret, err
+	}
+
+	if !errors.IsNotFound(err) {
+		return nil, nil, err
+	}
+
+	key, keyPEM, err := GeneratePrivateKey(config.CertificateOptions{KeyAlgorithm: string(v1alpha3.ECDSAKeyAlgorithm)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret = corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+			Labels:    map[string]string{config.ManagedByLabel: config.ManagedByLabelValue},
+		},
+		Type: ACMEAccountSecretType,
+		Data: map[string][]byte{corev1.TLSPrivateKeyKey: keyPEM},
+	}
+
+	r.Log.Info("creating acme account key secret")
+	if err := r.Create(ctx, &secret); err != nil {
+		return nil, nil, err
+	}
+
+	return key, &secret, nil
+}
+
+// ParsePrivateKey parses a DER encoded PKCS#1 RSA, PKCS#8 or SEC 1 EC private key.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+
+		return signer, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("private key is not in PKCS#1, PKCS#8 or SEC 1 form")
+}
+
+// GeneratePrivateKey generates a private key with the algorithm, size and encoding of the
+// options, defaulting like cert-manager to RSA 2048 in PKCS#1. It returns the key and its PEM form.
+func GeneratePrivateKey(options config.CertificateOptions) (crypto.Signer, []byte, error) {
+	var key crypto.Signer
+
+	switch v1alpha3.KeyAlgorithm(options.KeyAlgorithm) {
+	case v1alpha3.ECDSAKeyAlgorithm:
+		curve := elliptic.P256()
+		switch options.KeySize {
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		}
+
+		ecdsaKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = ecdsaKey
+	default:
+		size := options.KeySize
+		if size == 0 {
+			size = 2048
+		}
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = rsaKey
+	}
+
+	var block *pem.Block
+	if v1alpha3.KeyEncoding(options.KeyEncoding) == v1alpha3.PKCS8 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	} else if ecdsaKey, ok := key.(*ecdsa.PrivateKey); ok {
+		der, err := x509.MarshalECPrivateKey(ecdsaKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	} else {
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey))}
+	}
+
+	return key, pem.EncodeToMemory(block), nil
+}
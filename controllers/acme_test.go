@@ -0,0 +1,618 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+func TestGeneratePrivateKey(t *testing.T) {
+	_, keyPEM, err := GeneratePrivateKey(config.CertificateOptions{KeyAlgorithm: "ecdsa", KeySize: 384, KeyEncoding: "pkcs8"})
+	if err != nil {
+		t.Fatalf("GeneratePrivateKey() error = %v", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("GeneratePrivateKey() = %s, want PKCS#8 PEM", keyPEM)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ecdsaKey, ok := key.(*ecdsa.PrivateKey); !ok || ecdsaKey.Curve.Params().BitSize != 384 {
+		t.Errorf("GeneratePrivateKey() = %T, want P-384 key", key)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	for _, encoding := range []struct {
+		algorithm, encoding string
+	}{
+		{"rsa", "pkcs1"},
+		{"rsa", "pkcs8"},
+		{"ecdsa", "pkcs1"},
+		{"ecdsa", "pkcs8"},
+	} {
+		want, keyPEM, err := GeneratePrivateKey(config.CertificateOptions{KeyAlgorithm: encoding.algorithm, KeyEncoding: encoding.encoding})
+		if err != nil {
+			t.Fatalf("GeneratePrivateKey() error = %v", err)
+		}
+
+		block, _ := pem.Decode(keyPEM)
+		key, err := ParsePrivateKey(block.Bytes)
+		if err != nil {
+			t.Errorf("ParsePrivateKey(%s) error = %v", block.Type, err)
+			continue
+		}
+		if !reflect.DeepEqual(key.Public(), want.Public()) {
+			t.Errorf("ParsePrivateKey(%s) = %T, want the generated key", block.Type, key)
+		}
+	}
+
+	if _, err := ParsePrivateKey([]byte("garbage")); err == nil {
+		t.Errorf("ParsePrivateKey() error = nil, want error")
+	}
+}
+
+func TestCustomIngressManagerReconciler_acmeAccountKey(t *testing.T) {
+	InitTestScheme()
+
+	// the baseline TLS secret used to share the name of the account secret
+	tlsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-acme-account", Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSPrivateKeyKey: []byte("not ours")},
+	}
+	r := &CustomIngressManagerReconciler{
+		Client: clientFaker.NewFakeClientWithScheme(testScheme, tlsSecret),
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: config.DefaultConfig(),
+	}
+	if _, _, err := r.acmeAccountKey(context.Background(), "default-acme-account", "default"); err == nil {
+		t.Errorf("acmeAccountKey() of an unmanaged secret error = nil, want error")
+	}
+
+	key, _, err := r.acmeAccountKey(context.Background(), "other-acme-account", "default")
+	if err != nil {
+		t.Fatalf("acmeAccountKey() error = %v", err)
+	}
+
+	var secret corev1.Secret
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "other-acme-account", Namespace: "default"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != ACMEAccountSecretType || !IsManagedByUs(secret.ObjectMeta) {
+		t.Errorf("acme account secret = %v, want type %s managed by us", secret.ObjectMeta, ACMEAccountSecretType)
+	}
+
+	again, _, err := r.acmeAccountKey(context.Background(), "other-acme-account", "default")
+	if err != nil || !reflect.DeepEqual(again.Public(), key.Public()) {
+		t.Errorf("acmeAccountKey() = %v, %v, want the stored key", again, err)
+	}
+}
+
+func TestCustomIngressManagerReconciler_CreateHTTP01SolverObjects(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DefaultIngressAnnotations = map[string]string{
+		IngressClassAnnotation:                       "nginx",
+		"nginx.ingress.kubernetes.io/ssl-redirect":   "true",
+		"nginx.ingress.kubernetes.io/auth-url":       "https://auth.example.com",
+		"nginx.ingress.kubernetes.io/force-ssl-only": "true",
+	}
+	r := &CustomIngressManagerReconciler{
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Config: cfg,
+	}
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"ingress.feladat.banzaicloud.io/whitelist-source-range": "10.0.0.0/8"},
+		},
+	}
+
+	objects := r.CreateHTTP01SolverObjects(service, nil, "testsvc.com", "token", "/.well-known/acme-challenge/token", "token.thumbprint")
+	if len(objects) != 3 {
+		t.Fatalf("CreateHTTP01SolverObjects() returned %d objects, want 3", len(objects))
+	}
+
+	pod := objects[0].(*corev1.Pod)
+	solverService := objects[1].(*corev1.Service)
+	ingress := objects[2].(*v1beta1.Ingress)
+
+	if pod.Labels[ACMESolverLabel] != pod.Name || solverService.Spec.Selector[ACMESolverLabel] != pod.Name {
+		t.Errorf("solver service selector = %v, want pod %s", solverService.Spec.Selector, pod.Name)
+	}
+	if env := pod.Spec.Containers[0].Env; env[0].Value != "token" || env[1].Value != "token.thumbprint" {
+		t.Errorf("solver pod env = %v, want token and key authorization", env)
+	}
+	rule := ingress.Spec.Rules[0]
+	if rule.Host != "testsvc.com" || rule.HTTP.Paths[0].Path != "/.well-known/acme-challenge/token" || rule.HTTP.Paths[0].Backend.ServiceName != solverService.Name {
+		t.Errorf("solver ingress rule = %+v, want challenge path to the solver service", rule)
+	}
+	if want := map[string]string{IngressClassAnnotation: "nginx"}; !reflect.DeepEqual(ingress.Annotations, want) {
+		t.Errorf("solver ingress annotations = %v, want %v", ingress.Annotations, want)
+	}
+
+	// the class set by the policy selects the ingress controller as well
+	policy := &webappv1.CustomIngressManager{Spec: webappv1.CustomIngressManagerSpec{
+		DefaultIngressAnnotations: map[string]string{IngressClassAnnotation: "nginx-internal", "nginx.ingress.kubernetes.io/auth-type": "basic"},
+	}}
+	ingress = r.CreateHTTP01SolverObjects(service, policy, "testsvc.com", "token", "/.well-known/acme-challenge/token", "token.thumbprint")[2].(*v1beta1.Ingress)
+	if want := map[string]string{IngressClassAnnotation: "nginx-internal"}; !reflect.DeepEqual(ingress.Annotations, want) {
+		t.Errorf("solver ingress annotations = %v, want %v", ingress.Annotations, want)
+	}
+
+	r.Config = config.DefaultConfig()
+	ingress = r.CreateHTTP01SolverObjects(service, nil, "testsvc.com", "token", "/.well-known/acme-challenge/token", "token.thumbprint")[2].(*v1beta1.Ingress)
+	if len(ingress.Annotations) != 0 {
+		t.Errorf("solver ingress annotations = %v, want none", ingress.Annotations)
+	}
+}
+
+// TestCustomIngressManagerReconciler_EnsureACMECertificate_Pebble issues a certificate from Pebble
+// started with PEBBLE_VA_ALWAYS_VALID=1, e.g.
+// PEBBLE_DIRECTORY_URL=https://localhost:14000/dir go test ./controllers/ -run Pebble
+func TestCustomIngressManagerReconciler_EnsureACMECertificate_Pebble(t *testing.T) {
+	directoryURL := os.Getenv("PEBBLE_DIRECTORY_URL")
+	if directoryURL == "" {
+		t.Skip("PEBBLE_DIRECTORY_URL is not set")
+	}
+
+	InitTestScheme()
+
+	cfg := config.DefaultConfig()
+	cfg.CertificateBackend = config.CertificateBackendACME
+	cfg.ACMEStagingURL = directoryURL
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+		ACMEHTTPClient: &http.Client{
+			// Pebble serves its directory with a throwaway certificate
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+	}
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testsvc",
+			Namespace: "default",
+			Labels:    map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+			Annotations: map[string]string{
+				"feladat.banzaicloud.io/domain": "testsvc.com",
+				"feladat.banzaicloud.io/email":  "test@testsvc.com",
+			},
+		},
+	}
+
+	// there is no kubelet, mark the solver pods ready
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			var pods corev1.PodList
+			if err := c.List(ctx, &pods, client.InNamespace("default")); err == nil {
+				for i := range pods.Items {
+					pod := &pods.Items[i]
+					if !IsPodReady(pod) {
+						pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+						_ = c.Status().Update(ctx, pod)
+					}
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	// the order is taken a step further on every reconcile
+	var secret corev1.Secret
+	for deadline := time.Now().Add(2 * time.Minute); ; {
+		if _, err := r.EnsureACMECertificate(service, nil); err != nil {
+			t.Fatalf("CustomIngressManagerReconciler.EnsureACMECertificate() error = %v", err)
+		}
+
+		err := c.Get(ctx, types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &secret)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tls secret not issued: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+	if secret.Type != corev1.SecretTypeTLS || CertificateRenewIn(secret.Data[corev1.TLSCertKey], "testsvc.com", time.Hour, time.Now()) == 0 {
+		t.Errorf("tls secret = %v, want a certificate for testsvc.com", secret)
+	}
+	if secret.Annotations[ACMEServerAnnotation] != directoryURL {
+		t.Errorf("tls secret annotation %s = %q, want %q", ACMEServerAnnotation, secret.Annotations[ACMEServerAnnotation], directoryURL)
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("solver pods left behind: %d", len(pods.Items))
+	}
+
+	// a valid certificate is not issued again
+	if _, err := r.EnsureACMECertificate(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.EnsureACMECertificate() error = %v", err)
+	}
+}
+
+func TestCustomIngressManagerReconciler_EnsureACMECertificate(t *testing.T) {
+	InitTestScheme()
+
+	server := newFakeACMEServer(t)
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.CertificateBackend = config.CertificateBackendACME
+	cfg.ACMEStagingURL = server.URL + "/dir"
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testsvc",
+			Namespace: "default",
+			UID:       "testsvc-uid",
+			Labels:    map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+			Annotations: map[string]string{
+				"feladat.banzaicloud.io/domain":   "testsvc.com",
+				"feladat.banzaicloud.io/email":    "test@testsvc.com",
+				"feladat.banzaicloud.io/duration": "2160h",
+			},
+		},
+	}
+	ctx := context.Background()
+
+	ensure := func(want time.Duration) {
+		t.Helper()
+
+		got, err := r.EnsureACMECertificate(service, nil)
+		if err != nil {
+			t.Fatalf("CustomIngressManagerReconciler.EnsureACMECertificate() error = %v", err)
+		}
+		if want > 0 && got != want {
+			t.Fatalf("CustomIngressManagerReconciler.EnsureACMECertificate() = %v, want %v", got, want)
+		}
+	}
+
+	// the order is placed and the solver started without waiting for it
+	ensure(ACMEOrderRequeue)
+
+	var order corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-acme-order", Namespace: "default"}, &order); err != nil {
+		t.Fatal(err)
+	}
+	if order.Type != ACMEOrderSecretType || order.Annotations[ACMEOrderAnnotation] != server.URL+"/order/1" {
+		t.Errorf("acme order secret = %v, want order %s", order.ObjectMeta, server.URL+"/order/1")
+	}
+
+	// Let's Encrypt rejects orders with notBefore or notAfter
+	wantOrder := map[string]interface{}{
+		"identifiers": []interface{}{map[string]interface{}{"type": "dns", "value": "testsvc.com"}},
+	}
+	if len(server.orders) != 1 || !reflect.DeepEqual(server.orders[0], wantOrder) {
+		t.Errorf("order payloads = %v, want %v", server.orders, wantOrder)
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace("default")); err != nil || len(pods.Items) != 1 {
+		t.Fatalf("solver pods = %v, %v, want one", pods.Items, err)
+	}
+
+	// the challenge is accepted once the solver is ready
+	ensure(ACMEOrderRequeue)
+	if server.accepted() {
+		t.Errorf("challenge accepted before the solver pod is ready")
+	}
+
+	pod := &pods.Items[0]
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if err := c.Status().Update(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	ensure(ACMEOrderRequeue)
+	if !server.accepted() {
+		t.Errorf("challenge not accepted with the solver pod ready")
+	}
+
+	// the ready order is finalized with the key of the order secret
+	ensure(0)
+
+	var secret corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if CertificateRenewIn(secret.Data[corev1.TLSCertKey], "testsvc.com", time.Hour, time.Now()) == 0 {
+		t.Errorf("tls secret = %s, want a certificate for testsvc.com", secret.Data[corev1.TLSCertKey])
+	}
+	if string(secret.Data[corev1.TLSPrivateKeyKey]) != string(order.Data[corev1.TLSPrivateKeyKey]) {
+		t.Errorf("tls secret key differs from the key of the order")
+	}
+
+	// the account is registered once and recorded in the account secret
+	if server.registrations != 1 {
+		t.Errorf("account registrations = %d, want 1", server.registrations)
+	}
+	var account corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Name: "default-acme-account", Namespace: "default"}, &account); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"` + server.URL + `/dir":"` + server.URL + `/account/1"}`; string(account.Data[ACMEAccountsKey]) != want {
+		t.Errorf("acme account secret %s = %s, want %s", ACMEAccountsKey, account.Data[ACMEAccountsKey], want)
+	}
+
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-acme-order", Namespace: "default"}, &order); err == nil {
+		t.Errorf("acme order secret left behind")
+	}
+	if err := c.List(ctx, &pods, client.InNamespace("default")); err != nil || len(pods.Items) != 0 {
+		t.Errorf("solver pods left behind: %d", len(pods.Items))
+	}
+}
+
+func TestCustomIngressManagerReconciler_EnsureACMECertificate_Invalid(t *testing.T) {
+	InitTestScheme()
+
+	server := newFakeACMEServer(t)
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.CertificateBackend = config.CertificateBackendACME
+	cfg.ACMEStagingURL = server.URL + "/dir"
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testsvc",
+			Namespace: "default",
+			Labels:    map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+			Annotations: map[string]string{
+				"feladat.banzaicloud.io/domain": "testsvc.com",
+				"feladat.banzaicloud.io/email":  "test@testsvc.com",
+			},
+		},
+	}
+
+	if _, err := r.EnsureACMECertificate(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.EnsureACMECertificate() error = %v", err)
+	}
+
+	server.fail()
+
+	_, err := r.EnsureACMECertificate(service, nil)
+	if _, ok := err.(*ACMEOrderFailedError); !ok {
+		t.Fatalf("CustomIngressManagerReconciler.EnsureACMECertificate() error = %v, want ACMEOrderFailedError", err)
+	}
+
+	var order corev1.Secret
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-acme-order", Namespace: "default"}, &order); err == nil {
+		t.Errorf("failed acme order secret left behind")
+	}
+}
+
+// fakeACMEServer is a minimal RFC 8555 server with a single order of a single authorization.
+// Request signatures are not verified. The challenge is valid once accepted.
+type fakeACMEServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu            sync.Mutex
+	registrations int
+	orders        []map[string]interface{}
+	orderStatus   string
+	authzStatus   string
+	chalStatus    string
+	certPEM       []byte
+}
+
+func newFakeACMEServer(t *testing.T) *fakeACMEServer {
+	s := &fakeACMEServer{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+func (s *fakeACMEServer) accepted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.chalStatus == acme.StatusValid
+}
+
+func (s *fakeACMEServer) fail() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orderStatus = acme.StatusInvalid
+}
+
+func (s *fakeACMEServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Replay-Nonce", "nonce")
+	if req.URL.Path == "/dir" {
+		s.json(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+		})
+
+		return
+	}
+	if req.URL.Path == "/nonce" {
+		return
+	}
+
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&jws); err != nil {
+		s.t.Errorf("%s: %v", req.URL.Path, err)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	switch req.URL.Path {
+	case "/account":
+		var account struct {
+			OnlyReturnExisting bool `json:"onlyReturnExisting"`
+		}
+		_ = json.Unmarshal(payload, &account)
+		status := http.StatusOK
+		if !account.OnlyReturnExisting {
+			s.registrations++
+			status = http.StatusCreated
+		}
+		w.Header().Set("Location", s.URL+"/account/1")
+		s.json(w, status, map[string]interface{}{"status": acme.StatusValid})
+	case "/order":
+		var order map[string]interface{}
+		if err := json.Unmarshal(payload, &order); err != nil {
+			s.t.Errorf("order payload %s: %v", payload, err)
+		}
+		s.orders = append(s.orders, order)
+		s.orderStatus, s.authzStatus, s.chalStatus = acme.StatusPending, acme.StatusPending, acme.StatusPending
+		w.Header().Set("Location", s.URL+"/order/1")
+		s.json(w, http.StatusCreated, s.order())
+	case "/order/1":
+		s.json(w, http.StatusOK, s.order())
+	case "/authz/1":
+		s.json(w, http.StatusOK, map[string]interface{}{
+			"status":     s.authzStatus,
+			"identifier": map[string]string{"type": "dns", "value": "testsvc.com"},
+			"challenges": []interface{}{s.challenge()},
+		})
+	case "/challenge/1":
+		s.orderStatus, s.authzStatus, s.chalStatus = acme.StatusReady, acme.StatusValid, acme.StatusValid
+		s.json(w, http.StatusOK, s.challenge())
+	case "/finalize/1":
+		var finalize struct {
+			CSR string `json:"csr"`
+		}
+		if err := json.Unmarshal(payload, &finalize); err != nil {
+			s.t.Errorf("finalize payload %s: %v", payload, err)
+		}
+		der, _ := base64.RawURLEncoding.DecodeString(finalize.CSR)
+		s.certPEM = s.sign(der)
+		s.orderStatus = acme.StatusValid
+		s.json(w, http.StatusOK, s.order())
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(s.certPEM)
+	default:
+		s.json(w, http.StatusNotFound, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+	}
+}
+
+func (s *fakeACMEServer) order() map[string]interface{} {
+	order := map[string]interface{}{
+		"status":         s.orderStatus,
+		"identifiers":    []map[string]string{{"type": "dns", "value": "testsvc.com"}},
+		"authorizations": []string{s.URL + "/authz/1"},
+		"finalize":       s.URL + "/finalize/1",
+	}
+	if s.orderStatus == acme.StatusValid {
+		order["certificate"] = s.URL + "/cert/1"
+	}
+
+	return order
+}
+
+func (s *fakeACMEServer) challenge() map[string]string {
+	return map[string]string{"type": "http-01", "url": s.URL + "/challenge/1", "token": "token", "status": s.chalStatus}
+}
+
+// sign returns a self-signed certificate for the names of the CSR.
+func (s *fakeACMEServer) sign(der []byte) []byte {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.t.Errorf("finalize csr: %v", err)
+
+		return nil
+	}
+
+	key, _, _ := GeneratePrivateKey(config.CertificateOptions{KeyAlgorithm: "ecdsa"})
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, key)
+	if err != nil {
+		s.t.Errorf("sign csr: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+}
+
+func (s *fakeACMEServer) json(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,434 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+const (
+	// CAARequeue is the interval for re-checking a domain whose CAA records do not authorize the
+	// ACME server.
+	CAARequeue = 10 * time.Minute
+	// CAALookupTimeout bounds a CAA query.
+	CAALookupTimeout = 5 * time.Second
+	// CAAUDPSize is the UDP payload size advertised with EDNS0, larger responses are truncated
+	// and queried again over TCP.
+	CAAUDPSize = 4096
+
+	// CAAConditionAnnotation records the ServiceConditionCAAAuthorized condition of a service
+	// whose ACME order is held by the CAA check.
+	CAAConditionAnnotation = "feladat.banzaicloud.io/caa-condition"
+	// ServiceConditionCAAAuthorized tells whether the CAA records of the domain authorize the
+	// ACME server.
+	ServiceConditionCAAAuthorized = "CAAAuthorized"
+
+	typeCAA = dnsmessage.Type(257)
+)
+
+var (
+	errShortDNSMessage     = errors.New("short DNS message")
+	errTruncatedDNSMessage = errors.New("truncated response")
+)
+
+// CAARecord is a CAA resource record (RFC 8659).
+type CAARecord struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+func (record CAARecord) String() string {
+	return fmt.Sprintf("%d %s %q", record.Flag, record.Tag, record.Value)
+}
+
+// CAAResolver looks up the CAA records of a name. Names without records, or not existing at
+// all, have none.
+type CAAResolver interface {
+	LookupCAA(ctx context.Context, name string) ([]CAARecord, error)
+}
+
+// DNSCAAResolver queries the CAA records from the recursive DNS server at Address over UDP, and
+// over TCP if the response is truncated.
+type DNSCAAResolver struct {
+	Address string
+}
+
+// NewCAAResolver returns a resolver querying the DNS server at address, the first name server of
+// /etc/resolv.conf if address is empty.
+func NewCAAResolver(address string) *DNSCAAResolver {
+	if address == "" {
+		address = systemNameserver()
+	}
+
+	return &DNSCAAResolver{Address: address}
+}
+
+func (d *DNSCAAResolver) LookupCAA(ctx context.Context, name string) ([]CAARecord, error) {
+	fqdn, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+
+	// the OPT record lets the server answer with more than 512 bytes over UDP
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(CAAUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+
+	id := uint16(rand.Uint32())
+	query := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions:   []dnsmessage.Question{{Name: fqdn, Type: typeCAA, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, CAALookupTimeout)
+	defer cancel()
+
+	records, err := d.exchange(ctx, "udp", packed, id)
+	if err == errTruncatedDNSMessage {
+		records, err = d.exchange(ctx, "tcp", packed, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CAA lookup of %s: %v", name, err)
+	}
+
+	return records, nil
+}
+
+// exchange sends the query to the DNS server over the network, udp or tcp, and parses the CAA
+// records of the response.
+func (d *DNSCAAResolver) exchange(ctx context.Context, network string, query []byte, id uint16) ([]CAARecord, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, d.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, CAAUDPSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseCAAResponse(buf[:n], id)
+	}
+
+	// messages over TCP are prefixed with their length
+	if _, err := conn.Write(append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	return ParseCAAResponse(buf, id)
+}
+
+// ParseCAAResponse returns the CAA records in the answer section of the DNS response to the query
+// with the ID. dnsmessage can not unpack CAA records, so the answers are walked by hand.
+func ParseCAAResponse(msg []byte, id uint16) ([]CAARecord, error) {
+	if len(msg) < 12 {
+		return nil, errShortDNSMessage
+	}
+
+	if binary.BigEndian.Uint16(msg) != id {
+		return nil, errors.New("response ID does not match the query")
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&(1<<9) != 0 {
+		return nil, errTruncatedDNSMessage
+	}
+
+	switch rcode := dnsmessage.RCode(flags & 0xf); rcode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("DNS server answered %v", rcode)
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		// type and class
+		off += 4
+	}
+
+	var records []CAARecord
+	for i := 0; i < answers; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errShortDNSMessage
+		}
+
+		// type, class, TTL and data length
+		recordType := dnsmessage.Type(binary.BigEndian.Uint16(msg[off:]))
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, errShortDNSMessage
+		}
+
+		// CNAMEs followed by the server precede the records of the target
+		if recordType == typeCAA {
+			data := msg[off : off+length]
+			if len(data) < 2 || 2+int(data[1]) > len(data) {
+				return nil, errors.New("malformed CAA record")
+			}
+
+			tagEnd := 2 + int(data[1])
+			records = append(records, CAARecord{Flag: data[0], Tag: string(data[2:tagEnd]), Value: string(data[tagEnd:])})
+		}
+		off += length
+	}
+
+	return records, nil
+}
+
+// skipDNSName returns the offset after the possibly compressed name starting at off.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errShortDNSMessage
+		}
+
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return 0, errShortDNSMessage
+			}
+
+			return off + 2, nil
+		default:
+			off += 1 + length
+		}
+	}
+}
+
+// systemNameserver returns the first name server of /etc/resolv.conf, the local one if there is
+// none.
+func systemNameserver() string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err == nil {
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 1 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+
+	return "127.0.0.1:53"
+}
+
+// RelevantCAA returns the CAA records deciding on certificates for the domain and the name they
+// belong to: the records of the domain or of its closest ancestor having any (RFC 8659 section 3).
+// No records mean any CA may issue.
+func RelevantCAA(ctx context.Context, resolver CAAResolver, domain string) (string, []CAARecord, error) {
+	name := strings.TrimSuffix(strings.ToLower(domain), ".")
+	for name != "" {
+		records, err := resolver.LookupCAA(ctx, name)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(records) > 0 {
+			return name, records, nil
+		}
+
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		} else {
+			name = ""
+		}
+	}
+
+	return "", nil, nil
+}
+
+// CAAAuthorizes reports whether the CAA records allow a CA with one of the issuer domains to
+// issue a certificate for a name that is not a wildcard. Unknown properties flagged critical
+// forbid issuance.
+func CAAAuthorizes(records []CAARecord, identities []string) bool {
+	var issuers []string
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issuers = append(issuers, strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0]))
+		case "issuewild", "iodef":
+		default:
+			if record.Flag&128 != 0 {
+				return false
+			}
+		}
+	}
+
+	if issuers == nil {
+		return true
+	}
+
+	for _, issuer := range issuers {
+		for _, identity := range identities {
+			if strings.EqualFold(issuer, identity) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *CustomIngressManagerReconciler) caaResolver() CAAResolver {
+	if r.CAAResolver != nil {
+		return r.CAAResolver
+	}
+
+	return NewCAAResolver(r.config().DNSResolver)
+}
+
+// WaitForCAA reports whether the ACME order of the service has to wait because the CAA records
+// of its domain do not authorize the ACME server, or could not be looked up. The reason is told
+// with a Warning event and recorded in the CAAAuthorized condition of the service.
+func (r *CustomIngressManagerReconciler) WaitForCAA(service corev1.Service, policy *webappv1.CustomIngressManager) (bool, error) {
+	cfg := r.config()
+
+	if !cfg.CAACheck || cfg.IssuanceMode(&service, policy) != config.IssuanceModeACME {
+		return false, r.setCAACondition(service, "", "")
+	}
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	name, records, err := RelevantCAA(context.Background(), r.caaResolver(), domain)
+	if err != nil {
+		// the ACME server would refuse the order as well, a SERVFAIL is not taken as no records
+		message := "the ACME order is held until the CAA records of " + config.DisplayDomain(domain) + " can be looked up: " + err.Error()
+		r.Log.Info(message)
+		if r.Recorder != nil {
+			r.Recorder.Event(&service, corev1.EventTypeWarning, "CAALookupFailed", message)
+		}
+
+		return true, r.setCAACondition(service, "CAALookupFailed", message)
+	}
+
+	if CAAAuthorizes(records, cfg.CAAIdentityList()) {
+		return false, r.setCAACondition(service, "", "")
+	}
+
+	values := make([]string, 0, len(records))
+	for _, record := range records {
+		values = append(values, record.String())
+	}
+
+	message := fmt.Sprintf("CAA records of %s do not authorize %s: %s", config.DisplayDomain(name), cfg.CAAIdentities, strings.Join(values, ", "))
+	r.Log.Info(message)
+	if r.Recorder != nil {
+		r.Recorder.Event(&service, corev1.EventTypeWarning, "CAAForbidsIssuance", message)
+	}
+
+	return true, r.setCAACondition(service, "CAAForbidsIssuance", message)
+}
+
+// setCAACondition records the false CAAAuthorized condition with the reason on the service, an
+// empty reason removes it. The annotation is patched, the service may be a promoted copy.
+func (r *CustomIngressManagerReconciler) setCAACondition(service corev1.Service, reason, message string) error {
+	existing, found := service.ObjectMeta.Annotations[CAAConditionAnnotation]
+
+	var value interface{}
+	if reason != "" {
+		condition := ServiceCondition{
+			Type:               ServiceConditionCAAAuthorized,
+			Status:             corev1.ConditionFalse,
+			Reason:             reason,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+		}
+
+		var previous ServiceCondition
+		if json.Unmarshal([]byte(existing), &previous) == nil && previous.Reason == reason {
+			if previous.Message == message {
+				return nil
+			}
+			condition.LastTransitionTime = previous.LastTransitionTime
+		}
+
+		encoded, err := json.Marshal(condition)
+		if err != nil {
+			return err
+		}
+		value = string(encoded)
+	} else if !found {
+		return nil
+	}
+
+	return r.patchServiceAnnotation(service, CAAConditionAnnotation, value)
+}
@@ -0,0 +1,305 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+// mapCAAResolver serves CAA records from a map.
+type mapCAAResolver map[string][]CAARecord
+
+func (m mapCAAResolver) LookupCAA(ctx context.Context, name string) ([]CAARecord, error) {
+	return m[name], nil
+}
+
+// failingCAAResolver fails every lookup, like a server answering SERVFAIL.
+type failingCAAResolver struct{}
+
+func (failingCAAResolver) LookupCAA(ctx context.Context, name string) ([]CAARecord, error) {
+	return nil, errors.New("DNS server answered RCodeServerFailure")
+}
+
+// caaResponse builds the response to a CAA query of shop.example.com answering with the records
+// and an A record in between.
+func caaResponse(id uint16, rcode byte, records ...CAARecord) []byte {
+	msg := []byte{byte(id >> 8), byte(id), 0x81, 0x80 | rcode, 0, 1, 0, byte(len(records) + 1), 0, 0, 0, 0}
+	msg = append(msg, "\x04shop\x07example\x03com\x00\x01\x01\x00\x01"...)
+	msg = append(msg, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 203, 0, 113, 10)
+	for _, record := range records {
+		data := append([]byte{record.Flag, byte(len(record.Tag))}, record.Tag+record.Value...)
+		msg = append(msg, 0xc0, 0x0c, 0x01, 0x01, 0, 1, 0, 0, 0, 60, 0, byte(len(data)))
+		msg = append(msg, data...)
+	}
+
+	return msg
+}
+
+// truncated sets the TC flag of the response.
+func truncated(msg []byte) []byte {
+	msg[2] |= 0x02
+
+	return msg
+}
+
+func TestDNSCAAResolver_LookupCAA(t *testing.T) {
+	records := []CAARecord{{Flag: 0, Tag: "issue", Value: "letsencrypt.org"}}
+
+	// the UDP answer is truncated, the records are only served over TCP on the same port
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		t.Skipf("UDP port of %s is taken: %v", tcp.Addr(), err)
+	}
+	defer udp.Close()
+
+	edns := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// one OPT record in the additional section
+		edns <- n > 12 && binary.BigEndian.Uint16(buf[10:]) == 1
+		udp.WriteTo(truncated(caaResponse(binary.BigEndian.Uint16(buf), 0)), addr)
+	}()
+	go func() {
+		conn, err := tcp.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		response := caaResponse(binary.BigEndian.Uint16(query), 0, records...)
+		conn.Write(append([]byte{byte(len(response) >> 8), byte(len(response))}, response...))
+	}()
+
+	got, err := NewCAAResolver(tcp.Addr().String()).LookupCAA(context.Background(), "shop.example.com")
+	if err != nil {
+		t.Fatalf("DNSCAAResolver.LookupCAA() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("DNSCAAResolver.LookupCAA() = %v, want %v", got, records)
+	}
+	if !<-edns {
+		t.Errorf("DNSCAAResolver.LookupCAA() sent no EDNS0 OPT record")
+	}
+}
+
+func TestParseCAAResponse(t *testing.T) {
+	records := []CAARecord{
+		{Flag: 0, Tag: "issue", Value: "letsencrypt.org"},
+		{Flag: 128, Tag: "iodef", Value: "mailto:security@example.com"},
+	}
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    []CAARecord
+		wantErr bool
+	}{
+		{name: "Records", msg: caaResponse(42, 0, records...), want: records},
+		{name: "NoRecords", msg: caaResponse(42, 0)},
+		{name: "NameError", msg: caaResponse(42, 3)},
+		{name: "ServerFailure", msg: caaResponse(42, 2), wantErr: true},
+		{name: "Truncated", msg: truncated(caaResponse(42, 0)), wantErr: true},
+		{name: "OtherID", msg: caaResponse(7, 0, records...), wantErr: true},
+		{name: "Short", msg: caaResponse(42, 0, records...)[:40], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCAAResponse(tt.msg, 42)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCAAResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCAAResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCAAAuthorizes(t *testing.T) {
+	identities := []string{"letsencrypt.org"}
+
+	tests := []struct {
+		name    string
+		records []CAARecord
+		want    bool
+	}{
+		{name: "NoRecords", want: true},
+		{name: "OnlyIodef", records: []CAARecord{{Tag: "iodef", Value: "mailto:security@example.com"}}, want: true},
+		{name: "Authorized", records: []CAARecord{{Tag: "issue", Value: "LetsEncrypt.org; validationmethods=http-01"}}, want: true},
+		{name: "OtherCA", records: []CAARecord{{Tag: "issue", Value: "pki.goog"}}, want: false},
+		{name: "NoCA", records: []CAARecord{{Tag: "issue", Value: ";"}}, want: false},
+		{name: "OneOfSeveral", records: []CAARecord{{Tag: "issue", Value: "pki.goog"}, {Tag: "issue", Value: "letsencrypt.org"}}, want: true},
+		{name: "OnlyWildcards", records: []CAARecord{{Tag: "issuewild", Value: "pki.goog"}}, want: true},
+		{name: "UnknownCritical", records: []CAARecord{{Tag: "issue", Value: "letsencrypt.org"}, {Flag: 128, Tag: "tbs", Value: "x"}}, want: false},
+		{name: "UnknownNotCritical", records: []CAARecord{{Tag: "issue", Value: "letsencrypt.org"}, {Tag: "tbs", Value: "x"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CAAAuthorizes(tt.records, identities); got != tt.want {
+				t.Errorf("CAAAuthorizes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_WaitForCAA(t *testing.T) {
+	InitTestScheme()
+
+	resolver := mapCAAResolver{
+		"example.com":      {{Tag: "issue", Value: "pki.goog"}},
+		"shop.example.com": {{Tag: "issue", Value: "letsencrypt.org"}},
+	}
+
+	tests := []struct {
+		name        string
+		domain      string
+		environment string
+		caaCheck    bool
+		want        bool
+	}{
+		{name: "Authorized", domain: "shop.example.com", environment: "production", caaCheck: true, want: false},
+		{name: "InheritedForbidden", domain: "blog.example.com", environment: "production", caaCheck: true, want: true},
+		{name: "NoRecords", domain: "docs.example.org", environment: "production", caaCheck: true, want: false},
+		{name: "CheckDisabled", domain: "blog.example.com", environment: "production", want: false},
+		{name: "SelfSigned", domain: "blog.example.com", environment: "selfsigned", caaCheck: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "testsvc",
+					Namespace:   "default",
+					Annotations: map[string]string{"feladat.banzaicloud.io/domain": tt.domain, "feladat.banzaicloud.io/email": "tes@test.com"},
+					Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": tt.environment},
+				},
+			}
+
+			cfg := config.DefaultConfig()
+			cfg.CAACheck = tt.caaCheck
+			r := &CustomIngressManagerReconciler{
+				Client:      clientFaker.NewFakeClientWithScheme(testScheme),
+				Log:         ctrl.Log.WithName("customingressmanager"),
+				Scheme:      testScheme,
+				Config:      cfg,
+				CAAResolver: resolver,
+			}
+
+			got, err := r.WaitForCAA(service, nil)
+			if err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.WaitForCAA() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.WaitForCAA() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_WaitForCAA_LookupFailure(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "shop.example.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "production"},
+		},
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.CAACheck = true
+	c := clientFaker.NewFakeClientWithScheme(testScheme, service.DeepCopy())
+	r := &CustomIngressManagerReconciler{
+		Client:      c,
+		Log:         ctrl.Log.WithName("customingressmanager"),
+		Scheme:      testScheme,
+		Config:      cfg,
+		CAAResolver: failingCAAResolver{},
+	}
+	getService := func() corev1.Service {
+		var current corev1.Service
+		if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc", Namespace: "default"}, &current); err != nil {
+			t.Fatal(err)
+		}
+
+		return current
+	}
+
+	// a failed lookup holds the order and is recorded on the service
+	if got, err := r.WaitForCAA(service, nil); err != nil || !got {
+		t.Fatalf("CustomIngressManagerReconciler.WaitForCAA() = %v, %v, want true", got, err)
+	}
+	current := getService()
+	var condition ServiceCondition
+	if err := json.Unmarshal([]byte(current.Annotations[CAAConditionAnnotation]), &condition); err != nil {
+		t.Fatalf("CAA condition annotation = %q: %v", current.Annotations[CAAConditionAnnotation], err)
+	}
+	if condition.Type != ServiceConditionCAAAuthorized || condition.Status != corev1.ConditionFalse || condition.Reason != "CAALookupFailed" {
+		t.Errorf("CAA condition = %+v, want CAAAuthorized False CAALookupFailed", condition)
+	}
+
+	status, err := serviceStatus(context.Background(), c, cfg, &current, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Conditions) != 1 || status.Conditions[0].Reason != "CAALookupFailed" {
+		t.Errorf("serviceStatus() conditions = %+v, want the CAA condition", status.Conditions)
+	}
+
+	// a successful lookup removes it
+	r.CAAResolver = mapCAAResolver{}
+	if got, err := r.WaitForCAA(current, nil); err != nil || got {
+		t.Fatalf("CustomIngressManagerReconciler.WaitForCAA() = %v, %v, want false", got, err)
+	}
+	if value, ok := getService().Annotations[CAAConditionAnnotation]; ok {
+		t.Errorf("CAA condition annotation = %q, want it removed", value)
+	}
+}
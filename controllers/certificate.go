@@ -0,0 +1,141 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+func (r *CustomIngressManagerReconciler) GetCertificateByName(certificateName, namespace string) (*v1alpha3.Certificate, error) {
+	ctx := context.Background()
+	certificate := v1alpha3.Certificate{}
+	namespacedName := types.NamespacedName{
+		Name:      certificateName,
+		Namespace: namespace,
+	}
+
+	if err := r.Get(ctx, namespacedName, &certificate); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	r.Log.Info("certificate already there")
+
+	return &certificate, nil
+}
+
+func (r *CustomIngressManagerReconciler) CreateOrUpdateCertificateForService(service corev1.Service, policy *webappv1.CustomIngressManager, existingCertificate *v1alpha3.Certificate) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	certificate := builder.Certificate(cfg, service, policy)
+
+	if existingCertificate != nil {
+		desiredCertificate := existingCertificate.DeepCopy()
+		desiredCertificate.Labels = MergeLabels(existingCertificate.Labels, certificate.Labels)
+		desiredCertificate.OwnerReferences = certificate.OwnerReferences
+		desiredCertificate.Spec = certificate.Spec
+		if policy, ok := certificate.Annotations[config.RotationPolicyAnnotation]; ok {
+			if desiredCertificate.Annotations == nil {
+				desiredCertificate.Annotations = map[string]string{}
+			}
+			desiredCertificate.Annotations[config.RotationPolicyAnnotation] = policy
+		} else {
+			delete(desiredCertificate.Annotations, config.RotationPolicyAnnotation)
+		}
+
+		if !reflect.DeepEqual(existingCertificate, desiredCertificate) {
+			r.Log.Info("updating Certificate")
+			if err := r.Update(ctx, desiredCertificate); err != nil {
+				r.Log.Error(err, "unable to update the Certificate")
+
+				return client.IgnoreNotFound(err)
+			}
+		}
+
+		return nil
+	}
+
+	r.Log.Info("try to create Certificate")
+	if err := r.Create(ctx, &certificate); err != nil {
+		r.Log.Error(err, "unable to create the Certificate")
+
+		return client.IgnoreNotFound(err)
+	}
+
+	r.Log.Info("certificate created")
+
+	return nil
+}
+
+// DeleteCertificateForService deletes the managed Certificates of the service, if any.
+func (r *CustomIngressManagerReconciler) DeleteCertificateForService(serviceName types.NamespacedName) error {
+	return r.deleteCertificates(serviceName, types.NamespacedName{})
+}
+
+// DeleteStaleCertificatesForService deletes the managed Certificates of the service other than
+// the current one, left in another namespace after a policy change.
+func (r *CustomIngressManagerReconciler) DeleteStaleCertificatesForService(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	current := types.NamespacedName{
+		Name:      builder.CertificateName(r.config(), service, policy),
+		Namespace: builder.CertificateNamespace(service, policy),
+	}
+
+	return r.deleteCertificates(types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, current)
+}
+
+func (r *CustomIngressManagerReconciler) deleteCertificates(serviceName types.NamespacedName, keep types.NamespacedName) error {
+	ctx := context.Background()
+
+	// the certificate of a service exposed through Istio is in the namespace of the gateway
+	var certificates v1alpha3.CertificateList
+	if err := r.List(ctx, &certificates, client.MatchingLabels{
+		config.ManagedByLabel:        config.ManagedByLabelValue,
+		config.ServiceNameLabel:      serviceName.Name,
+		config.ServiceNamespaceLabel: serviceName.Namespace,
+	}); err != nil {
+		return err
+	}
+
+	for i := range certificates.Items {
+		certificate := &certificates.Items[i]
+		if (types.NamespacedName{Name: certificate.Name, Namespace: certificate.Namespace}) == keep {
+			continue
+		}
+
+		r.Log.Info("deleting existing certificate " + certificate.Namespace + "/" + certificate.Name)
+		if err := r.Delete(ctx, certificate); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
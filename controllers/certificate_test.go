@@ -0,0 +1,209 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestCustomIngressManagerReconciler_CreateOrUpdateCertificateForService(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+	cfg := config.DefaultConfig()
+	cfg.CertificateDuration = &metav1.Duration{Duration: 24 * time.Hour}
+	cfg.CertificateRenewBefore = &metav1.Duration{Duration: 8 * time.Hour}
+	cfg.CertificateKeyAlgorithm = "ecdsa"
+	cfg.CertificateKeySize = 256
+
+	wantSpec := v1alpha3.CertificateSpec{
+		CommonName:   "test.com",
+		DNSNames:     []string{"test.com"},
+		SecretName:   "testsvc-tls",
+		Duration:     &metav1.Duration{Duration: 24 * time.Hour},
+		RenewBefore:  &metav1.Duration{Duration: 8 * time.Hour},
+		KeyAlgorithm: v1alpha3.ECDSAKeyAlgorithm,
+		KeySize:      256,
+		IssuerRef: cmeta1.ObjectReference{
+			Name: "testsvc-lets-encrypt-staging",
+			Kind: "ClusterIssuer",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+	}{
+		{
+			name:    "Create",
+			objects: []runtime.Object{},
+		},
+		{
+			name: "Update",
+			objects: []runtime.Object{&v1alpha3.Certificate{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "testsvc-certificate",
+					Namespace: "default",
+					Labels:    map[string]string{"other": "label"},
+				},
+				Spec: v1alpha3.CertificateSpec{
+					SecretName: "old",
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clientFaker.NewFakeClientWithScheme(testScheme, tt.objects...)
+			r := &CustomIngressManagerReconciler{
+				Client: c,
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: testScheme,
+				Config: cfg,
+			}
+
+			existing, err := r.GetCertificateByName("testsvc-certificate", "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.CreateOrUpdateCertificateForService(service, nil, existing); err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.CreateOrUpdateCertificateForService() error = %v", err)
+			}
+
+			var got v1alpha3.Certificate
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-certificate", Namespace: "default"}, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Spec, wantSpec) {
+				t.Errorf("certificate spec = %+v, want %+v", got.Spec, wantSpec)
+			}
+			if !IsManagedByUs(got.ObjectMeta) {
+				t.Errorf("certificate is not labelled as managed: %v", got.Labels)
+			}
+		})
+	}
+}
+
+func TestConfig_CertificateOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      string
+		annotations  map[string]string
+		want         config.CertificateOptions
+		wantProblems bool
+	}{
+		{
+			name:        "Defaults",
+			annotations: map[string]string{},
+			want:        config.CertificateOptions{KeyAlgorithm: "rsa", KeySize: 4096},
+		},
+		{
+			name:        "ECDSA",
+			annotations: map[string]string{config.KeyAlgorithmAnnotation: "ECDSA"},
+			want:        config.CertificateOptions{KeyAlgorithm: "ecdsa"},
+		},
+		{
+			name: "ECDSAWithSizeAndDurations",
+			annotations: map[string]string{
+				config.KeyAlgorithmAnnotation: "ecdsa",
+				config.KeySizeAnnotation:      "384",
+				config.DurationAnnotation:     "720h",
+				config.RenewBeforeAnnotation:  "240h",
+			},
+			want: config.CertificateOptions{
+				KeyAlgorithm: "ecdsa",
+				KeySize:      384,
+				Duration:     &metav1.Duration{Duration: 720 * time.Hour},
+				RenewBefore:  &metav1.Duration{Duration: 240 * time.Hour},
+			},
+		},
+		{
+			name:         "InvalidKeySize",
+			annotations:  map[string]string{config.KeySizeAnnotation: "big"},
+			wantProblems: true,
+		},
+		{
+			name:         "InvalidDuration",
+			annotations:  map[string]string{config.DurationAnnotation: "90 days"},
+			wantProblems: true,
+		},
+		{
+			name:         "RenewBeforeLongerThanDuration",
+			annotations:  map[string]string{config.DurationAnnotation: "24h", config.RenewBeforeAnnotation: "48h"},
+			wantProblems: true,
+		},
+		{
+			name:        "RotationPolicy",
+			annotations: map[string]string{config.RotationPolicyAnnotation: "Always"},
+			want:        config.CertificateOptions{KeyAlgorithm: "rsa", KeySize: 4096, RotationPolicy: "Always"},
+		},
+		{
+			name:         "InvalidRotationPolicy",
+			annotations:  map[string]string{config.RotationPolicyAnnotation: "always"},
+			wantProblems: true,
+		},
+		{
+			name:        "Ed25519V1",
+			version:     config.CertManagerAPIVersionV1,
+			annotations: map[string]string{config.KeyAlgorithmAnnotation: "Ed25519"},
+			want:        config.CertificateOptions{KeyAlgorithm: "ed25519"},
+		},
+		{
+			name:         "Ed25519V1Alpha3",
+			version:      config.CertManagerAPIVersionV1Alpha3,
+			annotations:  map[string]string{config.KeyAlgorithmAnnotation: "Ed25519"},
+			wantProblems: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config.DefaultConfig()
+			c.CertificateKeyAlgorithm = "rsa"
+			c.CertificateKeySize = 4096
+			c.CertManagerAPIVersion = tt.version
+
+			got, problems := c.CertificateOptions(tt.annotations)
+			if (len(problems) > 0) != tt.wantProblems {
+				t.Fatalf("Config.CertificateOptions() problems = %v, wantProblems %v", problems, tt.wantProblems)
+			}
+			if !tt.wantProblems && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.CertificateOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
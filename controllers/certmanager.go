@@ -0,0 +1,250 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// DiscoverCertManagerAPIVersion returns the newest cert-manager API version served by the cluster.
+func DiscoverCertManagerAPIVersion(dc discovery.ServerGroupsInterface) (string, error) {
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return "", err
+	}
+
+	for _, group := range groups.Groups {
+		if group.Name != v1alpha3.SchemeGroupVersion.Group {
+			continue
+		}
+
+		served := map[string]bool{}
+		for _, version := range group.Versions {
+			served[version.Version] = true
+		}
+		for _, version := range []string{config.CertManagerAPIVersionV1, config.CertManagerAPIVersionV1Alpha3} {
+			if served[version] {
+				return version, nil
+			}
+		}
+
+		return "", fmt.Errorf("cert-manager serves none of the supported API versions v1 and v1alpha3")
+	}
+
+	return "", fmt.Errorf("the %s API group is not served, is cert-manager installed?", v1alpha3.SchemeGroupVersion.Group)
+}
+
+// CertManagerClient translates the v1alpha3 cert-manager objects used by the reconciler to the
+// cert-manager API version served by the cluster. Other objects pass through unchanged.
+type CertManagerClient struct {
+	client.Client
+	Scheme  *runtime.Scheme
+	Version string
+}
+
+// NewCertManagerClient returns c if the cluster serves v1alpha3, and a CertManagerClient
+// wrapping c otherwise.
+func NewCertManagerClient(c client.Client, scheme *runtime.Scheme, version string) client.Client {
+	if version == "" || version == config.CertManagerAPIVersionV1Alpha3 {
+		return c
+	}
+
+	return &CertManagerClient{Client: c, Scheme: scheme, Version: version}
+}
+
+// CertManagerObjectType returns the object to watch for the kind in the cert-manager API version.
+func CertManagerObjectType(obj runtime.Object, version string) runtime.Object {
+	kind, ok := builder.CertManagerKind(obj)
+	if !ok || version == "" || version == config.CertManagerAPIVersionV1Alpha3 {
+		return obj
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(builder.CertManagerGroupVersion(version).WithKind(kind))
+
+	return u
+}
+
+func (c *CertManagerClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	kind, ok := builder.CertManagerKind(obj)
+	if !ok {
+		return c.Client.Get(ctx, key, obj)
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(builder.CertManagerGroupVersion(c.Version).WithKind(kind))
+	if err := c.Client.Get(ctx, key, u); err != nil {
+		return err
+	}
+
+	return builder.FromCertManagerVersion(u, obj)
+}
+
+func (c *CertManagerClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	kind, ok := builder.CertManagerKind(list)
+	if !ok {
+		return c.Client.List(ctx, list, opts...)
+	}
+
+	u := &unstructured.UnstructuredList{}
+	u.SetGroupVersionKind(builder.CertManagerGroupVersion(c.Version).WithKind(kind))
+	if err := c.Client.List(ctx, u, opts...); err != nil {
+		return err
+	}
+
+	itemKind := kind[:len(kind)-len("List")]
+	items := make([]runtime.Object, 0, len(u.Items))
+	for i := range u.Items {
+		item, err := c.Scheme.New(v1alpha3.SchemeGroupVersion.WithKind(itemKind))
+		if err != nil {
+			return err
+		}
+		if err := builder.FromCertManagerVersion(&u.Items[i], item); err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+
+	accessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion(u.GetResourceVersion())
+	accessor.SetContinue(u.GetContinue())
+
+	return meta.SetList(list, items)
+}
+
+func (c *CertManagerClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return c.write(ctx, obj, false, func(u *unstructured.Unstructured) error {
+		return c.Client.Create(ctx, u, opts...)
+	}, func() error {
+		return c.Client.Create(ctx, obj, opts...)
+	})
+}
+
+func (c *CertManagerClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return c.write(ctx, obj, true, func(u *unstructured.Unstructured) error {
+		return c.Client.Update(ctx, u, opts...)
+	}, func() error {
+		return c.Client.Update(ctx, obj, opts...)
+	})
+}
+
+// Patch sends the whole translated object as a merge patch for cert-manager objects, the patch
+// computed on the v1alpha3 object does not apply to other versions.
+func (c *CertManagerClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.write(ctx, obj, true, func(u *unstructured.Unstructured) error {
+		return c.Client.Patch(ctx, u, client.Merge, opts...)
+	}, func() error {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	})
+}
+
+func (c *CertManagerClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return c.write(ctx, obj, false, func(u *unstructured.Unstructured) error {
+		return c.Client.Delete(ctx, u, opts...)
+	}, func() error {
+		return c.Client.Delete(ctx, obj, opts...)
+	})
+}
+
+func (c *CertManagerClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	kind, ok := builder.CertManagerKind(obj)
+	if !ok {
+		return c.Client.DeleteAllOf(ctx, obj, opts...)
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(builder.CertManagerGroupVersion(c.Version).WithKind(kind))
+
+	return c.Client.DeleteAllOf(ctx, u, opts...)
+}
+
+func (c *CertManagerClient) Status() client.StatusWriter {
+	return certManagerStatusWriter{c}
+}
+
+type certManagerStatusWriter struct {
+	c *CertManagerClient
+}
+
+func (w certManagerStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return w.c.write(ctx, obj, true, func(u *unstructured.Unstructured) error {
+		return w.c.Client.Status().Update(ctx, u, opts...)
+	}, func() error {
+		return w.c.Client.Status().Update(ctx, obj, opts...)
+	})
+}
+
+func (w certManagerStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return w.c.write(ctx, obj, true, func(u *unstructured.Unstructured) error {
+		return w.c.Client.Status().Patch(ctx, u, client.Merge, opts...)
+	}, func() error {
+		return w.c.Client.Status().Patch(ctx, obj, patch, opts...)
+	})
+}
+
+// write runs translated with the translated cert-manager object and copies the result back into
+// obj, or runs passthrough for other objects. With merge the translation is merged into the
+// stored object, so the fields v1alpha3 does not know survive the write.
+func (c *CertManagerClient) write(ctx context.Context, obj runtime.Object, merge bool, translated func(u *unstructured.Unstructured) error, passthrough func() error) error {
+	kind, ok := builder.CertManagerKind(obj)
+	if !ok {
+		return passthrough()
+	}
+
+	u, err := builder.ToCertManagerVersion(obj, c.Version)
+	if err != nil {
+		return err
+	}
+	if merge {
+		key, err := client.ObjectKeyFromObject(obj)
+		if err != nil {
+			return err
+		}
+
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(builder.CertManagerGroupVersion(c.Version).WithKind(kind))
+		err = c.Client.Get(ctx, key, existing)
+		switch {
+		case err == nil:
+			if u, err = builder.MergeCertManagerVersion(obj, existing); err != nil {
+				return err
+			}
+		case !errors.IsNotFound(err):
+			return err
+		}
+	}
+	if err := translated(u); err != nil {
+		return err
+	}
+
+	return builder.FromCertManagerVersion(u, obj)
+}
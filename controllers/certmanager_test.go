@@ -0,0 +1,158 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestDiscoverCertManagerAPIVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		groupVersions []string
+		want          string
+		wantErr       bool
+	}{
+		{name: "V1", groupVersions: []string{"cert-manager.io/v1alpha2", "cert-manager.io/v1alpha3", "cert-manager.io/v1"}, want: "v1"},
+		{name: "V1Alpha3", groupVersions: []string{"cert-manager.io/v1alpha2", "cert-manager.io/v1alpha3"}, want: "v1alpha3"},
+		{name: "Unsupported", groupVersions: []string{"cert-manager.io/v1alpha2"}, wantErr: true},
+		{name: "NotInstalled", groupVersions: []string{"apps/v1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &clienttesting.Fake{}
+			for _, groupVersion := range tt.groupVersions {
+				fake.Resources = append(fake.Resources, &metav1.APIResourceList{GroupVersion: groupVersion})
+			}
+
+			got, err := DiscoverCertManagerAPIVersion(&fakediscovery.FakeDiscovery{Fake: fake})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiscoverCertManagerAPIVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DiscoverCertManagerAPIVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertManagerClient(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+	cfg := config.DefaultConfig()
+	cfg.CertManagerAPIVersion = config.CertManagerAPIVersionV1
+	cfg.CertificateKeyAlgorithm = "ecdsa"
+	cfg.CertificateKeySize = 256
+
+	// the object tracker of the fake client needs the list kinds, the real client does not
+	testScheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "CertificateList"}, &unstructured.UnstructuredList{})
+
+	fakeClient := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: NewCertManagerClient(fakeClient, testScheme, cfg.CertManagerAPIVersion),
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+
+	if err := r.CreateOrUpdateCertificateForService(service, nil, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.CreateOrUpdateCertificateForService() error = %v", err)
+	}
+
+	stored := &unstructured.Unstructured{}
+	stored.SetAPIVersion("cert-manager.io/v1")
+	stored.SetKind("Certificate")
+	if err := fakeClient.Get(context.Background(), types.NamespacedName{Name: "testsvc-certificate", Namespace: "default"}, stored); err != nil {
+		t.Fatalf("v1 certificate not found: %v", err)
+	}
+	if algorithm, _, _ := unstructured.NestedString(stored.Object, "spec", "privateKey", "algorithm"); algorithm != "ECDSA" {
+		t.Errorf("v1 certificate spec.privateKey.algorithm = %q, want ECDSA", algorithm)
+	}
+	// a field unknown to v1alpha3 must survive the updates of the reconciler
+	if err := unstructured.SetNestedField(stored.Object, int64(3), "spec", "revisionHistoryLimit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeClient.Update(context.Background(), stored); err != nil {
+		t.Fatalf("fake client Update() error = %v", err)
+	}
+
+	existing, err := r.GetCertificateByName("testsvc-certificate", "default")
+	if err != nil || existing == nil {
+		t.Fatalf("CustomIngressManagerReconciler.GetCertificateByName() = %v, %v", existing, err)
+	}
+	if existing.Spec.KeyAlgorithm != v1alpha3.ECDSAKeyAlgorithm || existing.Spec.KeySize != 256 {
+		t.Errorf("certificate key = %s/%d, want ecdsa/256", existing.Spec.KeyAlgorithm, existing.Spec.KeySize)
+	}
+
+	cfg.CertificateDuration = &metav1.Duration{Duration: 48 * time.Hour}
+	cfg.CertificateRotationPolicy = config.RotationPolicyAlways
+	if err := r.CreateOrUpdateCertificateForService(service, nil, existing); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.CreateOrUpdateCertificateForService() error = %v", err)
+	}
+
+	if err := fakeClient.Get(context.Background(), types.NamespacedName{Name: "testsvc-certificate", Namespace: "default"}, stored); err != nil {
+		t.Fatalf("v1 certificate not found: %v", err)
+	}
+	if limit, _, _ := unstructured.NestedInt64(stored.Object, "spec", "revisionHistoryLimit"); limit != 3 {
+		t.Errorf("v1 certificate spec.revisionHistoryLimit = %d after update, want 3", limit)
+	}
+	if policy, _, _ := unstructured.NestedString(stored.Object, "spec", "privateKey", "rotationPolicy"); policy != config.RotationPolicyAlways {
+		t.Errorf("v1 certificate spec.privateKey.rotationPolicy = %q after update, want Always", policy)
+	}
+	if _, ok := stored.GetAnnotations()[config.RotationPolicyAnnotation]; ok {
+		t.Errorf("v1 certificate has the %s annotation", config.RotationPolicyAnnotation)
+	}
+
+	var certificates v1alpha3.CertificateList
+	if err := r.List(context.Background(), &certificates, client.InNamespace("default")); err != nil {
+		t.Fatalf("CertManagerClient.List() error = %v", err)
+	}
+	if len(certificates.Items) != 1 || certificates.Items[0].Spec.Duration == nil || certificates.Items[0].Spec.Duration.Duration != 48*time.Hour {
+		t.Fatalf("CertManagerClient.List() = %+v, want the updated certificate", certificates.Items)
+	}
+
+	if err := r.Delete(context.Background(), &certificates.Items[0]); err != nil {
+		t.Fatalf("CertManagerClient.Delete() error = %v", err)
+	}
+	if got, err := r.GetCertificateByName("testsvc-certificate", "default"); err != nil || got != nil {
+		t.Errorf("CustomIngressManagerReconciler.GetCertificateByName() after delete = %v, %v", got, err)
+	}
+}
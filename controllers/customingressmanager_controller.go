@@ -18,48 +18,95 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"reflect"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
-	isd "github.com/jbenet/go-is-domain"
 	"github.com/prometheus/common/log"
 
 	"github.com/go-logr/logr"
-	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha3"
 	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
-	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-)
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-const (
-	DomainAnnotation        = "domain"
-	EmailAnnotation         = "email"
-	CustomIngressLabel      = "feladat.banzaicloud.io/ingress"
-	CustomIngressLabelValue = "secure"
-	EnvironmentLabel        = "environment"
-	ClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
 )
 
+// ClusterIssuerConflictRequeue is the interval for re-checking a service whose cluster issuer
+// name is taken by a service in another namespace.
+const ClusterIssuerConflictRequeue = 5 * time.Minute
+
 // CustomIngressManagerReconciler reconciles a CustomIngressManager object
 type CustomIngressManagerReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// WatchNamespaces restricts the reconciler to the listed namespaces. Empty means all namespaces.
+	WatchNamespaces []string
+	// NamespaceSelector restricts the reconciler to namespaces with matching labels. Nil means all namespaces.
+	NamespaceSelector labels.Selector
+	// ClusterScopedReader is used to read cluster scoped objects (namespaces, cluster issuers).
+	// A multi-namespace cache can not serve them, so the manager passes its API reader in that case.
+	ClusterScopedReader client.Reader
+	// Config holds the label/annotation keys, ACME servers and name suffixes. Nil means DefaultConfig().
+	Config *config.Config
+	// Recorder emits events on the reconciled services. Events are skipped if nil.
+	Recorder record.EventRecorder
+	// ACMEHTTPClient is used by the built-in ACME client. Nil means http.DefaultClient.
+	ACMEHTTPClient *http.Client
+	// RouteKinds are the kinds of the unstructured route objects owned by the services, the
+	// ones served by the cluster are watched.
+	RouteKinds []schema.GroupVersionKind
+	// Resolver is used by the DNS preflight check. Nil means the configured DNS server.
+	Resolver Resolver
+	// CAAResolver looks up the CAA records of the domains. Nil means the configured DNS server.
+	CAAResolver CAAResolver
+	// APIReader reads the rate limit ledger, which lives outside the watched namespaces, without
+	// a cache. Nil means the client.
+	APIReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=webapp.feladat.banzaicloud.io,resources=customingressmanagers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=webapp.feladat.banzaicloud.io,resources=customingressmanagers/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups="",resources=services;ingresses;clusterissuers,verbs=get;list;create;update;delete,watch
-// +kubebuilder:rbac:groups=extensions;cert-manager.io,resources=services;ingresses;clusterissuers,verbs=get;list;create;update;watch
+// +kubebuilder:rbac:groups="",resources=services;ingresses;clusterissuers,verbs=get;list;create;update;delete;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=patch
+// +kubebuilder:rbac:groups=extensions;cert-manager.io,resources=services;ingresses;clusterissuers,verbs=get;list;create;update;watch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates/status,verbs=update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes;referencegrants,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups=networking.istio.io,resources=gateways;virtualservices,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=traefik.io;traefik.containo.us,resources=ingressroutes,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=route.openshift.io,resources=routes/custom-host,verbs=create;update
+// +kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;create;update;delete
 
 func (r *CustomIngressManagerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -67,64 +114,425 @@ func (r *CustomIngressManagerReconciler) Reconcile(req ctrl.Request) (ctrl.Resul
 
 	var service corev1.Service
 	if err := r.Get(ctx, req.NamespacedName, &service); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
 		log.Info("service not found: " + req.Name + " in " + req.Namespace + " namespace")
 
-		existingIngress, err := r.GetIngressByName(CreateIngressName(req.NamespacedName.Name), req.NamespacedName.Namespace)
-		if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, r.CleanupForService(req.NamespacedName)
+	}
+
+	watched, err := r.IsWatchedNamespace(service.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !watched {
+		return ctrl.Result{}, r.CleanupForService(req.NamespacedName)
+	}
+
+	policy, err := r.GetPolicyForService(&service)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !r.IsValidService(&service, policy) {
+		return ctrl.Result{}, r.CleanupForService(req.NamespacedName)
+	}
+
+	cfg := r.config()
+
+	// services exposed by earlier versions keep the certificate in their namespace wide secret
+	if pinned, err := PinLegacyTLSSecret(ctx, r, cfg, &service); err != nil {
+		return ctrl.Result{}, err
+	} else if pinned {
+		log.Info("pinning the legacy tls secret " + service.ObjectMeta.Annotations[config.TLSSecretAnnotation])
+		if err := r.Update(ctx, &service); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	if err := r.RecordUnicodeDomain(service); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// during the staging phase the certificate is issued for the service as if labelled staging
+	service, promoting, err := r.PromoteService(service, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if cfg.CertificateBackend == config.CertificateBackendACME {
+		if err := r.ExposeService(service, policy); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+
+		if err := r.PublishDNSForService(service, policy); err != nil {
 			return ctrl.Result{}, err
 		}
 
-		if existingIngress != nil {
-			log.Info("deleting existing ingress")
-			if err := r.Delete(ctx, existingIngress); err != nil {
-				return ctrl.Result{}, err
-			}
+		issued, err := r.HasProductionTLSSecret(service)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if waiting, err := r.WaitForDNS(service, policy, issued); err != nil {
+			return ctrl.Result{}, err
+		} else if waiting {
+			return ctrl.Result{RequeueAfter: cfg.PreflightInterval()}, nil
+		}
+
+		if r.IsDryRun() {
+			// issuing would create real orders at the ACME server
+			log.Info("dry run: skipping certificate issuance")
+
+			return ctrl.Result{}, nil
 		}
 
-		existingClusterIssuer, err := r.GetClusterIssuerByName(CreateClusterIssuerName(req.NamespacedName.Name))
-		if err != nil && !errors.IsNotFound(err) {
+		renewIn, err := r.EnsureACMECertificate(service, policy)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
 
-		if existingClusterIssuer != nil {
-			log.Info("deleting existing cluster issuer")
-			if err := r.Delete(ctx, existingClusterIssuer); err != nil {
-				return ctrl.Result{}, err
+		if builder.RouteOpenShift(policy) != nil {
+			// the Route holds a copy of the certificate
+			if err := r.ExposeService(service, policy); err != nil {
+				return ctrl.Result{}, client.IgnoreNotFound(err)
 			}
 		}
 
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		if promoting && renewIn > PromotionRequeue {
+			renewIn = PromotionRequeue
+		}
+
+		log.Info("certificate is renewed in " + renewIn.String())
+
+		return ctrl.Result{RequeueAfter: renewIn}, nil
 	}
 
-	if r.IsValidService(&service) {
-		log.Info("check if ingress already exists")
-		existingIngress, err := r.GetIngressByName(CreateIngressName(service.Name), service.ObjectMeta.Namespace)
-		if err != nil {
+	log.Info("check if clusterissuer already exists")
+	existingClusterIssuer, err := r.GetClusterIssuerByName(cfg.CreateClusterIssuerName(service.Name))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if existingClusterIssuer != nil && !IsClusterIssuerOfService(cfg, existingClusterIssuer, req.NamespacedName) {
+		message := "cluster issuer " + existingClusterIssuer.Name + " belongs to the service of the same name in another namespace"
+		log.Info(message)
+		if r.Recorder != nil {
+			r.Recorder.Event(&service, corev1.EventTypeWarning, "ClusterIssuerConflict", message)
+		}
+
+		return ctrl.Result{RequeueAfter: ClusterIssuerConflictRequeue}, nil
+	}
+
+	issued := existingClusterIssuer != nil && existingClusterIssuer.Spec.ACME != nil && existingClusterIssuer.Spec.ACME.Server == cfg.ACMEProductionURL
+	// an order is placed when the issuer is created or switched to another ACME server
+	ordered := existingClusterIssuer != nil && existingClusterIssuer.Spec.ACME != nil && existingClusterIssuer.Spec.ACME.Server == cfg.ACMEServerURL(service.ObjectMeta.Labels[cfg.EnvironmentLabel])
+
+	waiting, delay := false, CAARequeue
+	if !ordered {
+		if waiting, err = r.WaitForCAA(service, policy); err != nil {
 			return ctrl.Result{}, err
 		}
+	}
 
-		log.Info("check if clusterissuer already exists")
-		existingClusterIssuer, err := r.GetClusterIssuerByName(CreateClusterIssuerName(service.Name))
-		if err != nil {
+	if !waiting {
+		delay = cfg.PreflightInterval()
+		if waiting, err = r.WaitForDNS(service, policy, issued); err != nil {
 			return ctrl.Result{}, err
 		}
+	}
 
-		if err := r.CreateOrUpdateClusterIssuerForService(service, existingClusterIssuer); err != nil {
-			return ctrl.Result{}, client.IgnoreNotFound(err)
+	if !waiting && !issued {
+		if delay, err = r.ReserveRateLimitBudget(service, policy, false); err != nil {
+			return ctrl.Result{}, err
 		}
+		waiting = delay > 0
+	}
 
-		if err := r.CreateOrUpdateIngressForService(service, existingIngress); err != nil {
+	if waiting {
+		// the service is exposed meanwhile, the load balancer address is published with it
+		if err := r.ExposeService(service, policy); err != nil {
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
+
+		if err := r.PublishDNSForService(service, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	if err := r.CreateOrUpdateClusterIssuerForService(service, policy, existingClusterIssuer); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if builder.ManagesCertificate(cfg, policy) {
+		log.Info("check if certificate already exists")
+		existingCertificate, err := r.GetCertificateByName(builder.CertificateName(cfg, service, policy), builder.CertificateNamespace(service, policy))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.CreateOrUpdateCertificateForService(service, policy, existingCertificate); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if err := r.DeleteStaleCertificatesForService(service, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if err := r.DeleteCertificateForService(req.NamespacedName); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.ExposeService(service, policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	return ctrl.Result{}, nil
+	if err := r.PublishDNSForService(service, policy); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	renewIn, err := r.CheckCertificateRenewal(service, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if promoting && renewIn > PromotionRequeue {
+		renewIn = PromotionRequeue
+	}
+
+	log.Info("certificate is checked again in " + renewIn.String())
+
+	return ctrl.Result{RequeueAfter: renewIn}, nil
 }
 
 func (r *CustomIngressManagerReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Service{}).
-		Complete(r)
+		Owns(&v1beta1.Ingress{}).
+		Watches(
+			&source.Kind{Type: &webappv1.CustomIngressManager{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.ServicesInPolicyNamespace)},
+		)
+
+	// the cert-manager CRDs may be missing when the built-in ACME client is used
+	if r.config().CertificateBackend != config.CertificateBackendACME {
+		builder = builder.Owns(CertManagerObjectType(&v1alpha3.Certificate{}, r.config().CertManagerAPIVersion))
+	}
+
+	for _, gvk := range r.RouteKinds {
+		builder = builder.Owns(RouteObjectType(gvk))
+	}
+
+	// Namespaces are cluster scoped, so they can only be watched when the cache is not
+	// restricted to a set of namespaces. Otherwise label changes are picked up on the next
+	// service event.
+	if r.NamespaceSelector != nil && len(r.WatchNamespaces) == 0 {
+		builder = builder.Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.ServicesInNamespace)},
+		)
+	}
+
+	return builder.Complete(r)
+}
+
+// ServicesInNamespace maps a namespace event to reconcile requests for all services in it.
+func (r *CustomIngressManagerReconciler) ServicesInNamespace(namespace handler.MapObject) []reconcile.Request {
+	return r.requestsForServicesIn(namespace.Meta.GetName())
+}
+
+// ServicesInPolicyNamespace maps a policy event to reconcile requests for all services in its namespace.
+func (r *CustomIngressManagerReconciler) ServicesInPolicyNamespace(policy handler.MapObject) []reconcile.Request {
+	return r.requestsForServicesIn(policy.Meta.GetNamespace())
+}
+
+func (r *CustomIngressManagerReconciler) requestsForServicesIn(namespace string) []reconcile.Request {
+	var services corev1.ServiceList
+	if err := r.List(context.Background(), &services, client.InNamespace(namespace)); err != nil {
+		r.Log.Error(err, "unable to list services in namespace "+namespace)
+
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(services.Items))
+	for _, service := range services.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: service.Name, Namespace: service.Namespace},
+		})
+	}
+
+	return requests
+}
+
+// IsWatchedNamespace checks the namespace against the configured namespace list and label selector.
+func (r *CustomIngressManagerReconciler) IsWatchedNamespace(namespace string) (bool, error) {
+	if len(r.WatchNamespaces) > 0 {
+		found := false
+		for _, watchNamespace := range r.WatchNamespaces {
+			if watchNamespace == namespace {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			r.Log.Info("namespace " + namespace + " is not watched")
+
+			return false, nil
+		}
+	}
+
+	if r.NamespaceSelector == nil || r.NamespaceSelector.Empty() {
+		return true, nil
+	}
+
+	var ns corev1.Namespace
+	if err := r.clusterScopedReader().Get(context.Background(), types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	if !r.NamespaceSelector.Matches(labels.Set(ns.Labels)) {
+		r.Log.Info("namespace " + namespace + " does not match the namespace selector")
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (r *CustomIngressManagerReconciler) config() *config.Config {
+	if r.Config != nil {
+		return r.Config
+	}
+
+	return config.DefaultConfig()
+}
+
+func (r *CustomIngressManagerReconciler) clusterScopedReader() client.Reader {
+	if r.ClusterScopedReader != nil {
+		return r.ClusterScopedReader
+	}
+
+	return r.Client
+}
+
+// CleanupForService tears down the Ingress or HTTPRoute, Certificate, ClusterIssuer and TLS secret generated for
+// the given Service. Objects without our managed-by label are left alone, so resources
+// created by hand with a colliding name are never deleted, unless they are the unlabelled
+// Ingress and ClusterIssuer generated for the service by the first versions.
+func (r *CustomIngressManagerReconciler) CleanupForService(serviceName types.NamespacedName) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	if err := r.DeleteRoutesForService(serviceName, true); err != nil {
+		return err
+	}
+
+	if err := r.DeleteDNSEndpointForService(serviceName); err != nil {
+		return err
+	}
+
+	if cfg.CertificateBackend == config.CertificateBackendACME {
+		return nil
+	}
+
+	if err := r.DeleteCertificateForService(serviceName); err != nil {
+		return err
+	}
+
+	existingClusterIssuer, err := r.GetClusterIssuerByName(cfg.CreateClusterIssuerName(serviceName.Name))
+	if err != nil {
+		return err
+	}
+
+	if existingClusterIssuer == nil {
+		return nil
+	}
+
+	managed := IsManagedByUs(existingClusterIssuer.ObjectMeta) && IsClusterIssuerOfService(cfg, existingClusterIssuer, serviceName)
+	if managed || IsBaselineClusterIssuerOfService(cfg, existingClusterIssuer, serviceName) {
+		r.Log.Info("deleting existing cluster issuer")
+		if err := r.Delete(ctx, existingClusterIssuer); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteIngressForService deletes the managed Ingress of the service. The TLS secret is deleted
+// too if deleteSecret is set and the Ingress does not retain it.
+func (r *CustomIngressManagerReconciler) DeleteIngressForService(serviceName types.NamespacedName, deleteSecret bool) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	existingIngress, err := r.GetIngressByName(cfg.CreateIngressName(serviceName.Name), serviceName.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if existingIngress == nil {
+		return nil
+	}
+
+	// the namespace wide secret of a baseline ingress doubles as the ACME account key of the
+	// other services of the namespace, it is kept
+	baseline := IsBaselineIngressOfService(cfg, existingIngress, serviceName)
+	if !IsManagedByUs(existingIngress.ObjectMeta) && !baseline {
+		return nil
+	}
+
+	r.Log.Info("deleting existing ingress")
+	if err := r.Delete(ctx, existingIngress); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if deleteSecret && !baseline && existingIngress.Annotations[config.RetainSecretAnnotation] != "true" {
+		for _, tls := range existingIngress.Spec.TLS {
+			if err := r.DeleteUnusedSecret(tls.SecretName, serviceName.Namespace); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DeleteUnusedSecret deletes the TLS secret unless another managed Ingress in the
+// namespace still references it.
+func (r *CustomIngressManagerReconciler) DeleteUnusedSecret(secretName, namespace string) error {
+	ctx := context.Background()
+
+	var ingresses v1beta1.IngressList
+	if err := r.List(ctx, &ingresses, client.InNamespace(namespace), client.MatchingLabels{config.ManagedByLabel: config.ManagedByLabelValue}); err != nil {
+		return err
+	}
+
+	for _, ingress := range ingresses.Items {
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName == secretName {
+				r.Log.Info("secret still in use by " + ingress.Name + ", keeping it")
+
+				return nil
+			}
+		}
+	}
+
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+		},
+	}
+
+	r.Log.Info("deleting secret " + secretName)
+
+	return client.IgnoreNotFound(r.Delete(ctx, &secret))
 }
 
 func (r *CustomIngressManagerReconciler) GetIngressByName(ingressName, namespace string) (*v1beta1.Ingress, error) {
@@ -155,7 +563,7 @@ func (r *CustomIngressManagerReconciler) GetClusterIssuerByName(clusterIssuerNam
 		Name: clusterIssuerName,
 	}
 
-	if err := r.Get(ctx, namespacedName, &clusterIssuer); err != nil {
+	if err := r.clusterScopedReader().Get(ctx, namespacedName, &clusterIssuer); err != nil {
 		if errors.IsNotFound(err) {
 			return nil, nil
 		}
@@ -168,73 +576,201 @@ func (r *CustomIngressManagerReconciler) GetClusterIssuerByName(clusterIssuerNam
 	return &clusterIssuer, nil
 }
 
-func (r *CustomIngressManagerReconciler) IsValidService(service *corev1.Service) bool {
+func (r *CustomIngressManagerReconciler) IsValidService(service *corev1.Service, policy *webappv1.CustomIngressManager) bool {
 	regExValidaton := regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+	cfg := r.config()
+
 	r.Log.Info("validating service")
-	if labelValue, ok := service.ObjectMeta.Labels[CustomIngressLabel]; !ok || labelValue != CustomIngressLabelValue {
+	if labelValue, ok := service.ObjectMeta.Labels[cfg.IngressLabel]; !ok || labelValue != cfg.IngressLabelValue {
 		r.Log.Info("no custom label")
 
 		return false
 	}
 
-	if annotationValue, ok := service.ObjectMeta.Annotations[DomainAnnotation]; !ok || !isd.IsDomain(annotationValue) {
-		r.Log.Info("invalid domain name: " + annotationValue)
+	if problems := config.ValidatePolicy(policy); len(problems) > 0 {
+		r.Log.Info("invalid policy " + policy.Name + ": " + strings.Join(problems, "; "))
+		if r.Recorder != nil {
+			r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidPolicy", policy.Name+": "+strings.Join(problems, "; "))
+		}
+
+		return false
+	}
+
+	if config.PolicyIssuanceMode(policy) != "" && cfg.PolicyIssuerServer(policy) == nil {
+		message := policy.Name + ": the issuer server is not one of the issuer servers of the operator config"
+		r.Log.Info("invalid policy " + message)
+		if r.Recorder != nil {
+			r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidPolicy", message)
+		}
+
+		return false
+	}
+
+	mode := cfg.IssuanceMode(service, policy)
+	if config.PolicyIssuanceMode(policy) == "" && !config.IsValidIssuanceMode(mode) {
+		r.Log.Info("invalid issuance mode: " + mode)
+
+		return false
+	}
+
+	if cfg.CertificateBackend == config.CertificateBackendACME && mode != config.IssuanceModeACME {
+		r.Log.Info("issuance mode " + mode + " is not supported by the acme certificate backend")
+
+		return false
+	}
+
+	if cfg.CertificateBackend == config.CertificateBackendACME && builder.RouteIstio(policy) != nil {
+		// the solver of the built-in client needs an Ingress controller
+		r.Log.Info("istio routes are not supported by the acme certificate backend")
+
+		return false
+	}
+
+	annotationValue, legacy, err := cfg.Domain(service.ObjectMeta.Annotations)
+	if legacy {
+		r.WarnDeprecatedAnnotation(service, cfg.LegacyDomainAnnotation, cfg.DomainAnnotation)
+	}
+
+	if err != nil {
+		r.Log.Info("invalid domain name: " + err.Error())
+		if r.Recorder != nil {
+			r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidDomain", err.Error())
+		}
+
+		return false
+	}
+
+	// internal domains like .svc.cluster.local can not be checked against the public TLD list,
+	// the problems name internationalized domains in their Unicode form
+	if problems := cfg.ValidateDomain(config.UnicodeDomain(annotationValue), mode == config.IssuanceModeACME); len(problems) > 0 {
+		r.Log.Info("invalid domain name: " + strings.Join(problems, "; "))
+		if r.Recorder != nil {
+			r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidDomain", strings.Join(problems, "; "))
+		}
 
 		return false
 	}
 
-	if annotationValue, ok := service.ObjectMeta.Annotations[EmailAnnotation]; !ok || !regExValidaton.MatchString(annotationValue) {
-		r.Log.Info("invalid email address: " + annotationValue)
+	// the root CA is trusted cluster-wide, a namespace must not get certificates for the domains of others
+	if mode == config.IssuanceModeCA && !cfg.IsAllowedCADomain(annotationValue, service.Namespace) {
+		message := fmt.Sprintf("domain %s: not one of the CA domains of namespace %s", config.DisplayDomain(annotationValue), service.Namespace)
+		r.Log.Info("invalid domain name: " + message)
+		if r.Recorder != nil {
+			r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidDomain", message)
+		}
 
 		return false
 	}
 
+	if mode == config.IssuanceModeACME {
+		annotationValue, legacy = cfg.Email(service.ObjectMeta.Annotations)
+		if legacy {
+			r.WarnDeprecatedAnnotation(service, cfg.LegacyEmailAnnotation, cfg.EmailAnnotation)
+		}
+
+		if !regExValidaton.MatchString(annotationValue) {
+			r.Log.Info("invalid email address: " + annotationValue)
+
+			return false
+		}
+	}
+
+	if cfg.ExternalDNS != "" {
+		if _, problems := cfg.DNSOptions(service.ObjectMeta.Annotations); len(problems) > 0 {
+			r.Log.Info("invalid dns options: " + strings.Join(problems, "; "))
+			if r.Recorder != nil {
+				r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidDNSOptions", strings.Join(problems, "; "))
+			}
+
+			return false
+		}
+	}
+
+	if denied := cfg.DeniedIngressAnnotations(service.ObjectMeta.Annotations); len(denied) > 0 {
+		// the service is still exposed, without them
+		r.Log.Info("ingress annotations not allowed: " + strings.Join(denied, ", "))
+		if r.Recorder != nil {
+			r.Recorder.Event(service, corev1.EventTypeWarning, "DeniedIngressAnnotations", "not copied onto the ingress: "+strings.Join(denied, ", "))
+		}
+	}
+
+	if cfg.DNSPreflight && cfg.UsesProductionACME(service, policy) && builder.RouteTraefik(policy) != nil && cfg.TraefikService == "" {
+		// the preflight would wait forever for the address of the load balancer
+		if options, _ := cfg.DNSOptions(service.ObjectMeta.Annotations); len(options.Targets) == 0 {
+			message := policy.Name + ": the load balancer of Traefik routes is unknown, set traefikService in the operator config or the " + config.DNSTargetAnnotation + " annotation"
+			r.Log.Info("invalid policy " + message)
+			if r.Recorder != nil {
+				r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidPolicy", message)
+			}
+
+			return false
+		}
+	}
+
+	if builder.ManagesCertificate(cfg, policy) || cfg.CertificateBackend == config.CertificateBackendACME {
+		if _, problems := cfg.CertificateOptions(service.ObjectMeta.Annotations); len(problems) > 0 {
+			r.Log.Info("invalid certificate options: " + strings.Join(problems, "; "))
+			if r.Recorder != nil {
+				r.Recorder.Event(service, corev1.EventTypeWarning, "InvalidCertificateOptions", strings.Join(problems, "; "))
+			}
+
+			return false
+		}
+	}
+
+	if name, ok := service.ObjectMeta.Annotations[config.TLSSecretAnnotation]; ok {
+		if problems := validation.IsDNS1123Subdomain(name); len(problems) > 0 {
+			r.Log.Info("invalid tls secret " + name + ": " + strings.Join(problems, "; "))
+
+			return false
+		}
+	}
+
 	r.Log.Info("valid service found")
 
 	return true
 }
 
-func (r *CustomIngressManagerReconciler) CreateOrUpdateIngressForService(service corev1.Service, existingIngress *v1beta1.Ingress) error {
+// WarnDeprecatedAnnotation emits a Warning event on the service for a legacy annotation key.
+func (r *CustomIngressManagerReconciler) WarnDeprecatedAnnotation(service *corev1.Service, legacyKey, key string) {
+	r.Log.Info("service " + service.Name + " uses deprecated annotation " + legacyKey)
+
+	if r.Recorder != nil {
+		r.Recorder.Eventf(service, corev1.EventTypeWarning, "DeprecatedAnnotation",
+			"annotation %q is deprecated, use %q instead", legacyKey, key)
+	}
+}
+
+func (r *CustomIngressManagerReconciler) CreateOrUpdateIngressForService(service corev1.Service, policy *webappv1.CustomIngressManager, existingIngress *v1beta1.Ingress) error {
 	ctx := context.Background()
-	ingress := v1beta1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        CreateIngressName(service.Name),
-			Namespace:   service.Namespace,
-			Annotations: map[string]string{ClusterIssuerAnnotation: CreateClusterIssuerName(service.Name)},
-		},
-		Spec: v1beta1.IngressSpec{
-			TLS: []v1beta1.IngressTLS{
-				{
-					Hosts:      []string{service.ObjectMeta.Annotations[DomainAnnotation]},
-					SecretName: CreateSecretName(service.Namespace),
-				},
-			},
-			Rules: []v1beta1.IngressRule{
-				{
-					Host: service.ObjectMeta.Annotations[DomainAnnotation],
-					IngressRuleValue: v1beta1.IngressRuleValue{
-						HTTP: &v1beta1.HTTPIngressRuleValue{
-							Paths: []v1beta1.HTTPIngressPath{
-								{
-									Path: "/",
-									Backend: v1beta1.IngressBackend{
-										ServiceName: service.Name,
-										ServicePort: intstr.FromInt(80),
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+	cfg := r.config()
+
+	ingress := builder.Ingress(cfg, service, policy)
+
+	if name := ingress.Annotations[config.ClusterIssuerAnnotation]; name != "" && cfg.DNSPreflight {
+		// ingress-shim would order the certificate, the issuer is only created after the
+		// preflight check
+		existingClusterIssuer, err := r.GetClusterIssuerByName(name)
+		if err != nil {
+			return err
+		}
+
+		if existingClusterIssuer == nil {
+			delete(ingress.Annotations, config.ClusterIssuerAnnotation)
+		}
 	}
 
 	if existingIngress != nil {
-		if !reflect.DeepEqual(existingIngress, ingress) {
+		desiredIngress := existingIngress.DeepCopy()
+		desiredIngress.Labels = MergeLabels(existingIngress.Labels, ingress.Labels)
+		desiredIngress.Annotations = MergeManagedAnnotations(existingIngress.Annotations, ingress.Annotations)
+		desiredIngress.OwnerReferences = ingress.OwnerReferences
+		desiredIngress.Spec = ingress.Spec
+
+		if !reflect.DeepEqual(existingIngress, desiredIngress) {
 			log.Info("updating Ingress")
-			if err := r.Update(ctx, &ingress); err != nil {
+			if err := r.Update(ctx, desiredIngress); err != nil {
 				log.Error(err, "unable to update the Ingress")
 				// we'll ignore not-found errors, since they can't be fixed by an immediate
 				// requeue (we'll need to wait for a new notification), and we can get them
@@ -246,6 +782,8 @@ func (r *CustomIngressManagerReconciler) CreateOrUpdateIngressForService(service
 		return nil
 	}
 
+	ingress.Annotations = MergeManagedAnnotations(nil, ingress.Annotations)
+
 	log.Info("try to create Ingress")
 	if err := r.Create(ctx, &ingress); err != nil {
 		log.Error(err, "unable to create the Ingress")
@@ -260,51 +798,33 @@ func (r *CustomIngressManagerReconciler) CreateOrUpdateIngressForService(service
 	return nil
 }
 
-func (r *CustomIngressManagerReconciler) CreateOrUpdateClusterIssuerForService(service corev1.Service, existingClusterIssuer *v1alpha3.ClusterIssuer) error {
+func (r *CustomIngressManagerReconciler) CreateOrUpdateClusterIssuerForService(service corev1.Service, policy *webappv1.CustomIngressManager, existingClusterIssuer *v1alpha3.ClusterIssuer) error {
 	ctx := context.Background()
+	cfg := r.config()
 
-	var letsencryptUrl string
-	if environmentLabelValue, ok := service.ObjectMeta.Labels[EnvironmentLabel]; ok && environmentLabelValue == "production" {
-		letsencryptUrl = "https://acme-v02.api.letsencrypt.org/directory"
-	} else {
-		letsencryptUrl = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	if cfg.IssuanceMode(&service, policy) == config.IssuanceModeCA {
+		if err := r.EnsureRootCA(); err != nil {
+			return err
+		}
 	}
 
-	clusterIssuer := v1alpha3.ClusterIssuer{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: CreateClusterIssuerName(service.Name),
-		},
-		Spec: v1alpha3.IssuerSpec{
-			IssuerConfig: v1alpha3.IssuerConfig{
-				ACME: &cmacme.ACMEIssuer{
-					Server: letsencryptUrl,
-					Email:  service.ObjectMeta.Annotations[EmailAnnotation],
-					PrivateKey: cmeta1.SecretKeySelector{
-						LocalObjectReference: cmeta1.LocalObjectReference{
-							Name: CreateSecretName(service.Namespace),
-						},
-					},
-					Solvers: []cmacme.ACMEChallengeSolver{
-						{
-							HTTP01: &cmacme.ACMEChallengeSolverHTTP01{
-								// Not setting the Class or Name field will cause cert-manager to create
-								// new ingress resources that do not specify a class to solve challenges,
-								// which means all Ingress controllers should act on the ingresses.
-								Ingress: &cmacme.ACMEChallengeSolverHTTP01Ingress{},
-							},
-						},
-					},
-				},
-			},
-		},
+	clusterIssuer, err := builder.ClusterIssuer(cfg, service, policy)
+	if err != nil {
+		return err
 	}
 
 	if existingClusterIssuer != nil {
-		if !reflect.DeepEqual(existingClusterIssuer.ObjectMeta.Name, clusterIssuer.ObjectMeta.Name) ||
-			!reflect.DeepEqual(existingClusterIssuer.Namespace, clusterIssuer.Namespace) ||
-			!reflect.DeepEqual(existingClusterIssuer.Spec.ACME.Email, clusterIssuer.Spec.ACME.Email) {
+		if !IsClusterIssuerOfService(cfg, existingClusterIssuer, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}) {
+			return fmt.Errorf("cluster issuer %s belongs to the service %s in another namespace", existingClusterIssuer.Name, service.Name)
+		}
+
+		desiredClusterIssuer := existingClusterIssuer.DeepCopy()
+		desiredClusterIssuer.Labels = MergeLabels(existingClusterIssuer.Labels, clusterIssuer.Labels)
+		desiredClusterIssuer.Spec = clusterIssuer.Spec
+
+		if !reflect.DeepEqual(existingClusterIssuer, desiredClusterIssuer) {
 			log.Info("updating ClusterIssuer")
-			if err := r.Update(ctx, &clusterIssuer); err != nil {
+			if err := r.Update(ctx, desiredClusterIssuer); err != nil {
 				log.Error(err, "unable to update the ClusterIssuer")
 				// we'll ignore not-found errors, since they can't be fixed by an immediate
 				// requeue (we'll need to wait for a new notification), and we can get them
@@ -331,13 +851,125 @@ func (r *CustomIngressManagerReconciler) CreateOrUpdateClusterIssuerForService(s
 }
 
 func CreateIngressName(name string) string {
-	return name + "-ingress"
+	return config.DefaultConfig().CreateIngressName(name)
 }
 
 func CreateClusterIssuerName(name string) string {
-	return name + "-lets-encrypt-staging"
+	return config.DefaultConfig().CreateClusterIssuerName(name)
 }
 
 func CreateSecretName(name string) string {
-	return name + "-secret"
+	return config.DefaultConfig().CreateSecretName(name)
+}
+
+// CreateManagedLabels returns the labels marking the objects generated for the service.
+func CreateManagedLabels(service corev1.Service) map[string]string {
+	return builder.ManagedLabels(service)
+}
+
+// MergeLabels returns the existing labels with ours set on top.
+func MergeLabels(existing, ours map[string]string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range existing {
+		merged[key] = value
+	}
+
+	for key, value := range ours {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// MergeManagedAnnotations sets our annotations on top of the existing ones. Annotations we set
+// earlier (listed in ManagedAnnotationsAnnotation) but no longer want are removed, everything
+// else is kept untouched. The list is left out if we set no annotations.
+func MergeManagedAnnotations(existing, ours map[string]string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range existing {
+		merged[key] = value
+	}
+
+	if previous := existing[config.ManagedAnnotationsAnnotation]; previous != "" {
+		for _, key := range strings.Split(previous, ",") {
+			delete(merged, key)
+		}
+	}
+	delete(merged, config.ManagedAnnotationsAnnotation)
+
+	keys := make([]string, 0, len(ours))
+	for key, value := range ours {
+		merged[key] = value
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		merged[config.ManagedAnnotationsAnnotation] = strings.Join(keys, ",")
+	}
+
+	return merged
+}
+
+// IsClusterIssuerOfService reports whether the cluster issuer was generated for the service.
+// Cluster issuers are named after the service only, so a service of the same name in another
+// namespace must not take it over. Issuers of earlier versions carry no labels, their namespace
+// is told by the secret of the ACME account key.
+func IsClusterIssuerOfService(cfg *config.Config, clusterIssuer *v1alpha3.ClusterIssuer, serviceName types.NamespacedName) bool {
+	if namespace, ok := clusterIssuer.Labels[config.ServiceNamespaceLabel]; ok {
+		return namespace == serviceName.Namespace
+	}
+
+	if clusterIssuer.Spec.ACME != nil {
+		return clusterIssuer.Spec.ACME.PrivateKey.Name == cfg.CreateSecretName(serviceName.Namespace)
+	}
+
+	return true
+}
+
+// IsBaselineIngressOfService reports whether the unlabelled ingress was generated for the service
+// by the first versions, which set no labels: it is named after the service, carries the cluster
+// issuer annotation of the service and routes to the service only.
+func IsBaselineIngressOfService(cfg *config.Config, ingress *v1beta1.Ingress, serviceName types.NamespacedName) bool {
+	if len(ingress.Labels) > 0 || ingress.Name != cfg.CreateIngressName(serviceName.Name) || ingress.Namespace != serviceName.Namespace {
+		return false
+	}
+
+	if ingress.Annotations[config.ClusterIssuerAnnotation] != cfg.CreateClusterIssuerName(serviceName.Name) || len(ingress.Spec.Rules) == 0 {
+		return false
+	}
+
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			return false
+		}
+
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.ServiceName != serviceName.Name {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// IsBaselineClusterIssuerOfService reports whether the unlabelled cluster issuer was generated for
+// the service by the first versions: it is named after the service and is a Let's Encrypt issuer
+// with the ACME account key secret of the service namespace.
+func IsBaselineClusterIssuerOfService(cfg *config.Config, clusterIssuer *v1alpha3.ClusterIssuer, serviceName types.NamespacedName) bool {
+	if len(clusterIssuer.Labels) > 0 || clusterIssuer.Name != cfg.CreateClusterIssuerName(serviceName.Name) {
+		return false
+	}
+
+	acme := clusterIssuer.Spec.ACME
+	if acme == nil || acme.PrivateKey.Name != cfg.CreateSecretName(serviceName.Namespace) {
+		return false
+	}
+
+	return acme.Server == cfg.ACMEProductionURL || acme.Server == cfg.ACMEStagingURL
+}
+
+func IsManagedByUs(objectMeta metav1.ObjectMeta) bool {
+	return objectMeta.Labels[config.ManagedByLabel] == config.ManagedByLabelValue
 }
@@ -17,18 +17,29 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
 	"github.com/go-logr/logr"
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha3"
 	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
 )
 
 var (
@@ -36,8 +47,12 @@ var (
 )
 
 func InitTestScheme() {
+	_ = corev1.AddToScheme(testScheme)
 	_ = v1beta1.AddToScheme(testScheme)
 	_ = v1alpha3.AddToScheme(testScheme)
+	_ = webappv1.AddToScheme(testScheme)
+	// the fake client lists unstructured objects through the scheme
+	testScheme.AddKnownTypeWithName(builder.ExternalDNSGroupVersion.WithKind(builder.DNSEndpointKind+"List"), &unstructured.UnstructuredList{})
 }
 
 func TestCreateClusterIssuerName(t *testing.T) {
@@ -102,6 +117,44 @@ func TestCustomIngressManagerReconciler_IsValidService(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "PrefixedAnnotations",
+			fields: fields{
+				Client: clientFaker.NewFakeClientWithScheme(testScheme),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: runtime.NewScheme(),
+			},
+			args: args{
+				service: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com", "domain": "other"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "InternationalizedDomain",
+			fields: fields{
+				Client: clientFaker.NewFakeClientWithScheme(testScheme),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: runtime.NewScheme(),
+			},
+			args: args{
+				service: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"domain": "bücher.de", "email": "tes@test.com"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+			},
+			want: true,
+		},
 		{
 			name: "InvalidEmail",
 			fields: fields{
@@ -140,6 +193,82 @@ func TestCustomIngressManagerReconciler_IsValidService(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "MissingDomain",
+			fields: fields{
+				Client: clientFaker.NewFakeClient(),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: runtime.NewScheme(),
+			},
+			args: args{
+				service: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"email": "test@test.com"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "ReservedDomain",
+			fields: fields{
+				Client: clientFaker.NewFakeClient(),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: runtime.NewScheme(),
+			},
+			args: args{
+				service: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"domain": "app.localhost", "email": "test@test.com"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "PublicSuffix",
+			fields: fields{
+				Client: clientFaker.NewFakeClient(),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: runtime.NewScheme(),
+			},
+			args: args{
+				service: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"domain": "co.uk", "email": "test@test.com"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "IPAddress",
+			fields: fields{
+				Client: clientFaker.NewFakeClient(),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: runtime.NewScheme(),
+			},
+			args: args{
+				service: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"domain": "203.0.113.10", "email": "test@test.com"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+			},
+			want: false,
+		},
 		{
 			name: "NoValidLabel",
 			fields: fields{
@@ -166,7 +295,7 @@ func TestCustomIngressManagerReconciler_IsValidService(t *testing.T) {
 				Log:    tt.fields.Log,
 				Scheme: tt.fields.Scheme,
 			}
-			if got := r.IsValidService(tt.args.service); got != tt.want {
+			if got := r.IsValidService(tt.args.service, nil); got != tt.want {
 				t.Errorf("CustomIngressManagerReconciler.IsValidService() = %v, want %v", got, tt.want)
 			}
 		})
@@ -296,7 +425,7 @@ func TestCustomIngressManagerReconciler_CreateOrUpdateIngressForService(t *testi
 				Log:    tt.fields.Log,
 				Scheme: tt.fields.Scheme,
 			}
-			if err := r.CreateOrUpdateIngressForService(tt.args.service, tt.args.existingIngress); (err != nil) != tt.wantErr {
+			if err := r.CreateOrUpdateIngressForService(tt.args.service, nil, tt.args.existingIngress); (err != nil) != tt.wantErr {
 				t.Errorf("CustomIngressManagerReconciler.CreateIngressForService() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -341,6 +470,35 @@ func TestCustomIngressManagerReconciler_CreateOrUpdateClusterIssuerForService(t
 			},
 			wantErr: false,
 		},
+		{
+			name: "OtherNamespace",
+			fields: fields{
+				Client: clientFaker.NewFakeClientWithScheme(testScheme),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: testScheme,
+			},
+			args: args{
+				service: corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "testsvc",
+						Namespace:   "default",
+						Annotations: map[string]string{"domain": "test.com", "email": "tes@test.com"},
+						Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+					},
+				},
+				existingClusterIssuer: &v1alpha3.ClusterIssuer{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "testsvc-lets-encrypt-staging",
+						Labels: map[string]string{
+							config.ManagedByLabel:        config.ManagedByLabelValue,
+							config.ServiceNameLabel:      "testsvc",
+							config.ServiceNamespaceLabel: "other",
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -349,7 +507,7 @@ func TestCustomIngressManagerReconciler_CreateOrUpdateClusterIssuerForService(t
 				Log:    tt.fields.Log,
 				Scheme: tt.fields.Scheme,
 			}
-			if err := r.CreateOrUpdateClusterIssuerForService(tt.args.service, tt.args.existingClusterIssuer); (err != nil) != tt.wantErr {
+			if err := r.CreateOrUpdateClusterIssuerForService(tt.args.service, nil, tt.args.existingClusterIssuer); (err != nil) != tt.wantErr {
 				t.Errorf("CustomIngressManagerReconciler.CreateClusterIssuerForService() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -411,3 +569,328 @@ func TestCustomIngressManagerReconciler_GetClusterIssuerByName(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomIngressManagerReconciler_CleanupForService(t *testing.T) {
+	InitTestScheme()
+
+	managedLabels := map[string]string{
+		config.ManagedByLabel:        config.ManagedByLabelValue,
+		config.ServiceNameLabel:      "testsvc",
+		config.ServiceNamespaceLabel: "default",
+	}
+	newIngress := func(name string, labels, annotations map[string]string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Spec: v1beta1.IngressSpec{
+				TLS: []v1beta1.IngressTLS{{SecretName: "default-secret"}},
+			},
+		}
+	}
+	newClusterIssuer := func(labels map[string]string) *v1alpha3.ClusterIssuer {
+		return &v1alpha3.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "testsvc-lets-encrypt-staging",
+				Labels: labels,
+			},
+		}
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default-secret",
+			Namespace: "default",
+		},
+	}
+	// the objects of the first versions carry no labels
+	baselineIngress := func(backend string) *v1beta1.Ingress {
+		ingress := newIngress("testsvc-ingress", nil, map[string]string{config.ClusterIssuerAnnotation: "testsvc-lets-encrypt-staging"})
+		ingress.Spec.Rules = []v1beta1.IngressRule{{
+			Host: "test.com",
+			IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+				Paths: []v1beta1.HTTPIngressPath{{Path: "/", Backend: v1beta1.IngressBackend{ServiceName: backend, ServicePort: intstr.FromInt(80)}}},
+			}},
+		}}
+
+		return ingress
+	}
+	baselineClusterIssuer := func(privateKey string) *v1alpha3.ClusterIssuer {
+		clusterIssuer := newClusterIssuer(nil)
+		clusterIssuer.Spec.ACME = &cmacme.ACMEIssuer{
+			Server:     config.LetsEncryptStagingURL,
+			PrivateKey: cmeta1.SecretKeySelector{LocalObjectReference: cmeta1.LocalObjectReference{Name: privateKey}},
+		}
+
+		return clusterIssuer
+	}
+
+	tests := []struct {
+		name              string
+		objects           []runtime.Object
+		wantIngress       bool
+		wantClusterIssuer bool
+		wantSecret        bool
+	}{
+		{
+			name:              "ManagedResourcesDeleted",
+			objects:           []runtime.Object{newIngress("testsvc-ingress", managedLabels, nil), newClusterIssuer(managedLabels), secret.DeepCopy()},
+			wantIngress:       false,
+			wantClusterIssuer: false,
+			wantSecret:        false,
+		},
+		{
+			name:              "RetainSecret",
+			objects:           []runtime.Object{newIngress("testsvc-ingress", managedLabels, map[string]string{config.RetainSecretAnnotation: "true"}), newClusterIssuer(managedLabels), secret.DeepCopy()},
+			wantIngress:       false,
+			wantClusterIssuer: false,
+			wantSecret:        true,
+		},
+		{
+			name:              "SecretStillInUse",
+			objects:           []runtime.Object{newIngress("testsvc-ingress", managedLabels, nil), newIngress("othersvc-ingress", managedLabels, nil), secret.DeepCopy()},
+			wantIngress:       false,
+			wantClusterIssuer: false,
+			wantSecret:        true,
+		},
+		{
+			name: "OtherNamespaceClusterIssuerKept",
+			objects: []runtime.Object{newClusterIssuer(map[string]string{
+				config.ManagedByLabel:        config.ManagedByLabelValue,
+				config.ServiceNameLabel:      "testsvc",
+				config.ServiceNamespaceLabel: "other",
+			})},
+			wantClusterIssuer: true,
+		},
+		{
+			name:              "UnmanagedResourcesKept",
+			objects:           []runtime.Object{newIngress("testsvc-ingress", nil, nil), newClusterIssuer(nil), secret.DeepCopy()},
+			wantIngress:       true,
+			wantClusterIssuer: true,
+			wantSecret:        true,
+		},
+		{
+			name:              "BaselineResourcesDeleted",
+			objects:           []runtime.Object{baselineIngress("testsvc"), baselineClusterIssuer("default-secret"), secret.DeepCopy()},
+			wantIngress:       false,
+			wantClusterIssuer: false,
+			wantSecret:        true,
+		},
+		{
+			name:              "BaselineLookalikesKept",
+			objects:           []runtime.Object{baselineIngress("othersvc"), baselineClusterIssuer("other-secret"), secret.DeepCopy()},
+			wantIngress:       true,
+			wantClusterIssuer: true,
+			wantSecret:        true,
+		},
+		{
+			name:    "NothingToDelete",
+			objects: []runtime.Object{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clientFaker.NewFakeClientWithScheme(testScheme, tt.objects...)
+			r := &CustomIngressManagerReconciler{
+				Client: c,
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: testScheme,
+			}
+			if err := r.CleanupForService(types.NamespacedName{Name: "testsvc", Namespace: "default"}); err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.CleanupForService() error = %v", err)
+			}
+
+			ctx := context.Background()
+			gotIngress := c.Get(ctx, types.NamespacedName{Name: "testsvc-ingress", Namespace: "default"}, &v1beta1.Ingress{}) == nil
+			if gotIngress != tt.wantIngress {
+				t.Errorf("ingress exists = %v, want %v", gotIngress, tt.wantIngress)
+			}
+			gotClusterIssuer := c.Get(ctx, types.NamespacedName{Name: "testsvc-lets-encrypt-staging"}, &v1alpha3.ClusterIssuer{}) == nil
+			if gotClusterIssuer != tt.wantClusterIssuer {
+				t.Errorf("clusterissuer exists = %v, want %v", gotClusterIssuer, tt.wantClusterIssuer)
+			}
+			gotSecret := c.Get(ctx, types.NamespacedName{Name: "default-secret", Namespace: "default"}, &corev1.Secret{}) == nil
+			if gotSecret != tt.wantSecret {
+				t.Errorf("secret exists = %v, want %v", gotSecret, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_IsWatchedNamespace(t *testing.T) {
+	InitTestScheme()
+
+	enabledNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "enabled",
+			Labels: map[string]string{"feladat.banzaicloud.io/enabled": "true"},
+		},
+	}
+	disabledNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "disabled",
+		},
+	}
+	selector, _ := labels.Parse("feladat.banzaicloud.io/enabled=true")
+
+	type fields struct {
+		WatchNamespaces   []string
+		NamespaceSelector labels.Selector
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		namespace string
+		want      bool
+	}{
+		{
+			name:      "AllNamespaces",
+			fields:    fields{},
+			namespace: "disabled",
+			want:      true,
+		},
+		{
+			name:      "WatchedNamespace",
+			fields:    fields{WatchNamespaces: []string{"enabled", "disabled"}},
+			namespace: "disabled",
+			want:      true,
+		},
+		{
+			name:      "NotWatchedNamespace",
+			fields:    fields{WatchNamespaces: []string{"enabled"}},
+			namespace: "disabled",
+			want:      false,
+		},
+		{
+			name:      "MatchingSelector",
+			fields:    fields{NamespaceSelector: selector},
+			namespace: "enabled",
+			want:      true,
+		},
+		{
+			name:      "NotMatchingSelector",
+			fields:    fields{NamespaceSelector: selector},
+			namespace: "disabled",
+			want:      false,
+		},
+		{
+			name:      "MissingNamespace",
+			fields:    fields{NamespaceSelector: selector},
+			namespace: "missing",
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &CustomIngressManagerReconciler{
+				Client:            clientFaker.NewFakeClientWithScheme(testScheme, enabledNamespace, disabledNamespace),
+				Log:               ctrl.Log.WithName("customingressmanager"),
+				Scheme:            testScheme,
+				WatchNamespaces:   tt.fields.WatchNamespaces,
+				NamespaceSelector: tt.fields.NamespaceSelector,
+			}
+			got, err := r.IsWatchedNamespace(tt.namespace)
+			if err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.IsWatchedNamespace() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.IsWatchedNamespace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeManagedAnnotations(t *testing.T) {
+	type args struct {
+		existing map[string]string
+		ours     map[string]string
+	}
+	tests := []struct {
+		name string
+		args args
+		want map[string]string
+	}{
+		{
+			name: "Create",
+			args: args{
+				existing: nil,
+				ours:     map[string]string{"b": "2", "a": "1"},
+			},
+			want: map[string]string{"a": "1", "b": "2", config.ManagedAnnotationsAnnotation: "a,b"},
+		},
+		{
+			name: "KeepForeignAnnotations",
+			args: args{
+				existing: map[string]string{"a": "1", "other": "x", config.ManagedAnnotationsAnnotation: "a"},
+				ours:     map[string]string{"a": "2"},
+			},
+			want: map[string]string{"a": "2", "other": "x", config.ManagedAnnotationsAnnotation: "a"},
+		},
+		{
+			name: "RemoveDroppedAnnotations",
+			args: args{
+				existing: map[string]string{"a": "1", "b": "2", "other": "x", config.ManagedAnnotationsAnnotation: "a,b"},
+				ours:     map[string]string{"a": "1"},
+			},
+			want: map[string]string{"a": "1", "other": "x", config.ManagedAnnotationsAnnotation: "a"},
+		},
+		{
+			name: "NothingManaged",
+			args: args{
+				existing: map[string]string{"a": "1", "other": "x", config.ManagedAnnotationsAnnotation: "a"},
+				ours:     map[string]string{},
+			},
+			want: map[string]string{"other": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeManagedAnnotations(tt.args.existing, tt.args.ours); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeManagedAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsClusterIssuerOfService(t *testing.T) {
+	cfg := config.DefaultConfig()
+	serviceName := types.NamespacedName{Name: "testsvc", Namespace: "default"}
+	legacy := func(secretName string) *v1alpha3.ClusterIssuer {
+		return &v1alpha3.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: "testsvc-lets-encrypt-staging"},
+			Spec: v1alpha3.IssuerSpec{
+				IssuerConfig: v1alpha3.IssuerConfig{
+					ACME: &cmacme.ACMEIssuer{PrivateKey: cmeta1.SecretKeySelector{LocalObjectReference: cmeta1.LocalObjectReference{Name: secretName}}},
+				},
+			},
+		}
+	}
+	labelled := func(namespace string) *v1alpha3.ClusterIssuer {
+		return &v1alpha3.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "testsvc-lets-encrypt-staging",
+				Labels: map[string]string{config.ServiceNamespaceLabel: namespace},
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		clusterIssuer *v1alpha3.ClusterIssuer
+		want          bool
+	}{
+		{name: "SameNamespace", clusterIssuer: labelled("default"), want: true},
+		{name: "OtherNamespace", clusterIssuer: labelled("other"), want: false},
+		{name: "LegacySameNamespace", clusterIssuer: legacy("default-secret"), want: true},
+		{name: "LegacyOtherNamespace", clusterIssuer: legacy("other-secret"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsClusterIssuerOfService(cfg, tt.clusterIssuer, serviceName); got != tt.want {
+				t.Errorf("IsClusterIssuerOfService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
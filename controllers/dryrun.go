@@ -0,0 +1,246 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Actions of a PlannedChange.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// PlannedChange is a write the reconciler would have made, with the YAML diff of the object.
+type PlannedChange struct {
+	Action    string
+	Kind      string
+	Namespace string
+	Name      string
+	Diff      string
+}
+
+// DryRunClient reads through the wrapped client and records the writes instead of making them.
+type DryRunClient struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// OnChange is called for every recorded change if set.
+	OnChange func(change PlannedChange)
+
+	mu      sync.Mutex
+	changes []PlannedChange
+}
+
+// NewDryRunClient returns a DryRunClient wrapping c.
+func NewDryRunClient(c client.Client, scheme *runtime.Scheme) *DryRunClient {
+	return &DryRunClient{Client: c, Scheme: scheme}
+}
+
+// Changes returns the recorded changes in order.
+func (c *DryRunClient) Changes() []PlannedChange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]PlannedChange(nil), c.changes...)
+}
+
+func (c *DryRunClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return c.record(ActionCreate, obj, nil, obj)
+}
+
+func (c *DryRunClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return c.recordUpdate(ctx, obj)
+}
+
+func (c *DryRunClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.recordUpdate(ctx, obj)
+}
+
+func (c *DryRunClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return c.record(ActionDelete, obj, obj, nil)
+}
+
+func (c *DryRunClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	return c.record(ActionDelete, obj, nil, nil)
+}
+
+func (c *DryRunClient) Status() client.StatusWriter {
+	return dryRunStatusWriter{c}
+}
+
+type dryRunStatusWriter struct {
+	c *DryRunClient
+}
+
+func (w dryRunStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return w.c.recordUpdate(ctx, obj)
+}
+
+func (w dryRunStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return w.c.recordUpdate(ctx, obj)
+}
+
+func (c *DryRunClient) recordUpdate(ctx context.Context, obj runtime.Object) error {
+	current := obj.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: accessor.GetName(), Namespace: accessor.GetNamespace()}, current); err != nil {
+		return err
+	}
+
+	return c.record(ActionUpdate, obj, current, obj)
+}
+
+func (c *DryRunClient) record(action string, obj, before, after runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme)
+	if err != nil {
+		return err
+	}
+
+	diff, err := YAMLDiff(before, after)
+	if err != nil {
+		return err
+	}
+
+	change := PlannedChange{
+		Action:    action,
+		Kind:      gvk.Kind,
+		Namespace: accessor.GetNamespace(),
+		Name:      accessor.GetName(),
+		Diff:      diff,
+	}
+
+	c.mu.Lock()
+	c.changes = append(c.changes, change)
+	c.mu.Unlock()
+
+	if c.OnChange != nil {
+		c.OnChange(change)
+	}
+
+	return nil
+}
+
+// YAMLDiff returns the unified diff of the YAML forms of the objects, nil standing for a
+// missing object.
+func YAMLDiff(before, after runtime.Object) (string, error) {
+	var a, b string
+	for _, side := range []struct {
+		obj runtime.Object
+		out *string
+	}{{before, &a}, {after, &b}} {
+		if side.obj == nil {
+			continue
+		}
+
+		out, err := yaml.Marshal(side.obj)
+		if err != nil {
+			return "", err
+		}
+		*side.out = string(out)
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "current",
+		ToFile:   "planned",
+		Context:  3,
+	})
+}
+
+// IsDryRun reports whether the writes of the reconciler are only recorded.
+func (r *CustomIngressManagerReconciler) IsDryRun() bool {
+	_, ok := r.Client.(*DryRunClient)
+
+	return ok
+}
+
+// Plan reconciles every service of the namespaces, all namespaces if empty, with the dry-run
+// client of the reconciler and returns the changes it would make.
+func Plan(ctx context.Context, r *CustomIngressManagerReconciler, namespaces []string) ([]PlannedChange, error) {
+	dryRunClient, ok := r.Client.(*DryRunClient)
+	if !ok {
+		return nil, fmt.Errorf("the reconciler does not use a dry-run client")
+	}
+
+	if len(namespaces) == 0 {
+		namespaces = []string{corev1.NamespaceAll}
+	}
+
+	for _, namespace := range namespaces {
+		var services corev1.ServiceList
+		if err := r.List(ctx, &services, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+
+		for _, service := range services.Items {
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Name: service.Name, Namespace: service.Namespace}}
+			if _, err := r.Reconcile(request); err != nil {
+				return nil, fmt.Errorf("planning %s: %v", request.NamespacedName, err)
+			}
+		}
+	}
+
+	return dryRunClient.Changes(), nil
+}
+
+// WritePlan writes the changes with their diffs.
+func WritePlan(w io.Writer, changes []PlannedChange) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes.")
+
+		return err
+	}
+
+	for _, change := range changes {
+		name := change.Name
+		if change.Namespace != "" {
+			name = change.Namespace + "/" + name
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %s %s\n%s\n", strings.ToUpper(change.Action[:1])+change.Action[1:], change.Kind, name, change.Diff); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestPlan(t *testing.T) {
+	InitTestScheme()
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testsvc",
+			Namespace: "default",
+			Labels:    map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+			Annotations: map[string]string{
+				"feladat.banzaicloud.io/domain": "testsvc.com",
+				"feladat.banzaicloud.io/email":  "test@testsvc.com",
+			},
+		},
+	}
+	cfg := config.DefaultConfig()
+	cfg.ManageCertificates = true
+	c := clientFaker.NewFakeClientWithScheme(testScheme, service)
+	r := &CustomIngressManagerReconciler{
+		Client: NewDryRunClient(c, testScheme),
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+
+	changes, err := Plan(context.Background(), r, nil)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.Action+" "+change.Kind+" "+change.Name)
+	}
+	want := []string{
+		"create ClusterIssuer testsvc-lets-encrypt-staging",
+		"create Certificate testsvc-certificate",
+		"create Ingress testsvc-ingress",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Plan() = %v, want %v", got, want)
+	}
+	if !strings.Contains(changes[2].Diff, "+  - host: testsvc.com") {
+		t.Errorf("ingress diff = %s, want the planned rule", changes[2].Diff)
+	}
+
+	var ingresses v1beta1.IngressList
+	if err := c.List(context.Background(), &ingresses); err != nil {
+		t.Fatal(err)
+	}
+	var clusterIssuers v1alpha3.ClusterIssuerList
+	if err := c.List(context.Background(), &clusterIssuers); err != nil {
+		t.Fatal(err)
+	}
+	if len(ingresses.Items) != 0 || len(clusterIssuers.Items) != 0 {
+		t.Errorf("Plan() wrote %d ingresses and %d cluster issuers, want none", len(ingresses.Items), len(clusterIssuers.Items))
+	}
+
+	var out bytes.Buffer
+	if err := WritePlan(&out, changes); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Create ClusterIssuer testsvc-lets-encrypt-staging\n") {
+		t.Errorf("WritePlan() = %s", out.String())
+	}
+}
+
+func TestDryRunClient_Update(t *testing.T) {
+	InitTestScheme()
+
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "testsvc-ingress", Namespace: "default", Labels: map[string]string{"a": "old"}},
+	}
+	c := NewDryRunClient(clientFaker.NewFakeClientWithScheme(testScheme, ingress), testScheme)
+
+	desired := ingress.DeepCopy()
+	desired.Labels["a"] = "new"
+	if err := c.Update(context.Background(), desired); err != nil {
+		t.Fatalf("DryRunClient.Update() error = %v", err)
+	}
+
+	changes := c.Changes()
+	if len(changes) != 1 || !strings.Contains(changes[0].Diff, "-    a: old") || !strings.Contains(changes[0].Diff, "+    a: new") {
+		t.Errorf("DryRunClient.Changes() = %+v, want the label diff", changes)
+	}
+}
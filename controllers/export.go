@@ -0,0 +1,230 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// ExportManifests converts the Services in the YAML documents of the inputs into the manifests
+// the reconciler would create for them, using the CustomIngressManager policies found in the
+// same inputs. Other kinds are ignored. Objects without a namespace are put in namespace. The
+// cert-manager objects are rendered in the configured cert-manager API version, v1alpha3 if unset.
+func ExportManifests(cfg *config.Config, scheme *runtime.Scheme, inputs []io.Reader, namespace string, log logr.Logger) ([]runtime.Object, error) {
+	var services []corev1.Service
+	var policies []webappv1.CustomIngressManager
+
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	for _, input := range inputs {
+		reader := utilyaml.NewYAMLReader(bufio.NewReader(input))
+		for {
+			document, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if strings.TrimSpace(string(document)) == "" {
+				continue
+			}
+
+			obj, _, err := decoder.Decode(document, nil, nil)
+			if err != nil {
+				if runtime.IsNotRegisteredError(err) {
+					continue
+				}
+
+				return nil, err
+			}
+
+			switch typed := obj.(type) {
+			case *corev1.Service:
+				if typed.Namespace == "" {
+					typed.Namespace = namespace
+				}
+				services = append(services, *typed)
+			case *webappv1.CustomIngressManager:
+				if typed.Namespace == "" {
+					typed.Namespace = namespace
+				}
+				policies = append(policies, *typed)
+			}
+		}
+	}
+
+	r := &CustomIngressManagerReconciler{Log: log, Config: cfg}
+
+	var manifests []runtime.Object
+	rootCAExported := false
+	for i := range services {
+		service := services[i]
+		policy := r.SelectPolicy(policies, &service)
+		if !r.IsValidService(&service, policy) {
+			log.Info("skipping service " + service.Namespace + "/" + service.Name)
+
+			continue
+		}
+
+		if istio := builder.RouteIstio(policy); istio != nil {
+			manifests = append(manifests, builder.IstioGateway(cfg, service, policy), builder.VirtualService(cfg, service, policy))
+		} else if gateway := builder.RouteGateway(policy); gateway != nil {
+			manifests = append(manifests, builder.HTTPRoute(cfg, service, gateway))
+			if gateway.ManageListener {
+				// the Gateway is not ours, its listeners are added by the manager
+				log.Info("the listener of service " + service.Namespace + "/" + service.Name + " on gateway " + gateway.Name + " is not exported")
+				if grant := builder.ReferenceGrant(cfg, service, gateway); grant != nil {
+					manifests = append(manifests, grant)
+				}
+			}
+		} else if traefik := builder.RouteTraefik(policy); traefik != nil {
+			manifests = append(manifests, builder.IngressRoute(cfg, service, traefik))
+		} else if openShift := builder.RouteOpenShift(policy); openShift != nil {
+			// the certificate is copied into the Route by the manager once issued
+			manifests = append(manifests, builder.OpenShiftRoute(cfg, service, openShift, nil))
+		} else {
+			ingress := builder.Ingress(cfg, service, policy)
+			ingress.Annotations = MergeManagedAnnotations(nil, ingress.Annotations)
+			manifests = append(manifests, &ingress)
+		}
+
+		if cfg.ExternalDNS == config.ExternalDNSEndpoint {
+			// load balancer addresses are only known in the cluster
+			if options, _ := cfg.DNSOptions(service.ObjectMeta.Annotations); len(options.Targets) > 0 {
+				manifests = append(manifests, builder.DNSEndpoint(cfg, service, options.Targets, options.TTL))
+			} else {
+				log.Info("the dns endpoint of service " + service.Namespace + "/" + service.Name + " has no target and is not exported")
+			}
+		}
+
+		if cfg.CertificateBackend == config.CertificateBackendACME {
+			continue
+		}
+
+		if cfg.IssuanceMode(&service, policy) == config.IssuanceModeCA && !rootCAExported {
+			selfSignedIssuer, rootCA := builder.RootCA(cfg)
+			manifests = append(manifests, &selfSignedIssuer, &rootCA)
+			rootCAExported = true
+		}
+
+		clusterIssuer, err := builder.ClusterIssuer(cfg, service, policy)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, &clusterIssuer)
+
+		if builder.ManagesCertificate(cfg, policy) {
+			certificate := builder.Certificate(cfg, service, policy)
+			manifests = append(manifests, &certificate)
+		}
+	}
+
+	for i, manifest := range manifests {
+		accessor, err := meta.Accessor(manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		// the services are not in the cluster yet, there is no UID to own the objects
+		accessor.SetOwnerReferences(nil)
+
+		gvk, err := apiutil.GVKForObject(manifest, scheme)
+		if err != nil {
+			return nil, err
+		}
+		manifest.GetObjectKind().SetGroupVersionKind(gvk)
+
+		if manifests[i], err = builder.CertManagerObject(manifest, cfg.CertManagerAPIVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifests, nil
+}
+
+// WriteManifests writes the manifests as a multi-document YAML stream.
+func WriteManifests(w io.Writer, manifests []runtime.Object) error {
+	for i, manifest := range manifests {
+		out, err := yaml.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			if _, err := fmt.Fprintln(w, "---"); err != nil {
+				return err
+			}
+		}
+
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteManifestsToDir writes every manifest to its own <kind>-<namespace>-<name>.yaml file in dir.
+func WriteManifestsToDir(dir string, manifests []runtime.Object) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		accessor, err := meta.Accessor(manifest)
+		if err != nil {
+			return err
+		}
+
+		parts := []string{strings.ToLower(manifest.GetObjectKind().GroupVersionKind().Kind)}
+		if accessor.GetNamespace() != "" {
+			parts = append(parts, accessor.GetNamespace())
+		}
+		parts = append(parts, accessor.GetName())
+
+		out, err := yaml.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, strings.Join(parts, "-")+".yaml"), out, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
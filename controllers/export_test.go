@@ -0,0 +1,136 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	"k8s.io/api/extensions/v1beta1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"customingressmanager/pkg/config"
+)
+
+const exportInput = `apiVersion: v1
+kind: Service
+metadata:
+  name: testsvc
+  labels:
+    feladat.banzaicloud.io/ingress: secure
+  annotations:
+    feladat.banzaicloud.io/domain: testsvc.com
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: testsvc
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: unlabelled
+---
+apiVersion: webapp.feladat.banzaicloud.io/v1
+kind: CustomIngressManager
+metadata:
+  name: vault
+spec:
+  issuer:
+    vault:
+      server: https://vault.example.com
+      path: pki/sign/example
+`
+
+func TestExportManifests(t *testing.T) {
+	InitTestScheme()
+	_ = clientgoscheme.AddToScheme(testScheme)
+
+	cfg := issuerServerConfig()
+	cfg.ManageCertificates = true
+	manifests, err := ExportManifests(cfg, testScheme, []io.Reader{strings.NewReader(exportInput)}, "team-a", ctrl.Log.WithName("export"))
+	if err != nil {
+		t.Fatalf("ExportManifests() error = %v", err)
+	}
+	if len(manifests) != 3 {
+		t.Fatalf("ExportManifests() returned %d manifests, want ingress, cluster issuer and certificate", len(manifests))
+	}
+
+	ingress := manifests[0].(*v1beta1.Ingress)
+	if ingress.Namespace != "team-a" || ingress.Spec.Rules[0].Host != "testsvc.com" || len(ingress.OwnerReferences) != 0 {
+		t.Errorf("exported ingress = %+v, want testsvc.com in team-a without owner", ingress.ObjectMeta)
+	}
+	if _, ok := ingress.Annotations[config.ManagedAnnotationsAnnotation]; ok {
+		t.Errorf("exported ingress annotations = %v, want no managed annotations list", ingress.Annotations)
+	}
+	clusterIssuer := manifests[1].(*v1alpha3.ClusterIssuer)
+	if clusterIssuer.Spec.Vault == nil || clusterIssuer.Kind != "ClusterIssuer" || clusterIssuer.APIVersion != "cert-manager.io/v1alpha3" {
+		t.Errorf("exported cluster issuer = %+v, want Vault issuer from the policy", clusterIssuer)
+	}
+
+	var out bytes.Buffer
+	if err := WriteManifests(&out, manifests); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out.String(), "\n---\n"); got != 2 {
+		t.Errorf("WriteManifests() wrote %d separators, want 2", got)
+	}
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := WriteManifestsToDir(dir, manifests); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ingress-team-a-testsvc-ingress.yaml", "clusterissuer-testsvc-lets-encrypt-staging.yaml", "certificate-team-a-testsvc-certificate.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("WriteManifestsToDir() did not write %s", name)
+		}
+	}
+}
+
+func TestExportManifests_CertManagerV1(t *testing.T) {
+	InitTestScheme()
+	_ = clientgoscheme.AddToScheme(testScheme)
+
+	cfg := issuerServerConfig()
+	cfg.ManageCertificates = true
+	cfg.CertManagerAPIVersion = config.CertManagerAPIVersionV1
+	manifests, err := ExportManifests(cfg, testScheme, []io.Reader{strings.NewReader(exportInput)}, "team-a", ctrl.Log.WithName("export"))
+	if err != nil {
+		t.Fatalf("ExportManifests() error = %v", err)
+	}
+
+	for i, want := range []string{"extensions/v1beta1", "cert-manager.io/v1", "cert-manager.io/v1"} {
+		if got := manifests[i].GetObjectKind().GroupVersionKind().GroupVersion().String(); got != want {
+			t.Errorf("ExportManifests() manifest %d apiVersion = %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// PublishDNSForService creates or updates the DNSEndpoint of the service in the dnsendpoint
+// mode, and deletes it otherwise. The record points at the configured targets, or at the load
+// balancer of the route provider once it has an address. A domain published by another
+// DNSEndpoint is not taken over.
+func (r *CustomIngressManagerReconciler) PublishDNSForService(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	cfg := r.config()
+	serviceName := types.NamespacedName{Name: service.Name, Namespace: service.Namespace}
+
+	if cfg.ExternalDNS != config.ExternalDNSEndpoint {
+		return r.DeleteDNSEndpointForService(serviceName)
+	}
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	endpointName := types.NamespacedName{Name: cfg.CreateDNSEndpointName(service.Name), Namespace: service.Namespace}
+	owner, err := r.DNSNameOwner(domain, endpointName)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		message := config.DisplayDomain(domain) + " is published by the DNSEndpoint " + owner
+		r.Log.Info("not publishing: " + message)
+		if r.Recorder != nil {
+			r.Recorder.Event(&service, corev1.EventTypeWarning, "DomainConflict", message)
+		}
+
+		return r.DeleteDNSEndpointForService(serviceName)
+	}
+
+	options, _ := cfg.DNSOptions(service.ObjectMeta.Annotations)
+	targets := options.Targets
+	if len(targets) == 0 {
+		var err error
+		if targets, err = r.SelectedRouteProvider(policy).Targets(service, policy); err != nil {
+			return err
+		}
+	}
+
+	if len(targets) == 0 {
+		// the address is picked up by the next reconcile, the owned route objects trigger one
+		// when their status is updated
+		r.Log.Info("no load balancer address yet, set " + config.DNSTargetAnnotation + " if it is not recorded on the route")
+
+		return nil
+	}
+
+	r.Log.Info("publishing " + strings.Join(targets, ",") + " with a dns endpoint")
+
+	endpoint := builder.DNSEndpoint(cfg, service, targets, options.TTL)
+	existingEndpoint, err := r.getRouteObject(endpoint.GroupVersionKind(), types.NamespacedName{Name: endpoint.GetName(), Namespace: endpoint.GetNamespace()})
+	if err != nil {
+		return err
+	}
+
+	return r.createOrUpdateRouteObject(endpoint, existingEndpoint)
+}
+
+// DNSNameOwner returns the namespace/name of a DNSEndpoint other than the given one publishing
+// the domain, empty if there is none.
+func (r *CustomIngressManagerReconciler) DNSNameOwner(domain string, endpointName types.NamespacedName) (string, error) {
+	endpoints := &unstructured.UnstructuredList{}
+	endpoints.SetGroupVersionKind(builder.ExternalDNSGroupVersion.WithKind(builder.DNSEndpointKind + "List"))
+	if err := r.List(context.Background(), endpoints); err != nil {
+		if meta.IsNoMatchError(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	for _, endpoint := range endpoints.Items {
+		if endpoint.GetName() == endpointName.Name && endpoint.GetNamespace() == endpointName.Namespace {
+			continue
+		}
+
+		records, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+		for _, record := range records {
+			fields, ok := record.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			dnsName, _, _ := unstructured.NestedString(fields, "dnsName")
+			if strings.EqualFold(strings.TrimSuffix(dnsName, "."), domain) {
+				return endpoint.GetNamespace() + "/" + endpoint.GetName(), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// DeleteDNSEndpointForService deletes the managed DNSEndpoint of the service.
+func (r *CustomIngressManagerReconciler) DeleteDNSEndpointForService(serviceName types.NamespacedName) error {
+	existingEndpoint, err := r.getRouteObject(builder.ExternalDNSGroupVersion.WithKind(builder.DNSEndpointKind), types.NamespacedName{Name: r.config().CreateDNSEndpointName(serviceName.Name), Namespace: serviceName.Namespace})
+	if err != nil {
+		return err
+	}
+
+	return r.deleteManagedRouteObject(existingEndpoint)
+}
@@ -0,0 +1,149 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+func TestCustomIngressManagerReconciler_PublishDNSForService(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.ExternalDNS = config.ExternalDNSEndpoint
+	cfg.DNSTargetAllowlist = []string{"203.0.113.0/24"}
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+	endpointName := types.NamespacedName{Name: "testsvc-dns", Namespace: "default"}
+	getEndpoint := func() *unstructured.Unstructured {
+		endpoint, err := r.getRouteObject(builder.ExternalDNSGroupVersion.WithKind(builder.DNSEndpointKind), endpointName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return endpoint
+	}
+
+	if err := r.ExposeService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+
+	// the ingress has no address yet
+	if err := r.PublishDNSForService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.PublishDNSForService() error = %v", err)
+	}
+	if getEndpoint() != nil {
+		t.Errorf("dns endpoint was created without a target")
+	}
+
+	ingress, err := r.GetIngressByName("testsvc-ingress", "default")
+	if err != nil || ingress == nil {
+		t.Fatalf("CustomIngressManagerReconciler.GetIngressByName() = %v, %v", ingress, err)
+	}
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}
+	if err := c.Update(context.Background(), ingress); err != nil {
+		t.Fatal(err)
+	}
+
+	// the record points at the load balancer of the ingress
+	if err := r.PublishDNSForService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.PublishDNSForService() error = %v", err)
+	}
+	endpoint := getEndpoint()
+	if endpoint == nil {
+		t.Fatalf("dns endpoint was not created")
+	}
+	endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	if len(endpoints) != 1 {
+		t.Fatalf("dns endpoint endpoints = %v, want 1", endpoints)
+	}
+	record := endpoints[0].(map[string]interface{})
+	if record["dnsName"] != "test.com" || record["recordType"] != "CNAME" {
+		t.Errorf("dns endpoint record = %v, want CNAME for test.com", record)
+	}
+	if targets, _, _ := unstructured.NestedStringSlice(record, "targets"); len(targets) != 1 || targets[0] != "lb.example.com" {
+		t.Errorf("dns endpoint targets = %v, want [lb.example.com]", targets)
+	}
+
+	// the target annotation overrides the load balancer
+	service.Annotations[config.DNSTargetAnnotation] = "203.0.113.10"
+	if err := r.PublishDNSForService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.PublishDNSForService() error = %v", err)
+	}
+	endpoints, _, _ = unstructured.NestedSlice(getEndpoint().Object, "spec", "endpoints")
+	if len(endpoints) != 1 || endpoints[0].(map[string]interface{})["recordType"] != "A" {
+		t.Errorf("dns endpoint endpoints = %v, want an A record", endpoints)
+	}
+
+	// a service of another namespace can not take the domain over
+	other := *service.DeepCopy()
+	other.Namespace = "other"
+	other.Annotations[config.DNSTargetAnnotation] = "203.0.113.66"
+	if err := r.PublishDNSForService(other, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.PublishDNSForService() error = %v", err)
+	}
+	if endpoint, _ := r.getRouteObject(builder.ExternalDNSGroupVersion.WithKind(builder.DNSEndpointKind), types.NamespacedName{Name: "testsvc-dns", Namespace: "other"}); endpoint != nil {
+		t.Errorf("dns endpoint was created for a domain published by another service")
+	}
+	if owner, err := r.DNSNameOwner("TEST.com", types.NamespacedName{Name: "testsvc-dns", Namespace: "other"}); err != nil || owner != "default/testsvc-dns" {
+		t.Errorf("CustomIngressManagerReconciler.DNSNameOwner() = %v, %v, want default/testsvc-dns", owner, err)
+	}
+
+	// targets outside the allowlist are ignored
+	service.Annotations[config.DNSTargetAnnotation] = "198.51.100.1"
+	if err := r.PublishDNSForService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.PublishDNSForService() error = %v", err)
+	}
+	endpoints, _, _ = unstructured.NestedSlice(getEndpoint().Object, "spec", "endpoints")
+	if targets, _, _ := unstructured.NestedStringSlice(endpoints[0].(map[string]interface{}), "targets"); len(targets) != 1 || targets[0] != "lb.example.com" {
+		t.Errorf("dns endpoint targets = %v, want [lb.example.com]", targets)
+	}
+
+	// switching to annotations removes the endpoint
+	cfg.ExternalDNS = config.ExternalDNSAnnotations
+	if err := r.PublishDNSForService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.PublishDNSForService() error = %v", err)
+	}
+	if getEndpoint() != nil {
+		t.Errorf("dns endpoint was not deleted")
+	}
+}
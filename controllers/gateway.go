@@ -0,0 +1,269 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// IsGroupVersionServed reports whether the cluster serves the API group version.
+func IsGroupVersionServed(dc discovery.ServerGroupsInterface, groupVersion schema.GroupVersion) (bool, error) {
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return false, err
+	}
+
+	for _, group := range groups.Groups {
+		if group.Name != groupVersion.Group {
+			continue
+		}
+
+		for _, version := range group.Versions {
+			if version.Version == groupVersion.Version {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// GetHTTPRouteByName returns nil if the HTTPRoute does not exist or the cluster does not serve
+// the Gateway API.
+func (r *CustomIngressManagerReconciler) GetHTTPRouteByName(routeName, namespace string) (*unstructured.Unstructured, error) {
+	return r.getRouteObject(builder.GatewayGroupVersion.WithKind(builder.HTTPRouteKind), types.NamespacedName{Name: routeName, Namespace: namespace})
+}
+
+// CreateOrUpdateRouteForService creates or updates the HTTPRoute of the service attached to the
+// gateway, and the listener and ReferenceGrant if the listener is managed.
+func (r *CustomIngressManagerReconciler) CreateOrUpdateRouteForService(service corev1.Service, gateway *webappv1.GatewayRoutePolicy) error {
+	cfg := r.config()
+	serviceName := types.NamespacedName{Name: service.Name, Namespace: service.Namespace}
+
+	existingRoute, err := r.GetHTTPRouteByName(cfg.CreateRouteName(service.Name), service.Namespace)
+	if err != nil {
+		return err
+	}
+
+	route := builder.HTTPRoute(cfg, service, gateway)
+	if existingRoute != nil {
+		// the listeners of gateways the route is no longer attached to
+		wanted := map[types.NamespacedName]bool{}
+		for _, gatewayName := range routeListenerGateways(route, serviceName) {
+			wanted[gatewayName] = true
+		}
+		for _, gatewayName := range routeListenerGateways(existingRoute, serviceName) {
+			if wanted[gatewayName] {
+				continue
+			}
+
+			if err := r.RemoveGatewayListener(serviceName, gatewayName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if gateway.ManageListener {
+		if err := r.CreateOrUpdateReferenceGrantForService(service, gateway); err != nil {
+			return err
+		}
+
+		if err := r.EnsureGatewayListener(service, gateway); err != nil {
+			return err
+		}
+	} else if err := r.deleteReferenceGrant(serviceName); err != nil {
+		return err
+	}
+
+	return r.createOrUpdateRouteObject(route, existingRoute)
+}
+
+// routeListenerGateways returns the gateways the route is attached to through the listener
+// managed for the service.
+func routeListenerGateways(route *unstructured.Unstructured, serviceName types.NamespacedName) []types.NamespacedName {
+	parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+
+	var gateways []types.NamespacedName
+	listenerName := builder.ListenerName(serviceName.Namespace, serviceName.Name)
+	for _, parentRef := range parentRefs {
+		ref, ok := parentRef.(map[string]interface{})
+		if !ok || ref["sectionName"] != listenerName {
+			continue
+		}
+
+		gatewayName := types.NamespacedName{Name: fmt.Sprint(ref["name"]), Namespace: serviceName.Namespace}
+		if namespace, ok := ref["namespace"].(string); ok && namespace != "" {
+			gatewayName.Namespace = namespace
+		}
+		gateways = append(gateways, gatewayName)
+	}
+
+	return gateways
+}
+
+// CreateOrUpdateReferenceGrantForService lets the Gateway in another namespace read the TLS
+// secret of the service.
+func (r *CustomIngressManagerReconciler) CreateOrUpdateReferenceGrantForService(service corev1.Service, gateway *webappv1.GatewayRoutePolicy) error {
+	cfg := r.config()
+
+	grant := builder.ReferenceGrant(cfg, service, gateway)
+	existingGrant, err := r.getRouteObject(builder.ReferenceGrantGroupVersion.WithKind(builder.ReferenceGrantKind),
+		types.NamespacedName{Name: cfg.CreateTLSSecretName(service.Name), Namespace: service.Namespace})
+	if err != nil {
+		return err
+	}
+
+	if grant == nil {
+		// the gateway moved into the namespace of the service
+		return r.deleteManagedRouteObject(existingGrant)
+	}
+
+	return r.createOrUpdateRouteObject(grant, existingGrant)
+}
+
+// EnsureGatewayListener adds or updates the HTTPS listener of the service on the Gateway. Other
+// listeners are left alone.
+func (r *CustomIngressManagerReconciler) EnsureGatewayListener(service corev1.Service, gateway *webappv1.GatewayRoutePolicy) error {
+	gatewayName := types.NamespacedName{Name: gateway.Name, Namespace: builder.GatewayNamespace(service, gateway)}
+	existingGateway, err := r.getRouteObject(builder.GatewayGroupVersion.WithKind(builder.GatewayKind), gatewayName)
+	if err != nil {
+		return err
+	}
+
+	if existingGateway == nil {
+		return fmt.Errorf("gateway %s not found", gatewayName)
+	}
+
+	listener := builder.GatewayListener(r.config(), service)
+	listeners, _, err := unstructured.NestedSlice(existingGateway.Object, "spec", "listeners")
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range listeners {
+		if existing, ok := listeners[i].(map[string]interface{}); ok && existing["name"] == listener["name"] {
+			listeners[i] = listener
+			found = true
+		}
+	}
+	if !found {
+		listeners = append(listeners, listener)
+	}
+
+	return r.updateGatewayListeners(existingGateway, listeners)
+}
+
+// RemoveGatewayListener removes the listener of the service from the Gateway, if any.
+func (r *CustomIngressManagerReconciler) RemoveGatewayListener(serviceName types.NamespacedName, gatewayName types.NamespacedName) error {
+	existingGateway, err := r.getRouteObject(builder.GatewayGroupVersion.WithKind(builder.GatewayKind), gatewayName)
+	if err != nil || existingGateway == nil {
+		return err
+	}
+
+	listenerName := builder.ListenerName(serviceName.Namespace, serviceName.Name)
+	listeners, _, err := unstructured.NestedSlice(existingGateway.Object, "spec", "listeners")
+	if err != nil {
+		return err
+	}
+
+	var kept []interface{}
+	for _, listener := range listeners {
+		if existing, ok := listener.(map[string]interface{}); ok && existing["name"] == listenerName {
+			continue
+		}
+		kept = append(kept, listener)
+	}
+
+	return r.updateGatewayListeners(existingGateway, kept)
+}
+
+func (r *CustomIngressManagerReconciler) updateGatewayListeners(existingGateway *unstructured.Unstructured, listeners []interface{}) error {
+	updated := existingGateway.DeepCopy()
+	if err := unstructured.SetNestedSlice(updated.Object, listeners, "spec", "listeners"); err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(existingGateway, updated) {
+		return nil
+	}
+
+	r.Log.Info("updating the listeners of gateway " + existingGateway.GetNamespace() + "/" + existingGateway.GetName())
+
+	return r.Update(context.Background(), updated)
+}
+
+// DeleteRouteForService deletes the managed HTTPRoute of the service, with the Gateway listener
+// and ReferenceGrant managed for it. The TLS secret is deleted too if deleteSecret is set and the
+// HTTPRoute does not retain it.
+func (r *CustomIngressManagerReconciler) DeleteRouteForService(serviceName types.NamespacedName, deleteSecret bool) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	existingRoute, err := r.GetHTTPRouteByName(cfg.CreateRouteName(serviceName.Name), serviceName.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if existingRoute == nil || existingRoute.GetLabels()[config.ManagedByLabel] != config.ManagedByLabelValue {
+		return nil
+	}
+
+	for _, gatewayName := range routeListenerGateways(existingRoute, serviceName) {
+		if err := r.RemoveGatewayListener(serviceName, gatewayName); err != nil {
+			return err
+		}
+	}
+
+	if err := r.deleteReferenceGrant(serviceName); err != nil {
+		return err
+	}
+
+	r.Log.Info("deleting existing http route")
+	if err := r.Delete(ctx, existingRoute); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if deleteSecret && existingRoute.GetAnnotations()[config.RetainSecretAnnotation] != "true" {
+		return r.DeleteUnusedSecret(cfg.CreateTLSSecretName(serviceName.Name), serviceName.Namespace)
+	}
+
+	return nil
+}
+
+func (r *CustomIngressManagerReconciler) deleteReferenceGrant(serviceName types.NamespacedName) error {
+	existingGrant, err := r.getRouteObject(builder.ReferenceGrantGroupVersion.WithKind(builder.ReferenceGrantKind),
+		types.NamespacedName{Name: r.config().CreateTLSSecretName(serviceName.Name), Namespace: serviceName.Namespace})
+	if err != nil {
+		return err
+	}
+
+	return r.deleteManagedRouteObject(existingGrant)
+}
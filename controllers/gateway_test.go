@@ -0,0 +1,152 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+)
+
+func TestCustomIngressManagerReconciler_ExposeService_Gateway(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+	gatewayPolicy := &webappv1.CustomIngressManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "default"},
+		Spec: webappv1.CustomIngressManagerSpec{
+			Route: &webappv1.RoutePolicy{
+				Gateway: &webappv1.GatewayRoutePolicy{Name: "public", Namespace: "gateways", ManageListener: true},
+			},
+		},
+	}
+
+	gateway := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"listeners": []interface{}{
+				map[string]interface{}{"name": "http", "port": int64(80), "protocol": "HTTP"},
+			},
+		},
+	}}
+	gateway.SetGroupVersionKind(builder.GatewayGroupVersion.WithKind(builder.GatewayKind))
+	gateway.SetName("public")
+	gateway.SetNamespace("gateways")
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "testsvc-tls", Namespace: "default"}}
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme, gateway, secret)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+	}
+
+	listenerNames := func() []string {
+		current, err := r.getRouteObject(builder.GatewayGroupVersion.WithKind(builder.GatewayKind), types.NamespacedName{Name: "public", Namespace: "gateways"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners, _, _ := unstructured.NestedSlice(current.Object, "spec", "listeners")
+		var names []string
+		for _, listener := range listeners {
+			names = append(names, listener.(map[string]interface{})["name"].(string))
+		}
+
+		return names
+	}
+
+	// an Ingress is created without a route policy
+	if err := r.ExposeService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	existingIngress, err := r.GetIngressByName("testsvc-ingress", "default")
+	if err != nil || existingIngress == nil {
+		t.Fatalf("CustomIngressManagerReconciler.GetIngressByName() = %v, %v", existingIngress, err)
+	}
+
+	// the gateway policy replaces it with an HTTPRoute, keeping the secret
+	if err := r.ExposeService(service, gatewayPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if ingress, _ := r.GetIngressByName("testsvc-ingress", "default"); ingress != nil {
+		t.Errorf("ingress was not deleted")
+	}
+	route, err := r.GetHTTPRouteByName("testsvc-route", "default")
+	if err != nil || route == nil {
+		t.Fatalf("CustomIngressManagerReconciler.GetHTTPRouteByName() = %v, %v", route, err)
+	}
+	if hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames"); len(hostnames) != 1 || hostnames[0] != "test.com" {
+		t.Errorf("route hostnames = %v, want [test.com]", hostnames)
+	}
+	if got := listenerNames(); len(got) != 2 || got[1] != "default-testsvc" {
+		t.Errorf("gateway listeners = %v, want http and default-testsvc", got)
+	}
+	grant, _ := r.getRouteObject(builder.ReferenceGrantGroupVersion.WithKind(builder.ReferenceGrantKind), types.NamespacedName{Name: "testsvc-tls", Namespace: "default"})
+	if grant == nil {
+		t.Errorf("reference grant was not created")
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &corev1.Secret{}); err != nil {
+		t.Errorf("tls secret was deleted on the switch: %v", err)
+	}
+
+	// reconciling again changes nothing
+	if err := r.ExposeService(service, gatewayPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if got := listenerNames(); len(got) != 2 {
+		t.Errorf("gateway listeners = %v, want http and default-testsvc", got)
+	}
+
+	// removing the service tears the route down with its listener, grant and secret
+	if err := r.CleanupForService(types.NamespacedName{Name: "testsvc", Namespace: "default"}); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.CleanupForService() error = %v", err)
+	}
+	if route, _ := r.GetHTTPRouteByName("testsvc-route", "default"); route != nil {
+		t.Errorf("route was not deleted")
+	}
+	if got := listenerNames(); len(got) != 1 || got[0] != "http" {
+		t.Errorf("gateway listeners = %v, want http", got)
+	}
+	if grant, _ := r.getRouteObject(builder.ReferenceGrantGroupVersion.WithKind(builder.ReferenceGrantKind), types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}); grant != nil {
+		t.Errorf("reference grant was not deleted")
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &corev1.Secret{}); err == nil {
+		t.Errorf("tls secret was not deleted")
+	}
+
+	var ingresses v1beta1.IngressList
+	if err := c.List(context.Background(), &ingresses); err != nil || len(ingresses.Items) != 0 {
+		t.Errorf("ingresses = %v, %v, want none", ingresses.Items, err)
+	}
+}
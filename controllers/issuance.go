@@ -0,0 +1,61 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"customingressmanager/pkg/builder"
+)
+
+// EnsureRootCA creates the self-signed ClusterIssuer and the CA Certificate backing the CA
+// issuance mode. The CA secret is stored in the cluster resource namespace of cert-manager,
+// where CA ClusterIssuers look for it. Existing objects are left untouched.
+func (r *CustomIngressManagerReconciler) EnsureRootCA() error {
+	ctx := context.Background()
+	cfg := r.config()
+	selfSignedIssuer, rootCA := builder.RootCA(cfg)
+
+	existingIssuer, err := r.GetClusterIssuerByName(cfg.RootCAIssuerName)
+	if err != nil {
+		return err
+	}
+
+	if existingIssuer == nil {
+		r.Log.Info("creating root CA issuer")
+		if err := r.Create(ctx, &selfSignedIssuer); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	existingRootCA, err := r.GetCertificateByName(cfg.RootCASecretName, cfg.ClusterResourceNamespace)
+	if err != nil {
+		return err
+	}
+
+	if existingRootCA == nil {
+		r.Log.Info("creating root CA certificate")
+		// the cache may be restricted to other namespaces, so AlreadyExists is expected
+		if err := r.Create(ctx, &rootCA); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestConfig_IssuanceMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name: "Default",
+			want: config.IssuanceModeACME,
+		},
+		{
+			name:   "ProductionEnvironment",
+			labels: map[string]string{"environment": "production"},
+			want:   config.IssuanceModeACME,
+		},
+		{
+			name:   "CAEnvironment",
+			labels: map[string]string{"environment": "ca"},
+			want:   config.IssuanceModeCA,
+		},
+		{
+			name:        "AnnotationWins",
+			labels:      map[string]string{"environment": "ca"},
+			annotations: map[string]string{config.IssuanceModeAnnotation: "selfsigned"},
+			want:        config.IssuanceModeSelfSigned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      tt.labels,
+					Annotations: tt.annotations,
+				},
+			}
+			if got := config.DefaultConfig().IssuanceMode(service, nil); got != tt.want {
+				t.Errorf("Config.IssuanceMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_IsValidService_InternalDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		namespace string
+		want      bool
+	}{
+		{
+			name: "ACME",
+			mode: config.IssuanceModeACME,
+			want: false,
+		},
+		{
+			name: "CA",
+			mode: config.IssuanceModeCA,
+			want: true,
+		},
+		{
+			name:      "CAOtherNamespace",
+			mode:      config.IssuanceModeCA,
+			namespace: "other",
+			want:      false,
+		},
+		{
+			name:      "SelfSignedOtherNamespace",
+			mode:      config.IssuanceModeSelfSigned,
+			namespace: "other",
+			want:      true,
+		},
+		{
+			name: "SelfSigned",
+			mode: config.IssuanceModeSelfSigned,
+			want: true,
+		},
+		{
+			name: "Unknown",
+			mode: "vault",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.namespace == "" {
+				tt.namespace = "default"
+			}
+			r := &CustomIngressManagerReconciler{
+				Client: clientFaker.NewFakeClient(),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+			}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "testsvc",
+					Namespace:   tt.namespace,
+					Annotations: map[string]string{"feladat.banzaicloud.io/domain": "testsvc.default.svc.cluster.local", config.IssuanceModeAnnotation: tt.mode},
+					Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+				},
+			}
+			if got := r.IsValidService(service, nil); got != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.IsValidService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_CreateOrUpdateClusterIssuerForService_CA(t *testing.T) {
+	InitTestScheme()
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+	}
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "testsvc.corp"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "ca"},
+		},
+	}
+
+	// the second run updates nothing and must not fail on the existing root CA
+	for i := 0; i < 2; i++ {
+		existing, err := r.GetClusterIssuerByName("testsvc-lets-encrypt-staging")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.CreateOrUpdateClusterIssuerForService(service, nil, existing); err != nil {
+			t.Fatalf("CustomIngressManagerReconciler.CreateOrUpdateClusterIssuerForService() error = %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	var clusterIssuer v1alpha3.ClusterIssuer
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-lets-encrypt-staging"}, &clusterIssuer); err != nil {
+		t.Fatal(err)
+	}
+	if clusterIssuer.Spec.CA == nil || clusterIssuer.Spec.CA.SecretName != "customingressmanager-root-ca" || clusterIssuer.Spec.ACME != nil {
+		t.Errorf("cluster issuer spec = %+v, want CA issuer", clusterIssuer.Spec)
+	}
+
+	var selfSignedIssuer v1alpha3.ClusterIssuer
+	if err := c.Get(ctx, types.NamespacedName{Name: "customingressmanager-selfsigned"}, &selfSignedIssuer); err != nil {
+		t.Fatal(err)
+	}
+	if selfSignedIssuer.Spec.SelfSigned == nil {
+		t.Errorf("root CA issuer spec = %+v, want self-signed issuer", selfSignedIssuer.Spec)
+	}
+
+	var rootCA v1alpha3.Certificate
+	if err := c.Get(ctx, types.NamespacedName{Name: "customingressmanager-root-ca", Namespace: "cert-manager"}, &rootCA); err != nil {
+		t.Fatal(err)
+	}
+	if !rootCA.Spec.IsCA || rootCA.Spec.SecretName != "customingressmanager-root-ca" {
+		t.Errorf("root CA certificate spec = %+v, want CA certificate", rootCA.Spec)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// GetVirtualServiceByName returns the VirtualService, nil if it does not exist.
+func (r *CustomIngressManagerReconciler) GetVirtualServiceByName(name, namespace string) (*unstructured.Unstructured, error) {
+	return r.getRouteObject(builder.IstioGroupVersion.WithKind(builder.VirtualServiceKind), types.NamespacedName{Name: name, Namespace: namespace})
+}
+
+// CreateOrUpdateIstioForService creates or updates the Istio Gateway terminating TLS for the
+// service in the Istio namespace and the VirtualService routing its traffic to the service.
+func (r *CustomIngressManagerReconciler) CreateOrUpdateIstioForService(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	cfg := r.config()
+
+	gateway := builder.IstioGateway(cfg, service, policy)
+	existingGateway, err := r.getRouteObject(gateway.GroupVersionKind(), types.NamespacedName{Name: gateway.GetName(), Namespace: gateway.GetNamespace()})
+	if err != nil {
+		return err
+	}
+
+	if err := r.createOrUpdateRouteObject(gateway, existingGateway); err != nil {
+		return err
+	}
+
+	virtualService := builder.VirtualService(cfg, service, policy)
+	existingVirtualService, err := r.GetVirtualServiceByName(virtualService.GetName(), virtualService.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	return r.createOrUpdateRouteObject(virtualService, existingVirtualService)
+}
+
+// DeleteIstioForService deletes the VirtualService of the service and the Istio Gateway it is
+// bound to. The Gateway lives outside of the service namespace, so it is not garbage collected
+// with the service. The TLS secret is deleted when deleteSecret is set, unless it is retained by
+// annotation.
+func (r *CustomIngressManagerReconciler) DeleteIstioForService(serviceName types.NamespacedName, deleteSecret bool) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	existingVirtualService, err := r.GetVirtualServiceByName(cfg.CreateRouteName(serviceName.Name), serviceName.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if existingVirtualService == nil || existingVirtualService.GetLabels()[config.ManagedByLabel] != config.ManagedByLabelValue {
+		return nil
+	}
+
+	gateways, _, _ := unstructured.NestedStringSlice(existingVirtualService.Object, "spec", "gateways")
+	for _, gateway := range gateways {
+		parts := strings.SplitN(gateway, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		gatewayName := types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+		existingGateway, err := r.getRouteObject(builder.IstioGroupVersion.WithKind(builder.IstioGatewayKind), gatewayName)
+		if err != nil {
+			return err
+		}
+
+		if err := r.deleteManagedRouteObject(existingGateway); err != nil {
+			return err
+		}
+
+		if deleteSecret && existingGateway != nil && existingGateway.GetAnnotations()[config.RetainSecretAnnotation] != "true" {
+			// the gateway is named after the certificate of the service
+			if err := r.DeleteUnusedSecret(cfg.CreateTLSSecretName(gatewayName.Name), gatewayName.Namespace); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.Log.Info("deleting existing virtual service")
+
+	return client.IgnoreNotFound(r.Delete(ctx, existingVirtualService))
+}
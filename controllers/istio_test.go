@@ -0,0 +1,128 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+)
+
+func TestCustomIngressManagerReconciler_ExposeService_Istio(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+	istioPolicy := &webappv1.CustomIngressManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio", Namespace: "default"},
+		Spec: webappv1.CustomIngressManagerSpec{
+			Route: &webappv1.RoutePolicy{
+				Istio: &webappv1.IstioRoutePolicy{},
+			},
+		},
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "default-testsvc-tls", Namespace: "istio-system"}}
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme, secret)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+	}
+	gatewayName := types.NamespacedName{Name: "default-testsvc", Namespace: "istio-system"}
+	istioGateway := func() *unstructured.Unstructured {
+		gateway, err := r.getRouteObject(builder.IstioGroupVersion.WithKind(builder.IstioGatewayKind), gatewayName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return gateway
+	}
+
+	// an Ingress is created without a route policy
+	if err := r.ExposeService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	existingIngress, err := r.GetIngressByName("testsvc-ingress", "default")
+	if err != nil || existingIngress == nil {
+		t.Fatalf("CustomIngressManagerReconciler.GetIngressByName() = %v, %v", existingIngress, err)
+	}
+
+	// the istio policy replaces it with a Gateway and a VirtualService
+	if err := r.ExposeService(service, istioPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if ingress, _ := r.GetIngressByName("testsvc-ingress", "default"); ingress != nil {
+		t.Errorf("ingress was not deleted")
+	}
+	gateway := istioGateway()
+	if gateway == nil {
+		t.Fatalf("istio gateway was not created")
+	}
+	servers, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "servers")
+	if len(servers) != 1 {
+		t.Fatalf("istio gateway servers = %v, want 1", servers)
+	}
+	if got, _, _ := unstructured.NestedString(servers[0].(map[string]interface{}), "tls", "credentialName"); got != "default-testsvc-tls" {
+		t.Errorf("istio gateway credentialName = %v, want default-testsvc-tls", got)
+	}
+	virtualService, err := r.GetVirtualServiceByName("testsvc-route", "default")
+	if err != nil || virtualService == nil {
+		t.Fatalf("CustomIngressManagerReconciler.GetVirtualServiceByName() = %v, %v", virtualService, err)
+	}
+	if gateways, _, _ := unstructured.NestedStringSlice(virtualService.Object, "spec", "gateways"); len(gateways) != 1 || gateways[0] != "istio-system/default-testsvc" {
+		t.Errorf("virtual service gateways = %v, want [istio-system/default-testsvc]", gateways)
+	}
+
+	// reconciling again changes nothing
+	if err := r.ExposeService(service, istioPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if again := istioGateway(); again == nil || again.GetResourceVersion() != gateway.GetResourceVersion() {
+		t.Errorf("istio gateway was updated without changes")
+	}
+
+	// removing the service tears down the objects in the istio namespace as well
+	if err := r.CleanupForService(types.NamespacedName{Name: "testsvc", Namespace: "default"}); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.CleanupForService() error = %v", err)
+	}
+	if virtualService, _ := r.GetVirtualServiceByName("testsvc-route", "default"); virtualService != nil {
+		t.Errorf("virtual service was not deleted")
+	}
+	if istioGateway() != nil {
+		t.Errorf("istio gateway was not deleted")
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "default-testsvc-tls", Namespace: "istio-system"}, &corev1.Secret{}); err == nil {
+		t.Errorf("tls secret was not deleted")
+	}
+}
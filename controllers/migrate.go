@@ -0,0 +1,130 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"customingressmanager/pkg/config"
+)
+
+// MigrateLegacyAnnotations moves the legacy domain and email annotations of the labelled
+// services to the current keys. If the current key is already set it wins and the legacy
+// one is only removed. The legacy TLS secrets of the services are pinned, see
+// PinLegacyTLSSecret. All namespaces are migrated if namespaces is empty.
+// It returns the number of updated services.
+func MigrateLegacyAnnotations(ctx context.Context, c client.Client, cfg *config.Config, namespaces []string, log logr.Logger) (int, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{corev1.NamespaceAll}
+	}
+
+	migrated := 0
+	for _, namespace := range namespaces {
+		var services corev1.ServiceList
+		if err := c.List(ctx, &services, client.InNamespace(namespace), client.MatchingLabels{cfg.IngressLabel: cfg.IngressLabelValue}); err != nil {
+			return migrated, err
+		}
+
+		for i := range services.Items {
+			service := &services.Items[i]
+			changed := MigrateAnnotations(service.ObjectMeta.Annotations, map[string]string{
+				cfg.LegacyDomainAnnotation: cfg.DomainAnnotation,
+				cfg.LegacyEmailAnnotation:  cfg.EmailAnnotation,
+			})
+
+			pinned, err := PinLegacyTLSSecret(ctx, c, cfg, service)
+			if err != nil {
+				return migrated, err
+			}
+
+			if !changed && !pinned {
+				continue
+			}
+
+			log.Info("migrating legacy annotations", "service", service.Name, "namespace", service.Namespace)
+			if err := c.Update(ctx, service); err != nil {
+				return migrated, err
+			}
+
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+// MigrateAnnotations renames the annotations in place according to legacyToCurrent and
+// reports whether anything changed.
+func MigrateAnnotations(annotations map[string]string, legacyToCurrent map[string]string) bool {
+	changed := false
+	for legacyKey, key := range legacyToCurrent {
+		if legacyKey == "" || legacyKey == key {
+			continue
+		}
+
+		value, ok := annotations[legacyKey]
+		if !ok {
+			continue
+		}
+
+		if _, exists := annotations[key]; !exists {
+			annotations[key] = value
+		}
+		delete(annotations, legacyKey)
+		changed = true
+	}
+
+	return changed
+}
+
+// PinLegacyTLSSecret keeps the TLS secret of a service exposed before the secret was named after
+// the service. If its Ingress still serves the namespace wide <namespace>-secret, the secret is
+// pinned with the TLS secret annotation on the service, so the certificate is neither issued
+// again into a new secret nor is the old one left behind. It reports whether the annotation was
+// added, the caller updates the service.
+func PinLegacyTLSSecret(ctx context.Context, c client.Reader, cfg *config.Config, service *corev1.Service) (bool, error) {
+	if _, ok := service.ObjectMeta.Annotations[config.TLSSecretAnnotation]; ok {
+		return false, nil
+	}
+
+	var ingress v1beta1.Ingress
+	if err := c.Get(ctx, types.NamespacedName{Name: cfg.CreateIngressName(service.Name), Namespace: service.Namespace}, &ingress); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	legacySecret := cfg.CreateSecretName(service.Namespace)
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName != legacySecret {
+			continue
+		}
+
+		if service.ObjectMeta.Annotations == nil {
+			service.ObjectMeta.Annotations = map[string]string{}
+		}
+		service.ObjectMeta.Annotations[config.TLSSecretAnnotation] = legacySecret
+
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,194 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha3"
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestMigrateLegacyAnnotations(t *testing.T) {
+	InitTestScheme()
+
+	newService := func(name string, labels, annotations map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: annotations,
+			},
+		}
+	}
+	secureLabels := map[string]string{"feladat.banzaicloud.io/ingress": "secure"}
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme,
+		newService("legacy", secureLabels, map[string]string{"domain": "test.com", "email": "tes@test.com"}),
+		newService("mixed", secureLabels, map[string]string{"domain": "old.com", "feladat.banzaicloud.io/domain": "new.com", "feladat.banzaicloud.io/email": "tes@test.com"}),
+		newService("current", secureLabels, map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"}),
+		newService("unlabelled", nil, map[string]string{"domain": "test.com"}),
+	)
+
+	migrated, err := MigrateLegacyAnnotations(context.Background(), c, config.DefaultConfig(), nil, ctrl.Log.WithName("migrate"))
+	if err != nil {
+		t.Fatalf("MigrateLegacyAnnotations() error = %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("MigrateLegacyAnnotations() = %v, want %v", migrated, 2)
+	}
+
+	tests := []struct {
+		name string
+		want map[string]string
+	}{
+		{
+			name: "legacy",
+			want: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+		},
+		{
+			name: "mixed",
+			want: map[string]string{"feladat.banzaicloud.io/domain": "new.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+		},
+		{
+			name: "current",
+			want: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+		},
+		{
+			name: "unlabelled",
+			want: map[string]string{"domain": "test.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var service corev1.Service
+			if err := c.Get(context.Background(), types.NamespacedName{Name: tt.name, Namespace: "default"}, &service); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(service.Annotations, tt.want) {
+				t.Errorf("annotations = %v, want %v", service.Annotations, tt.want)
+			}
+		})
+	}
+}
+
+// TestCustomIngressManagerReconciler_Upgrade reconciles a service exposed by the baseline
+// version: an Ingress without labels serving the namespace wide secret through ingress-shim.
+func TestCustomIngressManagerReconciler_Upgrade(t *testing.T) {
+	InitTestScheme()
+
+	now := time.Now()
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+			Annotations: map[string]string{"domain": "testsvc.com", "email": "tes@test.com"},
+		},
+	}
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc-ingress",
+			Namespace:   "default",
+			Annotations: map[string]string{"cert-manager.io/cluster-issuer": "testsvc-lets-encrypt-staging"},
+		},
+		Spec: v1beta1.IngressSpec{
+			TLS: []v1beta1.IngressTLS{{Hosts: []string{"testsvc.com"}, SecretName: "default-secret"}},
+			Rules: []v1beta1.IngressRule{{
+				Host: "testsvc.com",
+				IngressRuleValue: v1beta1.IngressRuleValue{HTTP: &v1beta1.HTTPIngressRuleValue{
+					Paths: []v1beta1.HTTPIngressPath{{Path: "/", Backend: v1beta1.IngressBackend{ServiceName: "testsvc", ServicePort: intstr.FromInt(80)}}},
+				}},
+			}},
+		},
+	}
+	clusterIssuer := &v1alpha3.ClusterIssuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "testsvc-lets-encrypt-staging"},
+		Spec: v1alpha3.IssuerSpec{IssuerConfig: v1alpha3.IssuerConfig{ACME: &cmacme.ACMEIssuer{
+			Email:      "tes@test.com",
+			Server:     config.LetsEncryptStagingURL,
+			PrivateKey: cmeta1.SecretKeySelector{LocalObjectReference: cmeta1.LocalObjectReference{Name: "default-secret"}},
+		}}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-secret", Namespace: "default"},
+		Data:       map[string][]byte{corev1.TLSCertKey: testCertificatePEM(t, "testsvc.com", now, now.Add(90*24*time.Hour))},
+	}
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme, service, ingress, clusterIssuer, secret)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "testsvc", Namespace: "default"}}); err != nil {
+			t.Fatalf("CustomIngressManagerReconciler.Reconcile() error = %v", err)
+		}
+	}
+
+	var updatedService corev1.Service
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc", Namespace: "default"}, &updatedService); err != nil {
+		t.Fatal(err)
+	}
+	if got := updatedService.Annotations[config.TLSSecretAnnotation]; got != "default-secret" {
+		t.Errorf("service annotation %s = %q, want default-secret", config.TLSSecretAnnotation, got)
+	}
+
+	var updatedIngress v1beta1.Ingress
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-ingress", Namespace: "default"}, &updatedIngress); err != nil {
+		t.Fatal(err)
+	}
+	if got := updatedIngress.Spec.TLS[0].SecretName; got != "default-secret" {
+		t.Errorf("ingress tls secret = %q, want default-secret", got)
+	}
+	if got := updatedIngress.Annotations[config.ClusterIssuerAnnotation]; got != "testsvc-lets-encrypt-staging" {
+		t.Errorf("ingress cluster issuer = %q, want the baseline issuer", got)
+	}
+
+	var certificates v1alpha3.CertificateList
+	if err := c.List(context.Background(), &certificates); err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates.Items) != 0 {
+		t.Errorf("certificates = %d, want none, ingress-shim keeps issuing", len(certificates.Items))
+	}
+
+	var secrets corev1.SecretList
+	if err := c.List(context.Background(), &secrets, client.InNamespace("default")); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != "default-secret" {
+		t.Errorf("secrets = %v, want only default-secret", secrets.Items)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+)
+
+// CreateOrUpdateOpenShiftRouteForService creates or updates the OpenShift Route of the service
+// with the certificate and key of its TLS secret, if it is already issued.
+func (r *CustomIngressManagerReconciler) CreateOrUpdateOpenShiftRouteForService(service corev1.Service, openShift *webappv1.OpenShiftRoutePolicy) error {
+	cfg := r.config()
+
+	secret := &corev1.Secret{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: cfg.CreateTLSSecretName(service.Name), Namespace: service.Namespace}, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		secret = nil
+	}
+
+	route := builder.OpenShiftRoute(cfg, service, openShift, secret)
+	existingRoute, err := r.getRouteObject(route.GroupVersionKind(), types.NamespacedName{Name: route.GetName(), Namespace: route.GetNamespace()})
+	if err != nil {
+		return err
+	}
+
+	return r.createOrUpdateRouteObject(route, existingRoute)
+}
@@ -0,0 +1,75 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+)
+
+// GetPolicyForService returns the CustomIngressManager policy of the service: the first one by
+// name in the namespace of the service whose service selector matches it. Nil if there is none.
+func (r *CustomIngressManagerReconciler) GetPolicyForService(service *corev1.Service) (*webappv1.CustomIngressManager, error) {
+	var policies webappv1.CustomIngressManagerList
+	if err := r.List(context.Background(), &policies, client.InNamespace(service.Namespace)); err != nil {
+		return nil, err
+	}
+
+	return r.SelectPolicy(policies.Items, service), nil
+}
+
+// SelectPolicy returns the first policy by name whose service selector matches the service, nil
+// if there is none. Policies in other namespaces are ignored.
+func (r *CustomIngressManagerReconciler) SelectPolicy(policies []webappv1.CustomIngressManager, service *corev1.Service) *webappv1.CustomIngressManager {
+	sorted := make([]webappv1.CustomIngressManager, 0, len(policies))
+	for _, policy := range policies {
+		if policy.Namespace == service.Namespace {
+			sorted = append(sorted, policy)
+		}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for i := range sorted {
+		policy := &sorted[i]
+		if policy.Spec.ServiceSelector == nil {
+			return policy
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.ServiceSelector)
+		if err != nil {
+			r.Log.Info("ignoring policy " + policy.Name + " with invalid service selector: " + err.Error())
+
+			continue
+		}
+
+		if selector.Matches(labels.Set(service.Labels)) {
+			return policy
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+func vaultPolicy(name string, selector *metav1.LabelSelector) *webappv1.CustomIngressManager {
+	return &webappv1.CustomIngressManager{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: webappv1.CustomIngressManagerSpec{
+			ServiceSelector: selector,
+			Issuer: &webappv1.IssuerPolicy{
+				Vault: &webappv1.VaultIssuerPolicy{
+					Server: "https://vault.example.com",
+					Path:   "pki/sign/example",
+				},
+			},
+		},
+	}
+}
+
+// issuerServerConfig returns the config allowing the Vault server of vaultPolicy.
+func issuerServerConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.IssuerServers = []config.IssuerServer{
+		{
+			URL: "https://vault.example.com",
+			VaultAuth: &webappv1.VaultAuthPolicy{
+				TokenSecretRef: &webappv1.SecretKeyReference{Name: "vault-token", Key: "token"},
+			},
+		},
+	}
+
+	return cfg
+}
+
+func TestCustomIngressManagerReconciler_GetPolicyForService(t *testing.T) {
+	InitTestScheme()
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testsvc",
+			Namespace: "default",
+			Labels:    map[string]string{"team": "payments"},
+		},
+	}
+	tests := []struct {
+		name     string
+		policies []runtime.Object
+		want     string
+	}{
+		{
+			name: "NoPolicy",
+			want: "",
+		},
+		{
+			name:     "MatchingSelector",
+			policies: []runtime.Object{vaultPolicy("payments", &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}})},
+			want:     "payments",
+		},
+		{
+			name:     "OtherSelector",
+			policies: []runtime.Object{vaultPolicy("search", &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}})},
+			want:     "",
+		},
+		{
+			name: "FirstByName",
+			policies: []runtime.Object{
+				vaultPolicy("b-all", nil),
+				vaultPolicy("a-payments", &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}),
+			},
+			want: "a-payments",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &CustomIngressManagerReconciler{
+				Client: clientFaker.NewFakeClientWithScheme(testScheme, tt.policies...),
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: testScheme,
+			}
+			got, err := r.GetPolicyForService(service)
+			if err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.GetPolicyForService() error = %v", err)
+			}
+			gotName := ""
+			if got != nil {
+				gotName = got.Name
+			}
+			if gotName != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.GetPolicyForService() = %v, want %v", gotName, tt.want)
+			}
+		})
+	}
+}
+
+func gatewayPolicy(name, sectionName string) *webappv1.CustomIngressManager {
+	return &webappv1.CustomIngressManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "default"},
+		Spec: webappv1.CustomIngressManagerSpec{
+			Route: &webappv1.RoutePolicy{
+				Gateway: &webappv1.GatewayRoutePolicy{Name: name, SectionName: sectionName, ManageListener: true},
+			},
+		},
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	noPath := vaultPolicy("nopath", nil)
+	noPath.Spec.Issuer.Vault.Path = ""
+
+	both := vaultPolicy("both", nil)
+	both.Spec.Issuer.Venafi = &webappv1.VenafiIssuerPolicy{Zone: "zone"}
+
+	istio := gatewayPolicy("", "")
+	istio.Spec.Route = &webappv1.RoutePolicy{Istio: &webappv1.IstioRoutePolicy{Namespace: "istio-ingress"}}
+
+	badIstioNamespace := gatewayPolicy("", "")
+	badIstioNamespace.Spec.Route = &webappv1.RoutePolicy{Istio: &webappv1.IstioRoutePolicy{Namespace: "Istio_System"}}
+
+	bothRoutes := gatewayPolicy("public", "")
+	bothRoutes.Spec.Route.Istio = &webappv1.IstioRoutePolicy{}
+
+	traefik := gatewayPolicy("", "")
+	traefik.Spec.Route = &webappv1.RoutePolicy{Traefik: &webappv1.TraefikRoutePolicy{}}
+
+	traefikAndOpenShift := gatewayPolicy("", "")
+	traefikAndOpenShift.Spec.Route = &webappv1.RoutePolicy{Traefik: &webappv1.TraefikRoutePolicy{}, OpenShift: &webappv1.OpenShiftRoutePolicy{}}
+
+	venafi := &webappv1.CustomIngressManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "venafi", Namespace: "default"},
+		Spec: webappv1.CustomIngressManagerSpec{
+			Issuer: &webappv1.IssuerPolicy{
+				Venafi: &webappv1.VenafiIssuerPolicy{
+					Zone: "DevOps\\Default",
+					TPP:  &webappv1.VenafiTPPPolicy{URL: "https://tpp.example.com/vedsdk"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		policy *webappv1.CustomIngressManager
+		want   bool
+	}{
+		{name: "NoPolicy", policy: nil, want: true},
+		{name: "Vault", policy: vaultPolicy("vault", nil), want: true},
+		{name: "Venafi", policy: venafi, want: true},
+		{name: "VaultWithoutPath", policy: noPath, want: false},
+		{name: "BothBackends", policy: both, want: false},
+		{name: "Gateway", policy: gatewayPolicy("public", ""), want: true},
+		{name: "GatewayWithoutName", policy: gatewayPolicy("", ""), want: false},
+		{name: "ManagedListenerWithSectionName", policy: gatewayPolicy("public", "https"), want: false},
+		{name: "Istio", policy: istio, want: true},
+		{name: "IstioInvalidNamespace", policy: badIstioNamespace, want: false},
+		{name: "GatewayAndIstio", policy: bothRoutes, want: false},
+		{name: "Traefik", policy: traefik, want: true},
+		{name: "TraefikAndOpenShift", policy: traefikAndOpenShift, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.ValidatePolicy(tt.policy); (len(got) == 0) != tt.want {
+				t.Errorf("config.ValidatePolicy() = %v, want valid %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_CreateOrUpdateClusterIssuerForService_Vault(t *testing.T) {
+	InitTestScheme()
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: issuerServerConfig(),
+	}
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "testsvc.corp"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+	policy := vaultPolicy("vault", nil)
+
+	if !r.IsValidService(&service, policy) {
+		t.Fatal("CustomIngressManagerReconciler.IsValidService() = false, want true")
+	}
+	if err := r.CreateOrUpdateClusterIssuerForService(service, policy, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.CreateOrUpdateClusterIssuerForService() error = %v", err)
+	}
+
+	var clusterIssuer v1alpha3.ClusterIssuer
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-lets-encrypt-staging"}, &clusterIssuer); err != nil {
+		t.Fatal(err)
+	}
+	vault := clusterIssuer.Spec.Vault
+	if vault == nil || clusterIssuer.Spec.ACME != nil {
+		t.Fatalf("cluster issuer spec = %+v, want Vault issuer", clusterIssuer.Spec)
+	}
+	if vault.Path != "pki/sign/example" || vault.Auth.TokenSecretRef == nil || vault.Auth.TokenSecretRef.Name != "vault-token" {
+		t.Errorf("vault issuer = %+v, want policy path and token secret", vault)
+	}
+}
+
+func TestCustomIngressManagerReconciler_IsValidService_IssuerServer(t *testing.T) {
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "testsvc.corp"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+	}
+
+	// a tenant pointing the policy at its own server
+	exfiltrate := vaultPolicy("vault", nil)
+	exfiltrate.Spec.Issuer.Vault.Server = "https://vault.attacker.example"
+
+	tests := []struct {
+		name   string
+		config *config.Config
+		policy *webappv1.CustomIngressManager
+		want   bool
+	}{
+		{name: "ConfiguredServer", config: issuerServerConfig(), policy: vaultPolicy("vault", nil), want: true},
+		{name: "OtherServer", config: issuerServerConfig(), policy: exfiltrate, want: false},
+		{name: "NoServers", config: config.DefaultConfig(), policy: vaultPolicy("vault", nil), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &CustomIngressManagerReconciler{
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Config: tt.config,
+			}
+			if got := r.IsValidService(service.DeepCopy(), tt.policy); got != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.IsValidService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// Resolver looks up the addresses of host names, *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewResolver returns a resolver querying the DNS server at address, the system resolver if
+// address is empty.
+func NewResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+func (r *CustomIngressManagerReconciler) resolver() Resolver {
+	if r.Resolver != nil {
+		return r.Resolver
+	}
+
+	return NewResolver(r.config().DNSResolver)
+}
+
+// WaitForDNS reports whether the production ACME certificate of the service has to wait for
+// its domain to resolve to the load balancer. Only the first production order is held, issued
+// tells whether it was placed already.
+func (r *CustomIngressManagerReconciler) WaitForDNS(service corev1.Service, policy *webappv1.CustomIngressManager, issued bool) (bool, error) {
+	cfg := r.config()
+
+	if !cfg.DNSPreflight || issued || !cfg.UsesProductionACME(&service, policy) {
+		return false, nil
+	}
+
+	ready, reason, err := r.CheckDNSPreflight(service, policy)
+	if err != nil || ready {
+		return false, err
+	}
+
+	r.Log.Info("waiting for DNS: " + reason)
+	if r.Recorder != nil {
+		r.Recorder.Event(&service, corev1.EventTypeNormal, "WaitingForDNS", reason)
+	}
+
+	return true, nil
+}
+
+// CheckDNSPreflight reports whether every address of the domain of the service is one of the
+// load balancer addresses serving it, the configured DNS targets if any. The reason is returned
+// when it is not.
+func (r *CustomIngressManagerReconciler) CheckDNSPreflight(service corev1.Service, policy *webappv1.CustomIngressManager) (bool, string, error) {
+	ctx := context.Background()
+	cfg := r.config()
+	resolver := r.resolver()
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	options, _ := cfg.DNSOptions(service.ObjectMeta.Annotations)
+
+	targets := options.Targets
+	if len(targets) == 0 {
+		var err error
+		if targets, err = r.SelectedRouteProvider(policy).Targets(service, policy); err != nil {
+			return false, "", err
+		}
+	}
+
+	if len(targets) == 0 {
+		return false, "the load balancer has no address yet", nil
+	}
+
+	expected := map[string]bool{}
+	for _, target := range targets {
+		if ip := net.ParseIP(target); ip != nil {
+			expected[ip.String()] = true
+
+			continue
+		}
+
+		addresses, err := resolver.LookupIPAddr(ctx, target)
+		if err != nil {
+			return false, "unable to resolve the load balancer " + target + ": " + err.Error(), nil
+		}
+		for _, address := range addresses {
+			expected[address.IP.String()] = true
+		}
+	}
+
+	addresses, err := resolver.LookupIPAddr(ctx, domain)
+	if err != nil {
+		return false, "unable to resolve " + config.DisplayDomain(domain) + ": " + err.Error(), nil
+	}
+
+	var foreign []string
+	for _, address := range addresses {
+		if !expected[address.IP.String()] {
+			foreign = append(foreign, address.IP.String())
+		}
+	}
+
+	if len(addresses) == 0 || len(foreign) > 0 {
+		sort.Strings(foreign)
+
+		return false, config.DisplayDomain(domain) + " resolves to " + strings.Join(foreign, ",") + " instead of the load balancer " + strings.Join(targets, ","), nil
+	}
+
+	return true, "", nil
+}
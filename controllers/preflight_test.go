@@ -0,0 +1,288 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// startStubDNS serves the A records of the zone over UDP until the connection is closed.
+// Other names do not exist.
+func startStubDNS(t *testing.T, zone map[string]string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+
+			question := query.Questions[0]
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			ip, ok := zone[strings.TrimSuffix(question.Name.String(), ".")]
+			switch {
+			case !ok:
+				response.Header.RCode = dnsmessage.RCodeNameError
+			case question.Type == dnsmessage.TypeA:
+				var a dnsmessage.AResource
+				copy(a.A[:], net.ParseIP(ip).To4())
+				response.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &a,
+				}}
+			}
+
+			packed, err := response.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestCustomIngressManagerReconciler_CheckDNSPreflight(t *testing.T) {
+	InitTestScheme()
+
+	dns := startStubDNS(t, map[string]string{
+		"ready.com":      "203.0.113.10",
+		"elsewhere.com":  "198.51.100.1",
+		"lb.example.com": "203.0.113.10",
+	})
+	defer dns.Close()
+
+	tests := []struct {
+		name        string
+		domain      string
+		annotations map[string]string
+		lbHostname  string
+		want        bool
+	}{
+		{name: "NoLoadBalancerAddress", domain: "ready.com", want: false},
+		{name: "ResolvesToLoadBalancer", domain: "ready.com", lbHostname: "lb.example.com", want: true},
+		{name: "ResolvesElsewhere", domain: "elsewhere.com", lbHostname: "lb.example.com", want: false},
+		{name: "DoesNotResolve", domain: "missing.com", lbHostname: "lb.example.com", want: false},
+		{name: "TargetAnnotation", domain: "ready.com", annotations: map[string]string{config.DNSTargetAnnotation: "203.0.113.10"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "testsvc",
+					Namespace:   "default",
+					Annotations: map[string]string{"feladat.banzaicloud.io/domain": tt.domain, "feladat.banzaicloud.io/email": "tes@test.com"},
+					Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "production"},
+				},
+			}
+			for key, value := range tt.annotations {
+				service.Annotations[key] = value
+			}
+
+			cfg := config.DefaultConfig()
+			cfg.DNSPreflight = true
+			cfg.DNSResolver = dns.LocalAddr().String()
+			cfg.DNSTargetAllowlist = []string{"203.0.113.10"}
+			c := clientFaker.NewFakeClientWithScheme(testScheme)
+			r := &CustomIngressManagerReconciler{
+				Client: c,
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: testScheme,
+				Config: cfg,
+			}
+
+			if err := r.ExposeService(service, nil); err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+			}
+			if tt.lbHostname != "" {
+				ingress, _ := r.GetIngressByName("testsvc-ingress", "default")
+				ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: tt.lbHostname}}
+				if err := c.Update(context.Background(), ingress); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, reason, err := r.CheckDNSPreflight(service, nil)
+			if err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.CheckDNSPreflight() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.CheckDNSPreflight() = %v (%s), want %v", got, reason, tt.want)
+			}
+
+			waiting, err := r.WaitForDNS(service, nil, false)
+			if err != nil || waiting == tt.want {
+				t.Errorf("CustomIngressManagerReconciler.WaitForDNS() = %v, %v, want %v", waiting, err, !tt.want)
+			}
+			if waiting, _ := r.WaitForDNS(service, nil, true); waiting {
+				t.Errorf("CustomIngressManagerReconciler.WaitForDNS() = true after the production order")
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_CheckDNSPreflight_Providers(t *testing.T) {
+	InitTestScheme()
+
+	dns := startStubDNS(t, map[string]string{"ready.com": "203.0.113.10"})
+	defer dns.Close()
+
+	routePolicy := func(route *webappv1.RoutePolicy) *webappv1.CustomIngressManager {
+		return &webappv1.CustomIngressManager{
+			ObjectMeta: metav1.ObjectMeta{Name: "route", Namespace: "default"},
+			Spec:       webappv1.CustomIngressManagerSpec{Route: route},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		policy       *webappv1.CustomIngressManager
+		loadBalancer types.NamespacedName
+	}{
+		{
+			name:         "Istio",
+			policy:       routePolicy(&webappv1.RoutePolicy{Istio: &webappv1.IstioRoutePolicy{}}),
+			loadBalancer: types.NamespacedName{Name: "istio-ingressgateway", Namespace: "istio-system"},
+		},
+		{
+			name:         "IstioGatewayNamespace",
+			policy:       routePolicy(&webappv1.RoutePolicy{Istio: &webappv1.IstioRoutePolicy{Namespace: "gateways"}}),
+			loadBalancer: types.NamespacedName{Name: "istio-ingressgateway", Namespace: "gateways"},
+		},
+		{
+			name:         "Traefik",
+			policy:       routePolicy(&webappv1.RoutePolicy{Traefik: &webappv1.TraefikRoutePolicy{}}),
+			loadBalancer: types.NamespacedName{Name: "traefik", Namespace: "traefik"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "testsvc",
+					Namespace:   "default",
+					Annotations: map[string]string{"feladat.banzaicloud.io/domain": "ready.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+					Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "production"},
+				},
+			}
+
+			cfg := config.DefaultConfig()
+			cfg.DNSPreflight = true
+			cfg.DNSResolver = dns.LocalAddr().String()
+			cfg.TraefikService = "traefik/traefik"
+			c := clientFaker.NewFakeClientWithScheme(testScheme)
+			r := &CustomIngressManagerReconciler{
+				Client: c,
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Scheme: testScheme,
+				Config: cfg,
+			}
+
+			if got, reason, err := r.CheckDNSPreflight(service, tt.policy); err != nil || got {
+				t.Errorf("CustomIngressManagerReconciler.CheckDNSPreflight() = %v (%s), %v, want false without the load balancer", got, reason, err)
+			}
+
+			loadBalancer := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: tt.loadBalancer.Name, Namespace: tt.loadBalancer.Namespace},
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+				Status: corev1.ServiceStatus{
+					LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}},
+				},
+			}
+			if err := c.Create(context.Background(), loadBalancer); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, reason, err := r.CheckDNSPreflight(service, tt.policy); err != nil || !got {
+				t.Errorf("CustomIngressManagerReconciler.CheckDNSPreflight() = %v (%s), %v, want true", got, reason, err)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_IsValidService_TraefikPreflight(t *testing.T) {
+	policy := &webappv1.CustomIngressManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "route", Namespace: "default"},
+		Spec:       webappv1.CustomIngressManagerSpec{Route: &webappv1.RoutePolicy{Traefik: &webappv1.TraefikRoutePolicy{}}},
+	}
+
+	tests := []struct {
+		name           string
+		traefikService string
+		annotations    map[string]string
+		want           bool
+	}{
+		{name: "UnknownLoadBalancer", want: false},
+		{name: "TraefikService", traefikService: "traefik/traefik", want: true},
+		{name: "TargetAnnotation", annotations: map[string]string{config.DNSTargetAnnotation: "203.0.113.10"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "testsvc",
+					Namespace:   "default",
+					Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+					Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "production"},
+				},
+			}
+			for key, value := range tt.annotations {
+				service.Annotations[key] = value
+			}
+
+			cfg := config.DefaultConfig()
+			cfg.DNSPreflight = true
+			cfg.TraefikService = tt.traefikService
+			cfg.DNSTargetAllowlist = []string{"203.0.113.10"}
+			r := &CustomIngressManagerReconciler{
+				Log:    ctrl.Log.WithName("customingressmanager"),
+				Config: cfg,
+			}
+
+			if got := r.IsValidService(service, policy); got != tt.want {
+				t.Errorf("CustomIngressManagerReconciler.IsValidService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
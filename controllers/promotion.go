@@ -0,0 +1,253 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+const (
+	// PromotionRequeue is the interval for re-checking the certificate of a service being promoted.
+	PromotionRequeue = time.Minute
+
+	// ServiceConditionPromoted tells whether the production certificate of a promoted service is
+	// issued, its reason is the promotion phase.
+	ServiceConditionPromoted = "Promoted"
+)
+
+// PromoteService advances the staging to production promotion of the service and returns the
+// service its certificate has to be issued for, see Config.PromotedService. promoting is true
+// until the production certificate is issued. The promotion annotations are removed from
+// services not promoted, so switching them to production again starts over. Changing the domain
+// starts over too, with a staging certificate for the new domain.
+func (r *CustomIngressManagerReconciler) PromoteService(service corev1.Service, policy *webappv1.CustomIngressManager) (corev1.Service, bool, error) {
+	cfg := r.config()
+	phase := service.ObjectMeta.Annotations[config.PromotionPhaseAnnotation]
+
+	if !cfg.Promotes(&service, policy) {
+		if _, ok := service.ObjectMeta.Annotations[config.PromotionPhaseAnnotation]; ok {
+			return service, false, r.setPromotionPhase(&service, "", "", "", "")
+		}
+
+		return service, false, nil
+	}
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+
+	if promoted, ok := service.ObjectMeta.Annotations[config.PromotionDomainAnnotation]; phase != "" && ok && promoted != domain {
+		// the production issuer of the old domain would skip the staging certificate of the new one
+		message := "domain changed from " + config.DisplayDomain(promoted) + ", issuing a staging certificate for " + config.DisplayDomain(domain) + " before the production one"
+		if err := r.setPromotionPhase(&service, config.PromotionPhaseStaging, domain, "", message); err != nil {
+			return service, true, err
+		}
+
+		return cfg.PromotedService(service), true, nil
+	} else if phase != "" && !ok {
+		// promoted before the domain was recorded
+		if err := r.setPromotionPhase(&service, phase, domain, service.ObjectMeta.Annotations[config.PromotionStagingSerialAnnotation], ""); err != nil {
+			return service, true, err
+		}
+	}
+
+	switch phase {
+	case config.PromotionPhaseStaging:
+		staging := cfg.PromotedService(service)
+		status, err := serviceStatus(context.Background(), r.Client, cfg, &staging, policy)
+		if err != nil {
+			return service, true, err
+		}
+
+		if !status.Ready {
+			return staging, true, nil
+		}
+
+		if err := r.setPromotionPhase(&service, config.PromotionPhaseProduction, domain, status.SerialNumber, "staging certificate for "+config.DisplayDomain(domain)+" issued, switching to the production ACME server"); err != nil {
+			return staging, true, err
+		}
+	case config.PromotionPhaseProduction:
+		status, err := serviceStatus(context.Background(), r.Client, cfg, &service, policy)
+		if err != nil {
+			return service, true, err
+		}
+
+		if status.SerialNumber == service.ObjectMeta.Annotations[config.PromotionStagingSerialAnnotation] {
+			return service, true, r.ReplaceStagingCertificate(&service, policy)
+		}
+
+		if status.Ready {
+			if err := r.setPromotionPhase(&service, config.PromotionPhasePromoted, domain, "", "production certificate for "+config.DisplayDomain(domain)+" issued"); err != nil {
+				return service, true, err
+			}
+		}
+	case config.PromotionPhasePromoted:
+		// nothing left to do until the domain changes
+	default:
+		issued, err := r.HasProductionCertificate(service)
+		if err != nil {
+			return service, true, err
+		}
+
+		if issued {
+			return service, false, r.setPromotionPhase(&service, config.PromotionPhasePromoted, domain, "", "production certificate for "+config.DisplayDomain(domain)+" issued before the promotion")
+		}
+
+		if err := r.setPromotionPhase(&service, config.PromotionPhaseStaging, domain, "", "issuing a staging certificate for "+config.DisplayDomain(domain)+" before the production one"); err != nil {
+			return service, true, err
+		}
+	}
+
+	return cfg.PromotedService(service), service.ObjectMeta.Annotations[config.PromotionPhaseAnnotation] != config.PromotionPhasePromoted, nil
+}
+
+// HasProductionCertificate reports whether the certificate of the service is issued by the
+// production ACME server already.
+func (r *CustomIngressManagerReconciler) HasProductionCertificate(service corev1.Service) (bool, error) {
+	cfg := r.config()
+
+	if cfg.CertificateBackend == config.CertificateBackendACME {
+		return r.HasProductionTLSSecret(service)
+	}
+
+	existingClusterIssuer, err := r.GetClusterIssuerByName(cfg.CreateClusterIssuerName(service.Name))
+	if err != nil || existingClusterIssuer == nil {
+		return false, err
+	}
+
+	return existingClusterIssuer.Spec.ACME != nil && existingClusterIssuer.Spec.ACME.Server == cfg.ACMEProductionURL, nil
+}
+
+// ReplaceStagingCertificate forces the re-issuance of the staging certificate once the cluster
+// issuer of the service uses the production ACME server, see ForceReissue. The staging TLS secret
+// is served until cert-manager replaces it, re-issuance is forced again after RenewalGracePeriod
+// if it was not, e.g. because the production order failed or was rate limited. The built-in ACME
+// client replaces the secret by itself.
+func (r *CustomIngressManagerReconciler) ReplaceStagingCertificate(service *corev1.Service, policy *webappv1.CustomIngressManager) error {
+	cfg := r.config()
+
+	if cfg.CertificateBackend == config.CertificateBackendACME {
+		return nil
+	}
+
+	// cert-manager would issue a staging certificate again before the issuer is switched
+	if issued, err := r.HasProductionCertificate(*service); err != nil || !issued {
+		return err
+	}
+
+	now := time.Now()
+	if forced, err := time.Parse(time.RFC3339, service.ObjectMeta.Annotations[config.PromotionReissueAnnotation]); err == nil && now.Sub(forced) < RenewalGracePeriod {
+		return nil
+	}
+
+	r.Log.Info("forcing re-issuance of the staging certificate of " + service.Name)
+	if err := r.ForceReissue(*service, policy); err != nil {
+		return err
+	}
+
+	desired := service.DeepCopy()
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	desired.Annotations[config.PromotionReissueAnnotation] = now.UTC().Format(time.RFC3339)
+	if err := r.Update(context.Background(), desired); err != nil {
+		return err
+	}
+
+	*service = *desired
+
+	return nil
+}
+
+// setPromotionPhase records the phase, the domain and the serial number of the staging
+// certificate on the service, together with the ServiceConditionPromoted condition, and emits a
+// Normal event with the message. An empty phase removes the annotations.
+func (r *CustomIngressManagerReconciler) setPromotionPhase(service *corev1.Service, phase, domain, stagingSerial, message string) error {
+	desired := service.DeepCopy()
+	for _, key := range []string{
+		config.PromotionPhaseAnnotation,
+		config.PromotionDomainAnnotation,
+		config.PromotionStagingSerialAnnotation,
+		config.PromotionReissueAnnotation,
+		config.PromotionConditionAnnotation,
+	} {
+		delete(desired.Annotations, key)
+	}
+
+	if phase != "" {
+		if desired.Annotations == nil {
+			desired.Annotations = map[string]string{}
+		}
+		desired.Annotations[config.PromotionPhaseAnnotation] = phase
+		desired.Annotations[config.PromotionDomainAnnotation] = domain
+		if stagingSerial != "" {
+			desired.Annotations[config.PromotionStagingSerialAnnotation] = stagingSerial
+		}
+
+		condition, err := promotionCondition(service.ObjectMeta.Annotations[config.PromotionConditionAnnotation], phase, message)
+		if err != nil {
+			return err
+		}
+		desired.Annotations[config.PromotionConditionAnnotation] = condition
+	}
+
+	r.Log.Info("promotion phase of " + service.Name + ": " + phase)
+	if err := r.Update(context.Background(), desired); err != nil {
+		return err
+	}
+
+	if r.Recorder != nil && message != "" {
+		r.Recorder.Event(desired, corev1.EventTypeNormal, "Promotion"+phase, message)
+	}
+
+	*service = *desired
+
+	return nil
+}
+
+// promotionCondition returns the encoded ServiceConditionPromoted condition of the phase. The
+// previous condition is kept if it is of the same phase and the message is empty.
+func promotionCondition(previous, phase, message string) (string, error) {
+	condition := ServiceCondition{
+		Type:               ServiceConditionPromoted,
+		Status:             corev1.ConditionFalse,
+		Reason:             phase,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+	if phase == config.PromotionPhasePromoted {
+		condition.Status = corev1.ConditionTrue
+	}
+
+	var existing ServiceCondition
+	if json.Unmarshal([]byte(previous), &existing) == nil && existing.Reason == phase {
+		if message == "" {
+			return previous, nil
+		}
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+
+	encoded, err := json.Marshal(condition)
+
+	return string(encoded), err
+}
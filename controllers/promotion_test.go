@@ -0,0 +1,206 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestCustomIngressManagerReconciler_PromoteService(t *testing.T) {
+	InitTestScheme()
+
+	ctx := context.Background()
+	now := time.Now()
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "production"},
+		},
+	}
+	certificate := &v1alpha3.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Name: "testsvc-certificate", Namespace: "default"},
+		Status: v1alpha3.CertificateStatus{
+			Conditions: []v1alpha3.CertificateCondition{{Type: v1alpha3.CertificateConditionReady, Status: cmeta1.ConditionTrue}},
+		},
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Promotion = true
+	c := clientFaker.NewFakeClientWithScheme(testScheme, service.DeepCopy(), certificate)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+
+	promote := func(wantPhase, wantEnvironment string, wantPromoting bool) corev1.Service {
+		t.Helper()
+
+		current := corev1.Service{}
+		if err := c.Get(ctx, types.NamespacedName{Name: "testsvc", Namespace: "default"}, &current); err != nil {
+			t.Fatal(err)
+		}
+
+		got, promoting, err := r.PromoteService(current, nil)
+		if err != nil {
+			t.Fatalf("CustomIngressManagerReconciler.PromoteService() error = %v", err)
+		}
+		if phase := got.Annotations[config.PromotionPhaseAnnotation]; phase != wantPhase || promoting != wantPromoting {
+			t.Fatalf("CustomIngressManagerReconciler.PromoteService() phase = %q, promoting %v, want %q, %v", phase, promoting, wantPhase, wantPromoting)
+		}
+		if environment := got.Labels["environment"]; environment != wantEnvironment {
+			t.Errorf("CustomIngressManagerReconciler.PromoteService() environment = %q, want %q", environment, wantEnvironment)
+		}
+
+		return got
+	}
+	createSecret := func(notBefore time.Time) {
+		t.Helper()
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "testsvc-tls", Namespace: "default"},
+			Data:       map[string][]byte{corev1.TLSCertKey: testCertificatePEM(t, "test.com", notBefore, now.Add(90*24*time.Hour))},
+		}
+		if err := c.Create(ctx, secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staging := promote(config.PromotionPhaseStaging, "staging", true)
+	if err := r.CreateOrUpdateClusterIssuerForService(staging, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	promote(config.PromotionPhaseStaging, "staging", true)
+
+	createSecret(now.Add(-time.Hour))
+	production := promote(config.PromotionPhaseProduction, "production", true)
+	if production.Annotations[config.PromotionStagingSerialAnnotation] == "" {
+		t.Errorf("staging certificate serial not recorded")
+	}
+
+	// the staging certificate is kept until the cluster issuer is switched
+	promote(config.PromotionPhaseProduction, "production", true)
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &corev1.Secret{}); err != nil {
+		t.Fatalf("staging certificate secret deleted before the cluster issuer was switched: %v", err)
+	}
+
+	existingClusterIssuer, err := r.GetClusterIssuerByName("testsvc-lets-encrypt-staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateOrUpdateClusterIssuerForService(production, nil, existingClusterIssuer); err != nil {
+		t.Fatal(err)
+	}
+	request := func(name string) *v1alpha3.CertificateRequest {
+		owner := &v1alpha3.Certificate{ObjectMeta: metav1.ObjectMeta{Name: "testsvc-tls", Namespace: "default"}}
+		return &v1alpha3.CertificateRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, v1alpha3.SchemeGroupVersion.WithKind(v1alpha3.CertificateKind))},
+			},
+		}
+	}
+	if err := c.Create(ctx, request("testsvc-tls-staging")); err != nil {
+		t.Fatal(err)
+	}
+
+	// re-issuance is forced, the staging certificate is served until it is replaced
+	production = promote(config.PromotionPhaseProduction, "production", true)
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &corev1.Secret{}); err != nil {
+		t.Fatalf("staging certificate secret deleted: %v", err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-tls-staging", Namespace: "default"}, &v1alpha3.CertificateRequest{}); !errors.IsNotFound(err) {
+		t.Fatalf("certificate request of the staging certificate not deleted: %v", err)
+	}
+	if production.Annotations[config.PromotionReissueAnnotation] == "" {
+		t.Errorf("forced re-issuance not recorded")
+	}
+
+	// and not forced again while cert-manager issues the production certificate
+	if err := c.Create(ctx, request("testsvc-tls-production")); err != nil {
+		t.Fatal(err)
+	}
+	promote(config.PromotionPhaseProduction, "production", true)
+	if err := c.Get(ctx, types.NamespacedName{Name: "testsvc-tls-production", Namespace: "default"}, &v1alpha3.CertificateRequest{}); err != nil {
+		t.Fatalf("certificate request of the production certificate deleted: %v", err)
+	}
+
+	if err := c.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "testsvc-tls", Namespace: "default"}}); err != nil {
+		t.Fatal(err)
+	}
+	createSecret(now)
+	promoted := promote(config.PromotionPhasePromoted, "production", false)
+	if domain := promoted.Annotations[config.PromotionDomainAnnotation]; domain != "test.com" {
+		t.Errorf("promotion domain = %q, want test.com", domain)
+	}
+	var condition ServiceCondition
+	if err := json.Unmarshal([]byte(promoted.Annotations[config.PromotionConditionAnnotation]), &condition); err != nil {
+		t.Fatalf("promotion condition: %v", err)
+	}
+	if condition.Type != ServiceConditionPromoted || condition.Status != corev1.ConditionTrue || condition.Reason != config.PromotionPhasePromoted {
+		t.Errorf("promotion condition = %+v, want a true Promoted condition", condition)
+	}
+
+	update := func(modify func(service *corev1.Service)) {
+		t.Helper()
+
+		current := corev1.Service{}
+		if err := c.Get(ctx, types.NamespacedName{Name: "testsvc", Namespace: "default"}, &current); err != nil {
+			t.Fatal(err)
+		}
+		modify(&current)
+		if err := c.Update(ctx, &current); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// services promoted before the domain was recorded keep their phase
+	update(func(service *corev1.Service) { delete(service.Annotations, config.PromotionDomainAnnotation) })
+	promoted = promote(config.PromotionPhasePromoted, "production", false)
+	if domain := promoted.Annotations[config.PromotionDomainAnnotation]; domain != "test.com" {
+		t.Errorf("promotion domain = %q, want test.com", domain)
+	}
+
+	// a new domain gets a staging certificate first, although the cluster issuer is production
+	update(func(service *corev1.Service) { service.Annotations["feladat.banzaicloud.io/domain"] = "www.test.com" })
+	staging = promote(config.PromotionPhaseStaging, "staging", true)
+	if domain := staging.Annotations[config.PromotionDomainAnnotation]; domain != "www.test.com" {
+		t.Errorf("promotion domain = %q, want www.test.com", domain)
+	}
+	promote(config.PromotionPhaseStaging, "staging", true)
+
+	update(func(service *corev1.Service) { service.Labels["environment"] = "staging" })
+	promote("", "staging", false)
+}
@@ -0,0 +1,332 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// Prefixes of the rate limit ledger keys, followed by the registered domain or the namespace
+// owning the ACME account. The values list an order per line: its time and the service.
+const (
+	RateLimitDomainKeyPrefix  = "domain."
+	RateLimitAccountKeyPrefix = "account."
+)
+
+// RateLimitBudgetRemaining is exported on the metrics endpoint of the manager.
+var RateLimitBudgetRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "customingressmanager_rate_limit_budget_remaining",
+	Help: "Production ACME certificates or orders left in the rate limit budget of a registered domain or account.",
+}, []string{"scope", "name"})
+
+func init() {
+	metrics.Registry.MustRegister(RateLimitBudgetRemaining)
+}
+
+// rateLimitBudget is a budget of the ledger key within the window.
+type rateLimitBudget struct {
+	scope  string
+	name   string
+	key    string
+	limit  int
+	window time.Duration
+}
+
+// rateLimitOrder is an order recorded in the ledger.
+type rateLimitOrder struct {
+	time    time.Time
+	service string
+}
+
+func (r *CustomIngressManagerReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+
+	return r.Client
+}
+
+// ReserveRateLimitBudget records a production ACME order of the service in the rate limit ledger.
+// Renewals only count against the account budget, like at Let's Encrypt, and a new certificate
+// already recorded for the service within the window is not counted again. If a budget is
+// exhausted nothing is recorded, a Warning event is emitted and the time until the budget frees
+// up is returned.
+func (r *CustomIngressManagerReconciler) ReserveRateLimitBudget(service corev1.Service, policy *webappv1.CustomIngressManager, renewal bool) (time.Duration, error) {
+	ctx := context.Background()
+	cfg := r.config()
+
+	if !cfg.RateLimitBudgeting() || !cfg.UsesProductionACME(&service, policy) {
+		return 0, nil
+	}
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	serviceName := service.Namespace + "/" + service.Name
+	now := time.Now()
+
+	var budgets []rateLimitBudget
+	if cfg.RateLimitDomainBudget > 0 && !renewal {
+		registered := config.RegisteredDomain(domain)
+		budgets = append(budgets, rateLimitBudget{"registered_domain", registered, RateLimitDomainKeyPrefix + registered, cfg.RateLimitDomainBudget, config.RegisteredDomainRateLimitWindow})
+	}
+	if cfg.RateLimitAccountBudget > 0 {
+		// the account key is stored per namespace
+		budgets = append(budgets, rateLimitBudget{"account", service.Namespace, RateLimitAccountKeyPrefix + service.Namespace, cfg.RateLimitAccountBudget, config.AccountRateLimitWindow})
+	}
+
+	ledger := corev1.ConfigMap{}
+	err := r.apiReader().Get(ctx, types.NamespacedName{Name: cfg.RateLimitLedger, Namespace: cfg.ClusterResourceNamespace}, &ledger)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	found := err == nil
+
+	orders := make([][]rateLimitOrder, len(budgets))
+	var exhausted []string
+	var delay time.Duration
+	for i, budget := range budgets {
+		orders[i] = activeRateLimitOrders(ledger.Data[budget.key], now.Add(-budget.window))
+
+		if !renewal {
+			for _, order := range orders[i] {
+				if order.service == serviceName {
+					r.Log.Info("rate limit budget of " + domain + " already reserved")
+
+					return 0, nil
+				}
+			}
+		}
+
+		if len(orders[i]) >= budget.limit {
+			exhausted = append(exhausted, fmt.Sprintf("%s %s: %d of %d used", budget.scope, budget.name, len(orders[i]), budget.limit))
+			if freeIn := orders[i][len(orders[i])-budget.limit].time.Add(budget.window).Sub(now); freeIn > delay {
+				delay = freeIn
+			}
+		}
+	}
+
+	data := pruneRateLimitLedger(ledger.Data, now)
+
+	if len(exhausted) > 0 {
+		setRateLimitBudgetRemaining(cfg, data)
+
+		message := fmt.Sprintf("production certificate for %s delayed by %s, rate limit budget exhausted: %s", config.DisplayDomain(domain), delay.Round(time.Second), strings.Join(exhausted, "; "))
+		r.Log.Info(message)
+		if r.Recorder != nil {
+			r.Recorder.Event(&service, corev1.EventTypeWarning, "RateLimitBudgetExhausted", message)
+		}
+
+		return delay, nil
+	}
+
+	for i, budget := range budgets {
+		orders[i] = append(orders[i], rateLimitOrder{time: now, service: serviceName})
+		data[budget.key] = formatRateLimitOrders(orders[i])
+	}
+	setRateLimitBudgetRemaining(cfg, data)
+
+	if found {
+		desired := ledger.DeepCopy()
+		desired.Data = data
+
+		r.Log.Info("updating rate limit ledger")
+
+		return 0, r.Update(ctx, desired)
+	}
+
+	ledger = corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cfg.RateLimitLedger,
+			Namespace: cfg.ClusterResourceNamespace,
+			Labels:    map[string]string{config.ManagedByLabel: config.ManagedByLabelValue},
+		},
+		Data: data,
+	}
+
+	r.Log.Info("creating rate limit ledger")
+
+	return 0, r.Create(ctx, &ledger)
+}
+
+// ReleaseRateLimitBudget removes the last order of the service from the registered domain budget
+// after its order failed without a certificate, and from the account budget as well if the ACME
+// server never accepted the order.
+func (r *CustomIngressManagerReconciler) ReleaseRateLimitBudget(service corev1.Service, policy *webappv1.CustomIngressManager, account bool) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	if !cfg.RateLimitBudgeting() || !cfg.UsesProductionACME(&service, policy) {
+		return nil
+	}
+
+	ledger := corev1.ConfigMap{}
+	if err := r.apiReader().Get(ctx, types.NamespacedName{Name: cfg.RateLimitLedger, Namespace: cfg.ClusterResourceNamespace}, &ledger); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	serviceName := service.Namespace + "/" + service.Name
+	keys := []string{RateLimitDomainKeyPrefix + config.RegisteredDomain(domain)}
+	if account {
+		keys = append(keys, RateLimitAccountKeyPrefix+service.Namespace)
+	}
+
+	data := pruneRateLimitLedger(ledger.Data, time.Now())
+	released := false
+	for _, key := range keys {
+		orders := activeRateLimitOrders(data[key], time.Time{})
+		for i := len(orders) - 1; i >= 0; i-- {
+			if orders[i].service != serviceName {
+				continue
+			}
+
+			orders = append(orders[:i], orders[i+1:]...)
+			if len(orders) > 0 {
+				data[key] = formatRateLimitOrders(orders)
+			} else {
+				delete(data, key)
+			}
+			released = true
+
+			break
+		}
+	}
+
+	if !released {
+		return nil
+	}
+
+	desired := ledger.DeepCopy()
+	desired.Data = data
+
+	r.Log.Info("releasing rate limit budget of " + domain)
+	if err := r.Update(ctx, desired); err != nil {
+		return err
+	}
+
+	setRateLimitBudgetRemaining(cfg, data)
+
+	return nil
+}
+
+// RecordRateLimitBudgets sets the remaining budgets exported on the metrics endpoint from the
+// ledger, as they were before the manager was started.
+func (r *CustomIngressManagerReconciler) RecordRateLimitBudgets(ctx context.Context) error {
+	cfg := r.config()
+
+	if !cfg.RateLimitBudgeting() {
+		return nil
+	}
+
+	ledger := corev1.ConfigMap{}
+	if err := r.apiReader().Get(ctx, types.NamespacedName{Name: cfg.RateLimitLedger, Namespace: cfg.ClusterResourceNamespace}, &ledger); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	setRateLimitBudgetRemaining(cfg, pruneRateLimitLedger(ledger.Data, time.Now()))
+
+	return nil
+}
+
+// setRateLimitBudgetRemaining replaces the remaining budgets exported on the metrics endpoint with
+// the ones of the pruned ledger data. Budgets without orders in their window are not exported.
+func setRateLimitBudgetRemaining(cfg *config.Config, data map[string]string) {
+	RateLimitBudgetRemaining.Reset()
+
+	for key, value := range data {
+		budget := rateLimitBudget{scope: "registered_domain", name: strings.TrimPrefix(key, RateLimitDomainKeyPrefix), limit: cfg.RateLimitDomainBudget}
+		if strings.HasPrefix(key, RateLimitAccountKeyPrefix) {
+			budget = rateLimitBudget{scope: "account", name: strings.TrimPrefix(key, RateLimitAccountKeyPrefix), limit: cfg.RateLimitAccountBudget}
+		}
+
+		if budget.limit > 0 {
+			RateLimitBudgetRemaining.WithLabelValues(budget.scope, budget.name).Set(float64(remainingBudget(budget, strings.Count(value, "\n")+1)))
+		}
+	}
+}
+
+func remainingBudget(budget rateLimitBudget, orders int) int {
+	if remaining := budget.limit - orders; remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// activeRateLimitOrders parses the orders of a ledger value placed after since, oldest first.
+// Unparsable lines are dropped.
+func activeRateLimitOrders(value string, since time.Time) []rateLimitOrder {
+	var orders []rateLimitOrder
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		placed, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil || !placed.After(since) {
+			continue
+		}
+
+		orders = append(orders, rateLimitOrder{time: placed, service: fields[1]})
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].time.Before(orders[j].time) })
+
+	return orders
+}
+
+func formatRateLimitOrders(orders []rateLimitOrder) string {
+	lines := make([]string, 0, len(orders))
+	for _, order := range orders {
+		lines = append(lines, order.time.UTC().Format(time.RFC3339)+" "+order.service)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// pruneRateLimitLedger returns a copy of the ledger data without the orders that left their
+// window, keys without orders are dropped.
+func pruneRateLimitLedger(data map[string]string, now time.Time) map[string]string {
+	pruned := map[string]string{}
+	for key, value := range data {
+		window := config.RegisteredDomainRateLimitWindow
+		if strings.HasPrefix(key, RateLimitAccountKeyPrefix) {
+			window = config.AccountRateLimitWindow
+		}
+
+		if orders := activeRateLimitOrders(value, now.Add(-window)); len(orders) > 0 {
+			pruned[key] = formatRateLimitOrders(orders)
+		}
+	}
+
+	return pruned
+}
@@ -0,0 +1,235 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestCustomIngressManagerReconciler_ReserveRateLimitBudget(t *testing.T) {
+	InitTestScheme()
+
+	newService := func(name, domain, environment string) corev1.Service {
+		return corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{"feladat.banzaicloud.io/domain": domain, "feladat.banzaicloud.io/email": "tes@test.com"},
+				Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": environment},
+			},
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.RateLimitDomainBudget = 1
+	cfg.RateLimitAccountBudget = 3
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+
+	steps := []struct {
+		name      string
+		service   corev1.Service
+		renewal   bool
+		wantDelay bool
+	}{
+		{name: "FirstCertificate", service: newService("shop", "shop.example.co.uk", "production")},
+		{name: "SameServiceAgain", service: newService("shop", "shop.example.co.uk", "production")},
+		{name: "SameRegisteredDomain", service: newService("blog", "blog.example.co.uk", "production"), wantDelay: true},
+		{name: "Staging", service: newService("blog", "blog.example.co.uk", "staging")},
+		{name: "OtherRegisteredDomain", service: newService("docs", "docs.example.com", "production")},
+		{name: "Renewal", service: newService("shop", "shop.example.co.uk", "production"), renewal: true},
+		{name: "AccountExhausted", service: newService("api", "api.example.org", "production"), wantDelay: true},
+	}
+	for _, step := range steps {
+		delay, err := r.ReserveRateLimitBudget(step.service, nil, step.renewal)
+		if err != nil {
+			t.Fatalf("%s: CustomIngressManagerReconciler.ReserveRateLimitBudget() error = %v", step.name, err)
+		}
+		if (delay > 0) != step.wantDelay {
+			t.Errorf("%s: CustomIngressManagerReconciler.ReserveRateLimitBudget() = %v, want delay %v", step.name, delay, step.wantDelay)
+		}
+	}
+
+	ledger := corev1.ConfigMap{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "customingressmanager-rate-limits", Namespace: "cert-manager"}, &ledger); err != nil {
+		t.Fatalf("rate limit ledger not found: %v", err)
+	}
+
+	for key, want := range map[string]int{
+		"domain.example.co.uk": 1,
+		"domain.example.com":   1,
+		"account.default":      3,
+	} {
+		if got := len(strings.Split(ledger.Data[key], "\n")); got != want {
+			t.Errorf("ledger %s has %d orders, want %d:\n%s", key, got, want, ledger.Data[key])
+		}
+	}
+	if _, ok := ledger.Data["domain.example.org"]; ok {
+		t.Errorf("ledger records the delayed order of example.org")
+	}
+}
+
+func TestCustomIngressManagerReconciler_ReleaseRateLimitBudget(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "shop",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "shop.example.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure", "environment": "production"},
+		},
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.RateLimitDomainBudget = 1
+	cfg.RateLimitAccountBudget = 3
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+	getLedger := func() corev1.ConfigMap {
+		ledger := corev1.ConfigMap{}
+		if err := c.Get(context.Background(), types.NamespacedName{Name: "customingressmanager-rate-limits", Namespace: "cert-manager"}, &ledger); err != nil {
+			t.Fatalf("rate limit ledger not found: %v", err)
+		}
+
+		return ledger
+	}
+
+	if delay, err := r.ReserveRateLimitBudget(service, nil, false); err != nil || delay > 0 {
+		t.Fatalf("CustomIngressManagerReconciler.ReserveRateLimitBudget() = %v, %v", delay, err)
+	}
+	if got := testutil.ToFloat64(RateLimitBudgetRemaining.WithLabelValues("registered_domain", "example.com")); got != 0 {
+		t.Errorf("registered domain budget remaining = %v, want 0", got)
+	}
+
+	// a failed order frees the certificate, the order still counts against the account
+	if err := r.ReleaseRateLimitBudget(service, nil, false); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ReleaseRateLimitBudget() error = %v", err)
+	}
+	ledger := getLedger()
+	if value, ok := ledger.Data["domain.example.com"]; ok {
+		t.Errorf("ledger domain.example.com = %q after the release", value)
+	}
+	if ledger.Data["account.default"] == "" {
+		t.Errorf("ledger account.default was released")
+	}
+	if got := testutil.ToFloat64(RateLimitBudgetRemaining.WithLabelValues("account", "default")); got != 2 {
+		t.Errorf("account budget remaining = %v, want 2", got)
+	}
+
+	// releasing again changes nothing
+	if err := r.ReleaseRateLimitBudget(service, nil, false); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ReleaseRateLimitBudget() error = %v", err)
+	}
+
+	// an order refused by the server releases the account budget too
+	if delay, err := r.ReserveRateLimitBudget(service, nil, false); err != nil || delay > 0 {
+		t.Fatalf("CustomIngressManagerReconciler.ReserveRateLimitBudget() = %v, %v", delay, err)
+	}
+	if err := r.ReleaseRateLimitBudget(service, nil, true); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ReleaseRateLimitBudget() error = %v", err)
+	}
+	if got := len(strings.Split(getLedger().Data["account.default"], "\n")); got != 1 {
+		t.Errorf("ledger account.default has %d orders, want 1", got)
+	}
+}
+
+func TestCustomIngressManagerReconciler_RecordRateLimitBudgets(t *testing.T) {
+	InitTestScheme()
+
+	now := time.Now()
+	ledger := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "customingressmanager-rate-limits", Namespace: "cert-manager"},
+		Data: map[string]string{
+			"domain.example.com": now.Add(-time.Hour).UTC().Format(time.RFC3339) + " default/shop",
+			"domain.example.org": now.Add(-8*24*time.Hour).UTC().Format(time.RFC3339) + " default/old",
+			"account.default":    now.Add(-time.Hour).UTC().Format(time.RFC3339) + " default/shop",
+		},
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.RateLimitDomainBudget = 5
+	cfg.RateLimitAccountBudget = 3
+	r := &CustomIngressManagerReconciler{
+		Client: clientFaker.NewFakeClientWithScheme(testScheme, ledger),
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+
+	// left over from another budget, not in the ledger
+	RateLimitBudgetRemaining.WithLabelValues("registered_domain", "example.net").Set(1)
+
+	if err := r.RecordRateLimitBudgets(context.Background()); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.RecordRateLimitBudgets() error = %v", err)
+	}
+
+	want := `
+# HELP customingressmanager_rate_limit_budget_remaining Production ACME certificates or orders left in the rate limit budget of a registered domain or account.
+# TYPE customingressmanager_rate_limit_budget_remaining gauge
+customingressmanager_rate_limit_budget_remaining{name="default",scope="account"} 2
+customingressmanager_rate_limit_budget_remaining{name="example.com",scope="registered_domain"} 4
+`
+	if err := testutil.CollectAndCompare(RateLimitBudgetRemaining, strings.NewReader(want)); err != nil {
+		t.Errorf("CustomIngressManagerReconciler.RecordRateLimitBudgets() metrics: %v", err)
+	}
+}
+
+func TestPruneRateLimitLedger(t *testing.T) {
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	data := map[string]string{
+		"domain.example.com": "2020-03-01T12:00:00Z default/old\n2020-03-09T12:00:00Z default/new",
+		"domain.example.org": "2020-03-01T12:00:00Z default/old",
+		"account.default":    "2020-03-10T08:00:00Z default/old\n2020-03-10T11:00:00Z default/new",
+	}
+
+	got := pruneRateLimitLedger(data, now)
+	want := map[string]string{
+		"domain.example.com": "2020-03-09T12:00:00Z default/new",
+		"account.default":    "2020-03-10T11:00:00Z default/new",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("pruneRateLimitLedger() = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("pruneRateLimitLedger()[%s] = %q, want %q", key, got[key], value)
+		}
+	}
+}
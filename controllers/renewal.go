@@ -0,0 +1,198 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+const (
+	// RenewalGracePeriod is the time cert-manager gets to renew a certificate after its renewal
+	// time before it is reported and re-issuance is forced.
+	RenewalGracePeriod = time.Hour
+	// PendingCertificateRequeue is the interval for re-checking a TLS secret that does not hold a
+	// certificate for the domain yet.
+	PendingCertificateRequeue = 10 * time.Minute
+	// CertificateConditionIssuing is the condition of cert-manager v1 Certificates being issued.
+	CertificateConditionIssuing v1alpha3.CertificateConditionType = "Issuing"
+)
+
+// CertificateRenewalTime returns when the PEM encoded certificate has to be renewed, false if it
+// is unparsable or not valid for the domain. Certificates living shorter than renewBefore are
+// renewed after two thirds of their lifetime, like cert-manager does.
+func CertificateRenewalTime(certPEM []byte, domain string, renewBefore time.Duration) (time.Time, bool) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || cert.VerifyHostname(domain) != nil {
+		return time.Time{}, false
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if renewBefore >= lifetime {
+		renewBefore = lifetime / 3
+	}
+
+	return cert.NotAfter.Add(-renewBefore), true
+}
+
+// CertificateRenewIn returns the time left until the PEM encoded certificate has to be renewed,
+// 0 if it is unparsable, not valid for the domain or due already.
+func CertificateRenewIn(certPEM []byte, domain string, renewBefore time.Duration, now time.Time) time.Duration {
+	renewAt, ok := CertificateRenewalTime(certPEM, domain, renewBefore)
+	if !ok || renewAt.Before(now) {
+		return 0
+	}
+
+	return renewAt.Sub(now)
+}
+
+// CheckCertificateRenewal returns when the TLS secret of the service has to be checked again: at
+// the renewal time of its certificate, or after the grace period once it is due. A certificate
+// cert-manager did not renew within the grace period is reported with a Warning event and its
+// re-issuance is forced.
+func (r *CustomIngressManagerReconciler) CheckCertificateRenewal(service corev1.Service, policy *webappv1.CustomIngressManager) (time.Duration, error) {
+	ctx := context.Background()
+	cfg := r.config()
+
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	options, _ := cfg.CertificateOptions(service.ObjectMeta.Annotations)
+
+	secret := corev1.Secret{}
+	secretName := types.NamespacedName{Name: builder.TLSSecretName(cfg, service, policy), Namespace: builder.CertificateNamespace(service, policy)}
+	if err := r.Get(ctx, secretName, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return PendingCertificateRequeue, nil
+		}
+
+		return 0, err
+	}
+
+	renewAt, ok := CertificateRenewalTime(secret.Data[corev1.TLSCertKey], domain, options.RenewBeforeDuration())
+	if !ok {
+		return PendingCertificateRequeue, nil
+	}
+
+	overdue := time.Since(renewAt)
+	if overdue < 0 {
+		return -overdue, nil
+	}
+
+	if overdue < RenewalGracePeriod {
+		return RenewalGracePeriod - overdue, nil
+	}
+
+	message := fmt.Sprintf("certificate for %s was due for renewal at %s, forcing re-issuance", config.DisplayDomain(domain), renewAt.Format(time.RFC3339))
+	r.Log.Info(message)
+	if r.Recorder != nil {
+		r.Recorder.Event(&service, corev1.EventTypeWarning, "CertificateNotRenewed", message)
+	}
+
+	if err := r.ForceReissue(service, policy); err != nil {
+		return 0, err
+	}
+
+	return RenewalGracePeriod, nil
+}
+
+// ForceReissue makes cert-manager issue a new certificate for the service instead of waiting for
+// the backoff of a failed request. cert-manager v1 only reissues on the Issuing condition, which is
+// set the way cmctl renew does. Older versions reissue once the CertificateRequests are deleted.
+func (r *CustomIngressManagerReconciler) ForceReissue(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	ctx := context.Background()
+	cfg := r.config()
+
+	certificateName := builder.CertificateName(cfg, service, policy)
+	if !builder.ManagesCertificate(cfg, policy) {
+		// ingress-shim names the certificate after the secret
+		certificateName = builder.TLSSecretName(cfg, service, policy)
+	}
+	namespace := builder.CertificateNamespace(service, policy)
+
+	if cfg.CertManagerAPIVersion == config.CertManagerAPIVersionV1 {
+		return r.triggerIssuing(certificateName, namespace)
+	}
+
+	var requests v1alpha3.CertificateRequestList
+	if err := r.List(ctx, &requests, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+
+	for i := range requests.Items {
+		owner := metav1.GetControllerOf(&requests.Items[i])
+		if owner == nil || owner.Kind != v1alpha3.CertificateKind || owner.Name != certificateName {
+			continue
+		}
+
+		r.Log.Info("deleting certificate request " + requests.Items[i].Name)
+		if err := r.Delete(ctx, &requests.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// triggerIssuing sets the Issuing condition of the certificate, unless it is issuing already.
+func (r *CustomIngressManagerReconciler) triggerIssuing(certificateName, namespace string) error {
+	certificate, err := r.GetCertificateByName(certificateName, namespace)
+	if err != nil || certificate == nil {
+		return err
+	}
+
+	condition := v1alpha3.CertificateCondition{
+		Type:               CertificateConditionIssuing,
+		Status:             cmeta1.ConditionTrue,
+		LastTransitionTime: &metav1.Time{Time: time.Now()},
+		Reason:             "ManuallyTriggered",
+		Message:            "Certificate re-issuance forced, it was not renewed in time",
+	}
+	conditions := []v1alpha3.CertificateCondition{condition}
+	for _, c := range certificate.Status.Conditions {
+		if c.Type != CertificateConditionIssuing {
+			conditions = append(conditions, c)
+			continue
+		}
+		if c.Status == cmeta1.ConditionTrue {
+			return nil
+		}
+	}
+	certificate.Status.Conditions = conditions
+
+	r.Log.Info("triggering issuance of certificate " + certificateName)
+
+	return r.Status().Update(context.Background(), certificate)
+}
@@ -0,0 +1,287 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func testCertificatePEM(t *testing.T, domain string, notBefore, notAfter time.Time) []byte {
+	key, _, err := GeneratePrivateKey(config.CertificateOptions{KeyAlgorithm: "ecdsa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notBefore.Unix()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertificateRenewIn(t *testing.T) {
+	now := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	type args struct {
+		certPEM     []byte
+		domain      string
+		renewBefore time.Duration
+	}
+	tests := []struct {
+		name string
+		args args
+		want time.Duration
+	}{
+		{
+			name: "Fresh",
+			args: args{testCertificatePEM(t, "testsvc.com", now, now.Add(90*day)), "testsvc.com", 30 * day},
+			want: 60 * day,
+		},
+		{
+			name: "DueForRenewal",
+			args: args{testCertificatePEM(t, "testsvc.com", now.Add(-80*day), now.Add(10*day)), "testsvc.com", 30 * day},
+			want: 0,
+		},
+		{
+			name: "ShortLived",
+			args: args{testCertificatePEM(t, "testsvc.com", now, now.Add(3*time.Hour)), "testsvc.com", 30 * day},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "OtherDomain",
+			args: args{testCertificatePEM(t, "other.com", now, now.Add(90*day)), "testsvc.com", 30 * day},
+			want: 0,
+		},
+		{
+			name: "NotACertificate",
+			args: args{[]byte("garbage"), "testsvc.com", 30 * day},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CertificateRenewIn(tt.args.certPEM, tt.args.domain, tt.args.renewBefore, now); got != tt.want {
+				t.Errorf("CertificateRenewIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_CheckCertificateRenewal(t *testing.T) {
+	InitTestScheme()
+
+	now := time.Now()
+	day := 24 * time.Hour
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			UID:         "testsvc-uid",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "testsvc.com"},
+		},
+	}
+	tlsSecret := func(certPEM []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "testsvc-tls", Namespace: "default"},
+			Data:       map[string][]byte{corev1.TLSCertKey: certPEM},
+		}
+	}
+	certificate := &v1alpha3.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Name: "testsvc-certificate", Namespace: "default", UID: "certificate-uid"},
+	}
+	request := &v1alpha3.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "testsvc-certificate-1234",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(certificate, v1alpha3.SchemeGroupVersion.WithKind(v1alpha3.CertificateKind))},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		objects      []runtime.Object
+		wantMin      time.Duration
+		wantMax      time.Duration
+		wantEvent    bool
+		wantRequests int
+	}{
+		{
+			name:         "NoSecret",
+			objects:      []runtime.Object{request},
+			wantMin:      PendingCertificateRequeue,
+			wantMax:      PendingCertificateRequeue,
+			wantRequests: 1,
+		},
+		{
+			name:         "Fresh",
+			objects:      []runtime.Object{tlsSecret(testCertificatePEM(t, "testsvc.com", now, now.Add(90*day))), request},
+			wantMin:      60*day - time.Minute,
+			wantMax:      60 * day,
+			wantRequests: 1,
+		},
+		{
+			name:         "WithinGracePeriod",
+			objects:      []runtime.Object{tlsSecret(testCertificatePEM(t, "testsvc.com", now.Add(-60*day), now.Add(30*day-30*time.Minute))), request},
+			wantMin:      30*time.Minute - time.Minute,
+			wantMax:      30 * time.Minute,
+			wantRequests: 1,
+		},
+		{
+			name:         "NotRenewed",
+			objects:      []runtime.Object{tlsSecret(testCertificatePEM(t, "testsvc.com", now.Add(-80*day), now.Add(10*day))), request},
+			wantMin:      RenewalGracePeriod,
+			wantMax:      RenewalGracePeriod,
+			wantEvent:    true,
+			wantRequests: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.ManageCertificates = true
+			c := clientFaker.NewFakeClientWithScheme(testScheme, tt.objects...)
+			recorder := record.NewFakeRecorder(10)
+			r := &CustomIngressManagerReconciler{
+				Client:   c,
+				Log:      ctrl.Log.WithName("customingressmanager"),
+				Scheme:   testScheme,
+				Config:   cfg,
+				Recorder: recorder,
+			}
+			got, err := r.CheckCertificateRenewal(service, nil)
+			if err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.CheckCertificateRenewal() error = %v", err)
+			}
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("CustomIngressManagerReconciler.CheckCertificateRenewal() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+			if gotEvent := len(recorder.Events) > 0; gotEvent != tt.wantEvent {
+				t.Errorf("event emitted = %v, want %v", gotEvent, tt.wantEvent)
+			}
+
+			var requests v1alpha3.CertificateRequestList
+			if err := c.List(context.Background(), &requests, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			if len(requests.Items) != tt.wantRequests {
+				t.Errorf("certificate requests = %d, want %d", len(requests.Items), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCustomIngressManagerReconciler_ForceReissue_V1(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "testsvc.com"},
+		},
+	}
+	certificate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"name": "testsvc-certificate", "namespace": "default"},
+		"spec":       map[string]interface{}{"secretName": "testsvc-tls", "dnsNames": []interface{}{"testsvc.com"}},
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "True", "reason": "Ready"},
+		}},
+	}}
+	request := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "CertificateRequest",
+		"metadata": map[string]interface{}{
+			"name":      "testsvc-certificate-1234",
+			"namespace": "default",
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "cert-manager.io/v1", "kind": "Certificate", "name": "testsvc-certificate", "uid": "certificate-uid", "controller": true,
+			}},
+		},
+	}}
+
+	cfg := config.DefaultConfig()
+	cfg.ManageCertificates = true
+	cfg.CertManagerAPIVersion = config.CertManagerAPIVersionV1
+	fakeClient := clientFaker.NewFakeClientWithScheme(testScheme, certificate, request)
+	r := &CustomIngressManagerReconciler{
+		Client: NewCertManagerClient(fakeClient, testScheme, cfg.CertManagerAPIVersion),
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+		Config: cfg,
+	}
+
+	// the second call finds the certificate issuing already
+	for i := 0; i < 2; i++ {
+		if err := r.ForceReissue(service, nil); err != nil {
+			t.Fatalf("CustomIngressManagerReconciler.ForceReissue() error = %v", err)
+		}
+	}
+
+	stored := &unstructured.Unstructured{}
+	stored.SetGroupVersionKind(certificate.GroupVersionKind())
+	if err := fakeClient.Get(context.Background(), types.NamespacedName{Name: "testsvc-certificate", Namespace: "default"}, stored); err != nil {
+		t.Fatal(err)
+	}
+	conditions, _, _ := unstructured.NestedSlice(stored.Object, "status", "conditions")
+	got := map[string]string{}
+	for _, condition := range conditions {
+		condition := condition.(map[string]interface{})
+		got[condition["type"].(string)] = condition["status"].(string) + "/" + condition["reason"].(string)
+	}
+	want := map[string]string{"Issuing": "True/ManuallyTriggered", "Ready": "True/Ready"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("certificate conditions = %v, want %v", got, want)
+	}
+
+	storedRequest := &unstructured.Unstructured{}
+	storedRequest.SetGroupVersionKind(request.GroupVersionKind())
+	if err := fakeClient.Get(context.Background(), types.NamespacedName{Name: "testsvc-certificate-1234", Namespace: "default"}, storedRequest); err != nil {
+		t.Errorf("certificate request deleted: %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// RouteProvider exposes services with one kind of object: Ingresses, Gateway API HTTPRoutes,
+// Istio VirtualServices, Traefik IngressRoutes or OpenShift Routes. The policy of a service
+// selects exactly one provider.
+type RouteProvider interface {
+	// Selected reports whether the policy exposes its services with this provider.
+	Selected(policy *webappv1.CustomIngressManager) bool
+	// CreateOrUpdate creates or updates the objects exposing the service.
+	CreateOrUpdate(service corev1.Service, policy *webappv1.CustomIngressManager) error
+	// Delete deletes the managed objects of the service. The TLS secret is deleted when
+	// deleteSecret is set, unless it is retained by annotation.
+	Delete(serviceName types.NamespacedName, deleteSecret bool) error
+	// Targets returns the load balancer addresses serving the service, nil if they are not
+	// known (yet).
+	Targets(service corev1.Service, policy *webappv1.CustomIngressManager) ([]string, error)
+}
+
+// RouteProviders returns the route providers of the reconciler.
+func (r *CustomIngressManagerReconciler) RouteProviders() []RouteProvider {
+	return []RouteProvider{
+		ingressProvider{r},
+		gatewayProvider{r},
+		istioProvider{r},
+		traefikProvider{r},
+		openShiftProvider{r},
+	}
+}
+
+// ExposeService creates or updates the objects of the route provider selected by the policy.
+// The objects of the other providers, left over from a policy change, are deleted; the TLS
+// secret is kept for the new ones.
+func (r *CustomIngressManagerReconciler) ExposeService(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	serviceName := types.NamespacedName{Name: service.Name, Namespace: service.Namespace}
+
+	var selected RouteProvider
+	for _, provider := range r.RouteProviders() {
+		if provider.Selected(policy) {
+			selected = provider
+
+			continue
+		}
+
+		if err := provider.Delete(serviceName, false); err != nil {
+			return err
+		}
+	}
+
+	return selected.CreateOrUpdate(service, policy)
+}
+
+// SelectedRouteProvider returns the route provider selected by the policy.
+func (r *CustomIngressManagerReconciler) SelectedRouteProvider(policy *webappv1.CustomIngressManager) RouteProvider {
+	for _, provider := range r.RouteProviders() {
+		if provider.Selected(policy) {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// DeleteRoutesForService deletes the objects of all route providers for the service.
+func (r *CustomIngressManagerReconciler) DeleteRoutesForService(serviceName types.NamespacedName, deleteSecret bool) error {
+	for _, provider := range r.RouteProviders() {
+		if err := provider.Delete(serviceName, deleteSecret); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type ingressProvider struct {
+	r *CustomIngressManagerReconciler
+}
+
+func (p ingressProvider) Selected(policy *webappv1.CustomIngressManager) bool {
+	return builder.UsesIngress(policy)
+}
+
+func (p ingressProvider) CreateOrUpdate(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	p.r.Log.Info("check if ingress already exists")
+	existingIngress, err := p.r.GetIngressByName(p.r.config().CreateIngressName(service.Name), service.Namespace)
+	if err != nil {
+		return err
+	}
+
+	return p.r.CreateOrUpdateIngressForService(service, policy, existingIngress)
+}
+
+func (p ingressProvider) Delete(serviceName types.NamespacedName, deleteSecret bool) error {
+	return p.r.DeleteIngressForService(serviceName, deleteSecret)
+}
+
+func (p ingressProvider) Targets(service corev1.Service, policy *webappv1.CustomIngressManager) ([]string, error) {
+	existingIngress, err := p.r.GetIngressByName(p.r.config().CreateIngressName(service.Name), service.Namespace)
+	if err != nil || existingIngress == nil {
+		return nil, err
+	}
+
+	return LoadBalancerTargets(existingIngress.Status.LoadBalancer), nil
+}
+
+type gatewayProvider struct {
+	r *CustomIngressManagerReconciler
+}
+
+func (p gatewayProvider) Selected(policy *webappv1.CustomIngressManager) bool {
+	return builder.RouteGateway(policy) != nil
+}
+
+func (p gatewayProvider) CreateOrUpdate(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	return p.r.CreateOrUpdateRouteForService(service, builder.RouteGateway(policy))
+}
+
+func (p gatewayProvider) Delete(serviceName types.NamespacedName, deleteSecret bool) error {
+	return p.r.DeleteRouteForService(serviceName, deleteSecret)
+}
+
+func (p gatewayProvider) Targets(service corev1.Service, policy *webappv1.CustomIngressManager) ([]string, error) {
+	gateway := builder.RouteGateway(policy)
+	existingGateway, err := p.r.getRouteObject(builder.GatewayGroupVersion.WithKind(builder.GatewayKind), types.NamespacedName{Name: gateway.Name, Namespace: builder.GatewayNamespace(service, gateway)})
+	if err != nil || existingGateway == nil {
+		return nil, err
+	}
+
+	addresses, _, _ := unstructured.NestedSlice(existingGateway.Object, "status", "addresses")
+
+	return nestedStrings(addresses, "value"), nil
+}
+
+type istioProvider struct {
+	r *CustomIngressManagerReconciler
+}
+
+func (p istioProvider) Selected(policy *webappv1.CustomIngressManager) bool {
+	return builder.RouteIstio(policy) != nil
+}
+
+func (p istioProvider) CreateOrUpdate(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	return p.r.CreateOrUpdateIstioForService(service, policy)
+}
+
+func (p istioProvider) Delete(serviceName types.NamespacedName, deleteSecret bool) error {
+	return p.r.DeleteIstioForService(serviceName, deleteSecret)
+}
+
+func (p istioProvider) Targets(service corev1.Service, policy *webappv1.CustomIngressManager) ([]string, error) {
+	// the address of the ingress gateway is not recorded on the Istio objects, it is the one of
+	// the gateway Service
+	return p.r.serviceLoadBalancerTargets(types.NamespacedName{
+		Name:      p.r.config().IstioGatewayService,
+		Namespace: builder.IstioNamespace(builder.RouteIstio(policy)),
+	})
+}
+
+type traefikProvider struct {
+	r *CustomIngressManagerReconciler
+}
+
+func (p traefikProvider) Selected(policy *webappv1.CustomIngressManager) bool {
+	return builder.RouteTraefik(policy) != nil
+}
+
+func (p traefikProvider) CreateOrUpdate(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	return p.r.CreateOrUpdateIngressRouteForService(service, builder.RouteTraefik(policy))
+}
+
+func (p traefikProvider) Delete(serviceName types.NamespacedName, deleteSecret bool) error {
+	return p.r.deleteServiceRouteObject(builder.TraefikGroupVersion(p.r.config()).WithKind(builder.IngressRouteKind), serviceName, deleteSecret)
+}
+
+func (p traefikProvider) Targets(service corev1.Service, policy *webappv1.CustomIngressManager) ([]string, error) {
+	// IngressRoutes have no status, the address is the one of the Traefik Service
+	namespace, name := p.r.config().TraefikServiceName()
+	if namespace == "" {
+		return nil, nil
+	}
+
+	return p.r.serviceLoadBalancerTargets(types.NamespacedName{Name: name, Namespace: namespace})
+}
+
+type openShiftProvider struct {
+	r *CustomIngressManagerReconciler
+}
+
+func (p openShiftProvider) Selected(policy *webappv1.CustomIngressManager) bool {
+	return builder.RouteOpenShift(policy) != nil
+}
+
+func (p openShiftProvider) CreateOrUpdate(service corev1.Service, policy *webappv1.CustomIngressManager) error {
+	return p.r.CreateOrUpdateOpenShiftRouteForService(service, builder.RouteOpenShift(policy))
+}
+
+func (p openShiftProvider) Delete(serviceName types.NamespacedName, deleteSecret bool) error {
+	return p.r.deleteServiceRouteObject(builder.OpenShiftRouteGroupVersion.WithKind(builder.OpenShiftRouteKind), serviceName, deleteSecret)
+}
+
+func (p openShiftProvider) Targets(service corev1.Service, policy *webappv1.CustomIngressManager) ([]string, error) {
+	existingRoute, err := p.r.getRouteObject(builder.OpenShiftRouteGroupVersion.WithKind(builder.OpenShiftRouteKind), types.NamespacedName{Name: p.r.config().CreateRouteName(service.Name), Namespace: service.Namespace})
+	if err != nil || existingRoute == nil {
+		return nil, err
+	}
+
+	ingresses, _, _ := unstructured.NestedSlice(existingRoute.Object, "status", "ingress")
+
+	return nestedStrings(ingresses, "routerCanonicalHostname"), nil
+}
+
+// LoadBalancerTargets returns the IPs or host names of the load balancer.
+func LoadBalancerTargets(status corev1.LoadBalancerStatus) []string {
+	var targets []string
+	for _, ingress := range status.Ingress {
+		if ingress.IP != "" {
+			targets = append(targets, ingress.IP)
+		} else if ingress.Hostname != "" {
+			targets = append(targets, ingress.Hostname)
+		}
+	}
+
+	return targets
+}
+
+// serviceLoadBalancerTargets returns the load balancer addresses of the Service, nil if it does
+// not exist. The Service is read from the API server, its namespace may not be watched.
+func (r *CustomIngressManagerReconciler) serviceLoadBalancerTargets(name types.NamespacedName) ([]string, error) {
+	var service corev1.Service
+	if err := r.apiReader().Get(context.Background(), name, &service); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+
+	return LoadBalancerTargets(service.Status.LoadBalancer), nil
+}
+
+// nestedStrings returns the non-empty string fields of the objects.
+func nestedStrings(objects []interface{}, field string) []string {
+	var values []string
+	for _, obj := range objects {
+		if obj, ok := obj.(map[string]interface{}); ok {
+			if value, _, _ := unstructured.NestedString(obj, field); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values
+}
+
+// RouteObjectType returns the object to watch for the routes of the group version and kind.
+func RouteObjectType(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+
+	return obj
+}
+
+// deleteServiceRouteObject deletes the managed route object of the service named after it, and
+// its TLS secret if deleteSecret is set and it is not retained by annotation.
+func (r *CustomIngressManagerReconciler) deleteServiceRouteObject(gvk schema.GroupVersionKind, serviceName types.NamespacedName, deleteSecret bool) error {
+	cfg := r.config()
+
+	existingRoute, err := r.getRouteObject(gvk, types.NamespacedName{Name: cfg.CreateRouteName(serviceName.Name), Namespace: serviceName.Namespace})
+	if err != nil {
+		return err
+	}
+
+	if existingRoute == nil || existingRoute.GetLabels()[config.ManagedByLabel] != config.ManagedByLabelValue {
+		return nil
+	}
+
+	if err := r.deleteManagedRouteObject(existingRoute); err != nil {
+		return err
+	}
+
+	if deleteSecret && existingRoute.GetAnnotations()[config.RetainSecretAnnotation] != "true" {
+		return r.DeleteUnusedSecret(cfg.CreateTLSSecretName(serviceName.Name), serviceName.Namespace)
+	}
+
+	return nil
+}
+
+func (r *CustomIngressManagerReconciler) getRouteObject(gvk schema.GroupVersionKind, name types.NamespacedName) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+
+	if err := r.Get(context.Background(), name, obj); err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (r *CustomIngressManagerReconciler) createOrUpdateRouteObject(desired, existing *unstructured.Unstructured) error {
+	ctx := context.Background()
+
+	if existing != nil {
+		updated := existing.DeepCopy()
+		updated.SetLabels(MergeLabels(existing.GetLabels(), desired.GetLabels()))
+		updated.SetOwnerReferences(desired.GetOwnerReferences())
+		updated.SetAnnotations(MergeManagedAnnotations(existing.GetAnnotations(), desired.GetAnnotations()))
+		updated.Object["spec"] = desired.Object["spec"]
+
+		if reflect.DeepEqual(existing, updated) {
+			return nil
+		}
+
+		r.Log.Info("updating " + desired.GetKind() + " " + desired.GetName())
+
+		return client.IgnoreNotFound(r.Update(ctx, updated))
+	}
+
+	desired.SetAnnotations(MergeManagedAnnotations(nil, desired.GetAnnotations()))
+
+	r.Log.Info("creating " + desired.GetKind() + " " + desired.GetName())
+
+	return client.IgnoreNotFound(r.Create(ctx, desired))
+}
+
+func (r *CustomIngressManagerReconciler) deleteManagedRouteObject(obj *unstructured.Unstructured) error {
+	if obj == nil || obj.GetLabels()[config.ManagedByLabel] != config.ManagedByLabelValue {
+		return nil
+	}
+
+	r.Log.Info("deleting existing " + obj.GetKind() + " " + obj.GetName())
+
+	return client.IgnoreNotFound(r.Delete(context.Background(), obj))
+}
@@ -0,0 +1,160 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+)
+
+func TestCustomIngressManagerReconciler_ExposeService_Providers(t *testing.T) {
+	InitTestScheme()
+
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testsvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", "feladat.banzaicloud.io/email": "tes@test.com"},
+			Labels:      map[string]string{"feladat.banzaicloud.io/ingress": "secure"},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+	routePolicy := func(route *webappv1.RoutePolicy) *webappv1.CustomIngressManager {
+		return &webappv1.CustomIngressManager{
+			ObjectMeta: metav1.ObjectMeta{Name: "route", Namespace: "default"},
+			Spec:       webappv1.CustomIngressManagerSpec{Route: route},
+		}
+	}
+	traefikPolicy := routePolicy(&webappv1.RoutePolicy{Traefik: &webappv1.TraefikRoutePolicy{EntryPoints: []string{"https"}}})
+	openShiftPolicy := routePolicy(&webappv1.RoutePolicy{OpenShift: &webappv1.OpenShiftRoutePolicy{}})
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme)
+	r := &CustomIngressManagerReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("customingressmanager"),
+		Scheme: testScheme,
+	}
+	ingressRoute := builder.TraefikGroupVersion(r.config()).WithKind(builder.IngressRouteKind)
+	openShiftRoute := builder.OpenShiftRouteGroupVersion.WithKind(builder.OpenShiftRouteKind)
+	getRoute := func(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+		route, err := r.getRouteObject(gvk, types.NamespacedName{Name: "testsvc-route", Namespace: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return route
+	}
+
+	// the traefik policy exposes the service with an IngressRoute
+	if err := r.ExposeService(service, traefikPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if ingress, _ := r.GetIngressByName("testsvc-ingress", "default"); ingress != nil {
+		t.Errorf("ingress was created for the traefik policy")
+	}
+	route := getRoute(ingressRoute)
+	if route == nil {
+		t.Fatalf("ingress route was not created")
+	}
+	if entryPoints, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "entryPoints"); len(entryPoints) != 1 || entryPoints[0] != "https" {
+		t.Errorf("ingress route entryPoints = %v, want [https]", entryPoints)
+	}
+	if secretName, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "secretName"); secretName != "testsvc-tls" {
+		t.Errorf("ingress route secretName = %v, want testsvc-tls", secretName)
+	}
+
+	// switching to openshift replaces it with a Route, without a certificate until it is issued
+	if err := r.ExposeService(service, openShiftPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if getRoute(ingressRoute) != nil {
+		t.Errorf("ingress route was not deleted")
+	}
+	route = getRoute(openShiftRoute)
+	if route == nil {
+		t.Fatalf("openshift route was not created")
+	}
+	if _, found, _ := unstructured.NestedString(route.Object, "spec", "tls", "certificate"); found {
+		t.Errorf("openshift route has a certificate before it is issued")
+	}
+	if targetPort, _, _ := unstructured.NestedString(route.Object, "spec", "port", "targetPort"); targetPort != "http" {
+		t.Errorf("openshift route targetPort = %v, want http", targetPort)
+	}
+
+	// the issued certificate is copied into the Route
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "testsvc-tls", Namespace: "default"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+	}
+	if err := c.Create(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ExposeService(service, openShiftPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	route = getRoute(openShiftRoute)
+	if certificate, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "certificate"); certificate != "cert" {
+		t.Errorf("openshift route certificate = %q, want cert", certificate)
+	}
+	if key, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "key"); key != "key" {
+		t.Errorf("openshift route key = %q, want key", key)
+	}
+	if insecure, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "insecureEdgeTerminationPolicy"); insecure != "Redirect" {
+		t.Errorf("openshift route insecureEdgeTerminationPolicy = %q, want Redirect", insecure)
+	}
+
+	// without a route policy the Ingress is back
+	if err := r.ExposeService(service, nil); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if getRoute(openShiftRoute) != nil {
+		t.Errorf("openshift route was not deleted")
+	}
+	if ingress, _ := r.GetIngressByName("testsvc-ingress", "default"); ingress == nil {
+		t.Errorf("ingress was not created")
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &corev1.Secret{}); err != nil {
+		t.Errorf("tls secret was deleted on the switch: %v", err)
+	}
+
+	// removing the service deletes the objects of every provider with the secret
+	if err := r.ExposeService(service, openShiftPolicy); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.ExposeService() error = %v", err)
+	}
+	if err := r.CleanupForService(types.NamespacedName{Name: "testsvc", Namespace: "default"}); err != nil {
+		t.Fatalf("CustomIngressManagerReconciler.CleanupForService() error = %v", err)
+	}
+	if getRoute(openShiftRoute) != nil {
+		t.Errorf("openshift route was not deleted")
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "testsvc-tls", Namespace: "default"}, &corev1.Secret{}); err == nil {
+		t.Errorf("tls secret was not deleted")
+	}
+}
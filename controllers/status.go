@@ -0,0 +1,339 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// Output formats of WriteServiceStatus.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// UnicodeDomainAnnotation records the Unicode form of the domain of a service whose domain
+// annotation is an internationalized domain name, next to the A-labels it is served as.
+const UnicodeDomainAnnotation = "feladat.banzaicloud.io/unicode-domain"
+
+// ServiceStatus is the certificate state of a managed service.
+type ServiceStatus struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Domain    string `json:"domain"`
+	// UnicodeDomain is the domain with Unicode labels if it is an internationalized domain name.
+	UnicodeDomain string `json:"unicodeDomain,omitempty"`
+	// Issuer is the cluster issuer, or the ACME server of the built-in ACME client.
+	Issuer       string       `json:"issuer"`
+	Subject      string       `json:"subject,omitempty"`
+	DNSNames     []string     `json:"dnsNames,omitempty"`
+	IssuerCA     string       `json:"issuerCA,omitempty"`
+	SerialNumber string       `json:"serialNumber,omitempty"`
+	NotAfter     *metav1.Time `json:"notAfter,omitempty"`
+	Ready        bool         `json:"ready"`
+	Message      string       `json:"message,omitempty"`
+	// PromotionPhase is the phase of the staging to production promotion, if any.
+	PromotionPhase string `json:"promotionPhase,omitempty"`
+	// Conditions recorded on the service, e.g. the CAA check holding its ACME order or the
+	// promotion phase.
+	Conditions []ServiceCondition `json:"conditions,omitempty"`
+}
+
+// ServiceCondition is a condition of a managed service. Services have no conditions of their
+// own, they are recorded in annotations.
+type ServiceCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+}
+
+// RecordUnicodeDomain records the Unicode form of an internationalized domain name in the
+// UnicodeDomainAnnotation of the service, with a Normal event when it changes. The annotation is
+// removed from services with an ASCII domain.
+func (r *CustomIngressManagerReconciler) RecordUnicodeDomain(service corev1.Service) error {
+	domain, _, err := r.config().Domain(service.ObjectMeta.Annotations)
+	if err != nil {
+		return err
+	}
+
+	existing, found := service.ObjectMeta.Annotations[UnicodeDomainAnnotation]
+	unicode := config.UnicodeDomain(domain)
+	if unicode == domain {
+		if !found {
+			return nil
+		}
+
+		return r.patchServiceAnnotation(service, UnicodeDomainAnnotation, nil)
+	}
+
+	if existing == unicode {
+		return nil
+	}
+
+	if err := r.patchServiceAnnotation(service, UnicodeDomainAnnotation, unicode); err != nil {
+		return err
+	}
+	if r.Recorder != nil {
+		r.Recorder.Event(&service, corev1.EventTypeNormal, "InternationalizedDomain", "serving "+config.DisplayDomain(domain))
+	}
+
+	return nil
+}
+
+// patchServiceAnnotation sets the annotation of the service with a merge patch, or removes it if
+// value is nil. The service is not updated, so a stale copy does not conflict.
+func (r *CustomIngressManagerReconciler) patchServiceAnnotation(service corev1.Service, key string, value interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{key: value}},
+	})
+	if err != nil {
+		return err
+	}
+
+	target := &corev1.Service{}
+	target.Name, target.Namespace = service.Name, service.Namespace
+
+	return client.IgnoreNotFound(r.Patch(context.Background(), target, client.RawPatch(types.MergePatchType, patch)))
+}
+
+// CollectServiceStatus returns the certificate state of the labelled services, ordered by
+// namespace and name. All namespaces are listed if namespaces is empty.
+func CollectServiceStatus(ctx context.Context, c client.Reader, cfg *config.Config, namespaces []string) ([]ServiceStatus, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{corev1.NamespaceAll}
+	}
+
+	var statuses []ServiceStatus
+	for _, namespace := range namespaces {
+		var services corev1.ServiceList
+		if err := c.List(ctx, &services, client.InNamespace(namespace), client.MatchingLabels{cfg.IngressLabel: cfg.IngressLabelValue}); err != nil {
+			return nil, err
+		}
+
+		var policies webappv1.CustomIngressManagerList
+		if err := c.List(ctx, &policies, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+
+		r := &CustomIngressManagerReconciler{Log: ctrl.Log.WithName("status"), Config: cfg}
+		for i := range services.Items {
+			policy := r.SelectPolicy(policies.Items, &services.Items[i])
+			service := cfg.PromotedService(services.Items[i])
+			status, err := serviceStatus(ctx, c, cfg, &service, policy)
+			if err != nil {
+				return nil, err
+			}
+
+			statuses = append(statuses, status)
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses, nil
+}
+
+func serviceStatus(ctx context.Context, c client.Reader, cfg *config.Config, service *corev1.Service, policy *webappv1.CustomIngressManager) (ServiceStatus, error) {
+	domain, _, _ := cfg.Domain(service.ObjectMeta.Annotations)
+	status := ServiceStatus{
+		Namespace:      service.Namespace,
+		Name:           service.Name,
+		Domain:         domain,
+		Issuer:         cfg.CreateClusterIssuerName(service.Name),
+		PromotionPhase: service.ObjectMeta.Annotations[config.PromotionPhaseAnnotation],
+	}
+
+	if unicode, ok := service.ObjectMeta.Annotations[UnicodeDomainAnnotation]; ok {
+		status.UnicodeDomain = unicode
+	} else if unicode := config.UnicodeDomain(domain); unicode != domain {
+		// not reconciled yet
+		status.UnicodeDomain = unicode
+	}
+
+	for _, key := range []string{CAAConditionAnnotation, config.PromotionConditionAnnotation} {
+		var condition ServiceCondition
+		if value, ok := service.ObjectMeta.Annotations[key]; ok && json.Unmarshal([]byte(value), &condition) == nil {
+			status.Conditions = append(status.Conditions, condition)
+		}
+	}
+
+	if cfg.CertificateBackend == config.CertificateBackendACME {
+		status.Issuer = cfg.ACMEServerURL(service.ObjectMeta.Labels[cfg.EnvironmentLabel])
+	}
+
+	secret := corev1.Secret{}
+	secretName := types.NamespacedName{Name: builder.TLSSecretName(cfg, *service, policy), Namespace: builder.CertificateNamespace(*service, policy)}
+	if err := c.Get(ctx, secretName, &secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return status, err
+		}
+
+		status.Message = "tls secret not found"
+
+		return status, nil
+	}
+
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		status.Message = "no certificate in the tls secret"
+
+		return status, nil
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		status.Message = "invalid certificate: " + err.Error()
+
+		return status, nil
+	}
+
+	notAfter := metav1.NewTime(cert.NotAfter)
+	status.Subject = cert.Subject.String()
+	status.DNSNames = cert.DNSNames
+	status.IssuerCA = cert.Issuer.String()
+	status.SerialNumber = cert.SerialNumber.Text(16)
+	status.NotAfter = &notAfter
+
+	switch {
+	case cert.VerifyHostname(domain) != nil:
+		status.Message = "certificate is not valid for " + domain
+	case time.Now().After(cert.NotAfter):
+		status.Message = "certificate expired"
+	default:
+		status.Ready = true
+	}
+
+	if !status.Ready || !builder.ManagesCertificate(cfg, policy) || cfg.CertificateBackend == config.CertificateBackendACME {
+		return status, nil
+	}
+
+	certificate := v1alpha3.Certificate{}
+	certificateName := types.NamespacedName{Name: builder.CertificateName(cfg, *service, policy), Namespace: builder.CertificateNamespace(*service, policy)}
+	if err := c.Get(ctx, certificateName, &certificate); err != nil {
+		if !errors.IsNotFound(err) {
+			return status, err
+		}
+
+		status.Ready = false
+		status.Message = "certificate not found"
+
+		return status, nil
+	}
+
+	for _, condition := range certificate.Status.Conditions {
+		if condition.Type == v1alpha3.CertificateConditionReady {
+			status.Ready = condition.Status == cmeta1.ConditionTrue
+			status.Message = condition.Message
+		}
+	}
+
+	return status, nil
+}
+
+// WriteServiceStatus writes the statuses as a table, JSON or YAML.
+func WriteServiceStatus(w io.Writer, statuses []ServiceStatus, format string) error {
+	switch format {
+	case OutputJSON:
+		if statuses == nil {
+			statuses = []ServiceStatus{}
+		}
+
+		out, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(out))
+
+		return err
+	case OutputYAML:
+		if statuses == nil {
+			statuses = []ServiceStatus{}
+		}
+
+		out, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(out)
+
+		return err
+	case OutputTable, "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAMESPACE\tNAME\tDOMAIN\tISSUER\tSUBJECT\tSANS\tISSUER CA\tNOT AFTER\tREADY\tPROMOTION")
+		for _, status := range statuses {
+			notAfter := "-"
+			if status.NotAfter != nil {
+				notAfter = status.NotAfter.UTC().Format(time.RFC3339)
+			}
+
+			domain := status.Domain
+			if status.UnicodeDomain != "" {
+				domain = status.UnicodeDomain
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+				status.Namespace, status.Name, domain, status.Issuer, orDash(status.Subject),
+				orDash(strings.Join(status.DNSNames, ",")), orDash(status.IssuerCA), notAfter, status.Ready, orDash(status.PromotionPhase))
+		}
+
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q: must be table, json or yaml", format)
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
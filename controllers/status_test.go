@@ -0,0 +1,217 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientFaker "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestCollectServiceStatus(t *testing.T) {
+	InitTestScheme()
+
+	now := time.Now()
+	labels := map[string]string{"feladat.banzaicloud.io/ingress": "secure"}
+	objects := []runtime.Object{
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "ready",
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: map[string]string{"feladat.banzaicloud.io/domain": "ready.com"},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ready-tls", Namespace: "default"},
+			Data:       map[string][]byte{corev1.TLSCertKey: testCertificatePEM(t, "ready.com", now, now.Add(24*time.Hour))},
+		},
+		&v1alpha3.Certificate{
+			ObjectMeta: metav1.ObjectMeta{Name: "ready-certificate", Namespace: "default"},
+			Status: v1alpha3.CertificateStatus{
+				Conditions: []v1alpha3.CertificateCondition{
+					{Type: v1alpha3.CertificateConditionReady, Status: cmeta1.ConditionTrue, Message: "Certificate is up to date and has not expired"},
+				},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pending",
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: map[string]string{"feladat.banzaicloud.io/domain": "pending.com"},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "shop",
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: map[string]string{"feladat.banzaicloud.io/domain": "Bücher.de"},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "default"},
+		},
+	}
+
+	c := clientFaker.NewFakeClientWithScheme(testScheme, objects...)
+	statuses, err := CollectServiceStatus(context.Background(), c, config.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatalf("CollectServiceStatus() error = %v", err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("CollectServiceStatus() returned %d statuses, want 3", len(statuses))
+	}
+
+	pending, ready, shop := statuses[0], statuses[1], statuses[2]
+	if pending.Name != "pending" || pending.Ready || pending.Message != "tls secret not found" {
+		t.Errorf("pending status = %+v, want not ready without secret", pending)
+	}
+	if ready.Name != "ready" || !ready.Ready || ready.Subject != "CN=ready.com" || ready.NotAfter == nil || ready.Issuer != "ready-lets-encrypt-staging" {
+		t.Errorf("ready status = %+v, want ready certificate for ready.com", ready)
+	}
+	if shop.Domain != "xn--bcher-kva.de" || shop.UnicodeDomain != "bücher.de" {
+		t.Errorf("shop status = %+v, want domain xn--bcher-kva.de displayed as bücher.de", shop)
+	}
+	if ready.UnicodeDomain != "" {
+		t.Errorf("ready status has unicode domain %q, want none", ready.UnicodeDomain)
+	}
+}
+
+func TestCustomIngressManagerReconciler_RecordUnicodeDomain(t *testing.T) {
+	InitTestScheme()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		wantEvent   bool
+	}{
+		{
+			name:        "Internationalized",
+			annotations: map[string]string{"feladat.banzaicloud.io/domain": "Bücher.de"},
+			want:        "bücher.de",
+			wantEvent:   true,
+		},
+		{
+			name:        "Recorded",
+			annotations: map[string]string{"feladat.banzaicloud.io/domain": "bücher.de", UnicodeDomainAnnotation: "bücher.de"},
+			want:        "bücher.de",
+		},
+		{
+			name:        "ASCII",
+			annotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com", UnicodeDomainAnnotation: "bücher.de"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", Annotations: tt.annotations},
+			}
+			c := clientFaker.NewFakeClientWithScheme(testScheme, service)
+			recorder := record.NewFakeRecorder(10)
+			r := &CustomIngressManagerReconciler{
+				Client:   c,
+				Log:      ctrl.Log.WithName("customingressmanager"),
+				Scheme:   testScheme,
+				Recorder: recorder,
+			}
+
+			if err := r.RecordUnicodeDomain(*service); err != nil {
+				t.Fatalf("CustomIngressManagerReconciler.RecordUnicodeDomain() error = %v", err)
+			}
+
+			stored := corev1.Service{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "shop", Namespace: "default"}, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if got := stored.Annotations[UnicodeDomainAnnotation]; got != tt.want {
+				t.Errorf("%s annotation = %q, want %q", UnicodeDomainAnnotation, got, tt.want)
+			}
+			if gotEvent := len(recorder.Events) > 0; gotEvent != tt.wantEvent {
+				t.Fatalf("event emitted = %v, want %v", gotEvent, tt.wantEvent)
+			}
+			if tt.wantEvent {
+				if event := <-recorder.Events; !strings.Contains(event, "bücher.de (xn--bcher-kva.de)") {
+					t.Errorf("event = %q, want the Unicode and A-label forms", event)
+				}
+			}
+		})
+	}
+
+	r := &CustomIngressManagerReconciler{Client: clientFaker.NewFakeClientWithScheme(testScheme), Log: ctrl.Log.WithName("customingressmanager")}
+	if err := r.RecordUnicodeDomain(corev1.Service{}); err == nil || err.Error() != "domain annotation missing" {
+		t.Errorf("CustomIngressManagerReconciler.RecordUnicodeDomain() error = %v, want domain annotation missing", err)
+	}
+}
+
+func TestWriteServiceStatus(t *testing.T) {
+	statuses := []ServiceStatus{
+		{Namespace: "default", Name: "testsvc", Domain: "testsvc.com", Issuer: "testsvc-lets-encrypt-staging", DNSNames: []string{"testsvc.com"}, Ready: true},
+		{Namespace: "default", Name: "shop", Domain: "xn--bcher-kva.de", UnicodeDomain: "bücher.de", Issuer: "shop-lets-encrypt-staging"},
+	}
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Table", format: OutputTable, want: "NAMESPACE"},
+		{name: "TableUnicodeDomain", format: OutputTable, want: " bücher.de "},
+		{name: "JSONUnicodeDomain", format: OutputJSON, want: `"unicodeDomain": "bücher.de"`},
+		{name: "JSON", format: OutputJSON, want: `"dnsNames": [`},
+		{name: "YAML", format: OutputYAML, want: "domain: testsvc.com"},
+		{name: "Unknown", format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := WriteServiceStatus(&out, statuses, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteServiceStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("WriteServiceStatus() = %s, want it to contain %s", out.String(), tt.want)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if err := WriteServiceStatus(&out, nil, OutputJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []ServiceStatus
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded == nil {
+		t.Errorf("WriteServiceStatus() = %s, want an empty JSON list", out.String())
+	}
+}
@@ -0,0 +1,55 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
+)
+
+// DiscoverTraefikAPIGroup returns the API group of the IngressRoutes served by the cluster,
+// traefik.io if both are served. It is empty if Traefik is not installed.
+func DiscoverTraefikAPIGroup(dc discovery.ServerGroupsInterface) (string, error) {
+	for _, group := range []string{config.TraefikAPIGroupIO, config.TraefikAPIGroupContainous} {
+		served, err := IsGroupVersionServed(dc, schema.GroupVersion{Group: group, Version: builder.TraefikVersion})
+		if err != nil {
+			return "", err
+		}
+		if served {
+			return group, nil
+		}
+	}
+
+	return "", nil
+}
+
+// CreateOrUpdateIngressRouteForService creates or updates the Traefik IngressRoute of the service.
+func (r *CustomIngressManagerReconciler) CreateOrUpdateIngressRouteForService(service corev1.Service, traefik *webappv1.TraefikRoutePolicy) error {
+	route := builder.IngressRoute(r.config(), service, traefik)
+	existingRoute, err := r.getRouteObject(route.GroupVersionKind(), types.NamespacedName{Name: route.GetName(), Namespace: route.GetNamespace()})
+	if err != nil {
+		return err
+	}
+
+	return r.createOrUpdateRouteObject(route, existingRoute)
+}
@@ -0,0 +1,53 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestDiscoverTraefikAPIGroup(t *testing.T) {
+	tests := []struct {
+		name          string
+		groupVersions []string
+		want          string
+	}{
+		{name: "Both", groupVersions: []string{"traefik.containo.us/v1alpha1", "traefik.io/v1alpha1"}, want: "traefik.io"},
+		{name: "Containous", groupVersions: []string{"traefik.containo.us/v1alpha1"}, want: "traefik.containo.us"},
+		{name: "NotInstalled", groupVersions: []string{"apps/v1"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &clienttesting.Fake{}
+			for _, groupVersion := range tt.groupVersions {
+				fake.Resources = append(fake.Resources, &metav1.APIResourceList{GroupVersion: groupVersion})
+			}
+
+			got, err := DiscoverTraefikAPIGroup(&fakediscovery.FakeDiscovery{Fake: fake})
+			if err != nil {
+				t.Fatalf("DiscoverTraefikAPIGroup() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DiscoverTraefikAPIGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
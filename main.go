@@ -17,17 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"io"
 	"os"
+	"strings"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	webappv1 "customingressmanager/api/v1"
 	"customingressmanager/controllers"
+	"customingressmanager/pkg/builder"
+	"customingressmanager/pkg/config"
 
 	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
 	// +kubebuilder:scaffold:imports
@@ -43,38 +54,156 @@ func init() {
 
 	_ = webappv1.AddToScheme(scheme)
 
+	// the reconciler works with v1alpha3 objects, CertManagerClient reads and writes them as
+	// unstructured v1 objects on clusters serving cert-manager v1
 	_ = v1alpha3.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		os.Exit(status(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		os.Exit(plan(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(export(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
+	var watchNamespaces string
+	var namespaceSelector string
+	var configFile string
+	var migrateAnnotations bool
+	var dryRun bool
+	cfg := config.DefaultConfig()
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of namespaces to watch. All namespaces are watched if empty.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector for the namespaces to manage, e.g. feladat.banzaicloud.io/enabled=true. "+
+			"All namespaces are managed if empty.")
+	flag.StringVar(&configFile, "config", "",
+		"Path of a YAML config file overriding the label/annotation keys, ACME servers and name suffixes. "+
+			"Flags given on the command line take precedence over the file.")
+	flag.BoolVar(&migrateAnnotations, "migrate-annotations", false,
+		"Rewrite the legacy domain and email annotations of the labelled services to the current keys, then exit.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Log the objects the manager would create, update or delete as YAML diffs instead of writing them.")
+	cfg.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if err := loadConfig(flag.CommandLine, cfg, configFile); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	var selector labels.Selector
+	if namespaceSelector != "" {
+		var err error
+		if selector, err = labels.Parse(namespaceSelector); err != nil {
+			setupLog.Error(err, "invalid namespace selector", "namespace-selector", namespaceSelector)
+			os.Exit(1)
+		}
+	}
+
+	namespaces := splitNamespaces(watchNamespaces)
+
+	if migrateAnnotations {
+		c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+
+		migrated, err := controllers.MigrateLegacyAnnotations(context.Background(), c, cfg, namespaces, setupLog)
+		if err != nil {
+			setupLog.Error(err, "unable to migrate annotations")
+			os.Exit(1)
+		}
+
+		setupLog.Info("annotations migrated", "services", migrated)
+		os.Exit(0)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	if err := resolveCertManagerAPIVersion(cfg, restConfig); err != nil {
+		setupLog.Error(err, "unable to detect the cert-manager API version")
+		os.Exit(1)
+	}
+
+	if err := resolveTraefikAPIGroup(cfg, restConfig); err != nil {
+		setupLog.Error(err, "unable to detect the Traefik API group")
+		os.Exit(1)
+	}
+
+	routeKinds, err := servedRouteKinds(cfg, restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to detect the route APIs")
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		Port:               9443,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "7141f1cc.feladat.banzaicloud.io",
-	})
+	}
+	if len(namespaces) == 1 {
+		options.Namespace = namespaces[0]
+	} else if len(namespaces) > 1 {
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.CustomIngressManagerReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("CustomIngressManager"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	var clusterScopedReader client.Reader
+	if len(namespaces) > 1 {
+		clusterScopedReader = mgr.GetAPIReader()
+	}
+
+	reconciler := &controllers.CustomIngressManagerReconciler{
+		Client:              controllers.NewCertManagerClient(mgr.GetClient(), mgr.GetScheme(), cfg.CertManagerAPIVersion),
+		Log:                 ctrl.Log.WithName("controllers").WithName("CustomIngressManager"),
+		Scheme:              mgr.GetScheme(),
+		WatchNamespaces:     namespaces,
+		NamespaceSelector:   selector,
+		ClusterScopedReader: clusterScopedReader,
+		Config:              cfg,
+		Recorder:            mgr.GetEventRecorderFor("customingressmanager"),
+		RouteKinds:          routeKinds,
+		APIReader:           mgr.GetAPIReader(),
+	}
+	if dryRun {
+		dryRunClient := controllers.NewDryRunClient(reconciler.Client, mgr.GetScheme())
+		dryRunLog := ctrl.Log.WithName("dry-run")
+		dryRunClient.OnChange = func(change controllers.PlannedChange) {
+			dryRunLog.Info("would "+change.Action+" "+change.Kind, "namespace", change.Namespace, "name", change.Name, "diff", change.Diff)
+		}
+		reconciler.Client = dryRunClient
+		reconciler.Recorder = nil
+	}
+
+	// the metrics start from the orders recorded by earlier runs
+	if err := reconciler.RecordRateLimitBudgets(context.Background()); err != nil {
+		setupLog.Error(err, "unable to read the rate limit ledger")
+	}
+
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CustomIngressManager")
 		os.Exit(1)
 	}
@@ -86,3 +215,291 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// status prints the certificate state of the managed services and returns the exit code.
+func status(args []string) int {
+	var namespaces string
+	var configFile string
+	var output string
+	cfg := config.DefaultConfig()
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.StringVar(&namespaces, "namespaces", "",
+		"Comma separated list of namespaces to list. All namespaces are listed if empty.")
+	fs.StringVar(&configFile, "config", "", "Path of the YAML config file of the manager.")
+	fs.StringVar(&output, "o", controllers.OutputTable, "Output format: table, json or yaml.")
+	cfg.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+
+	if err := loadConfig(fs, cfg, configFile); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		return 1
+	}
+
+	c, err := newCertManagerClient(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+
+	statuses, err := controllers.CollectServiceStatus(context.Background(), c, cfg, splitNamespaces(namespaces))
+	if err != nil {
+		setupLog.Error(err, "unable to collect service status")
+		return 1
+	}
+
+	if err := controllers.WriteServiceStatus(os.Stdout, statuses, output); err != nil {
+		setupLog.Error(err, "unable to write service status")
+		return 1
+	}
+
+	return 0
+}
+
+// plan prints the objects the manager would create, update or delete for the existing services
+// and returns the exit code.
+func plan(args []string) int {
+	var watchNamespaces string
+	var namespaceSelector string
+	var configFile string
+	cfg := config.DefaultConfig()
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	fs.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of namespaces to plan for. All namespaces are planned if empty.")
+	fs.StringVar(&namespaceSelector, "namespace-selector", "", "Label selector for the namespaces to manage.")
+	fs.StringVar(&configFile, "config", "", "Path of the YAML config file of the manager.")
+	cfg.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+
+	if err := loadConfig(fs, cfg, configFile); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		return 1
+	}
+
+	var selector labels.Selector
+	if namespaceSelector != "" {
+		var err error
+		if selector, err = labels.Parse(namespaceSelector); err != nil {
+			setupLog.Error(err, "invalid namespace selector", "namespace-selector", namespaceSelector)
+			return 1
+		}
+	}
+
+	c, err := newCertManagerClient(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+
+	namespaces := splitNamespaces(watchNamespaces)
+	changes, err := controllers.Plan(context.Background(), &controllers.CustomIngressManagerReconciler{
+		Client:            controllers.NewDryRunClient(c, scheme),
+		Log:               ctrl.Log.WithName("plan"),
+		Scheme:            scheme,
+		WatchNamespaces:   namespaces,
+		NamespaceSelector: selector,
+		Config:            cfg,
+	}, namespaces)
+	if err != nil {
+		setupLog.Error(err, "unable to plan")
+		return 1
+	}
+
+	if err := controllers.WritePlan(os.Stdout, changes); err != nil {
+		setupLog.Error(err, "unable to write plan")
+		return 1
+	}
+
+	return 0
+}
+
+// export renders the manifests for the Services in the YAML files given as arguments, - for
+// stdin, and returns the exit code.
+func export(args []string) int {
+	var outputDir string
+	var namespace string
+	var configFile string
+	cfg := config.DefaultConfig()
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&outputDir, "output-dir", "",
+		"Directory to write one manifest file per object to. The manifests are written to stdout if empty.")
+	fs.StringVar(&namespace, "namespace", "default", "Namespace of the input objects without one.")
+	fs.StringVar(&configFile, "config", "", "Path of the YAML config file of the manager.")
+	cfg.BindFlags(fs)
+	_ = fs.Parse(args)
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+
+	if err := loadConfig(fs, cfg, configFile); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		return 1
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	var inputs []io.Reader
+	for _, path := range paths {
+		if path == "-" {
+			inputs = append(inputs, os.Stdin)
+
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			setupLog.Error(err, "unable to open input", "path", path)
+			return 1
+		}
+		defer file.Close()
+
+		inputs = append(inputs, file)
+	}
+
+	manifests, err := controllers.ExportManifests(cfg, scheme, inputs, namespace, ctrl.Log.WithName("export"))
+	if err != nil {
+		setupLog.Error(err, "unable to export manifests")
+		return 1
+	}
+
+	if outputDir != "" {
+		err = controllers.WriteManifestsToDir(outputDir, manifests)
+	} else {
+		err = controllers.WriteManifests(os.Stdout, manifests)
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to write manifests")
+		return 1
+	}
+
+	return 0
+}
+
+// newCertManagerClient returns a client translating the cert-manager objects to the API version
+// served by the cluster.
+func newCertManagerClient(cfg *config.Config) (client.Client, error) {
+	restConfig := ctrl.GetConfigOrDie()
+	if err := resolveCertManagerAPIVersion(cfg, restConfig); err != nil {
+		return nil, err
+	}
+
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	return controllers.NewCertManagerClient(c, scheme, cfg.CertManagerAPIVersion), nil
+}
+
+// resolveCertManagerAPIVersion sets the cert-manager API version of the config to the newest
+// version served by the cluster unless it is configured, and validates the options depending on
+// it again. The built-in ACME client does not use cert-manager.
+func resolveCertManagerAPIVersion(cfg *config.Config, restConfig *rest.Config) error {
+	if cfg.CertManagerAPIVersion != "" || cfg.CertificateBackend == config.CertificateBackendACME {
+		return nil
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	version, err := controllers.DiscoverCertManagerAPIVersion(dc)
+	if err != nil {
+		return err
+	}
+
+	setupLog.Info("using cert-manager API " + version)
+	cfg.CertManagerAPIVersion = version
+
+	return cfg.Validate()
+}
+
+// resolveTraefikAPIGroup sets the Traefik API group of the config to the group served by the
+// cluster unless it is configured. It is left empty if Traefik is not installed.
+func resolveTraefikAPIGroup(cfg *config.Config, restConfig *rest.Config) error {
+	if cfg.TraefikAPIGroup != "" {
+		return nil
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	group, err := controllers.DiscoverTraefikAPIGroup(dc)
+	if err != nil {
+		return err
+	}
+
+	if group != "" {
+		setupLog.Info("using Traefik API group " + group)
+	}
+	cfg.TraefikAPIGroup = group
+
+	return nil
+}
+
+// servedRouteKinds returns the kinds of the route objects served by the cluster, only those are
+// watched.
+func servedRouteKinds(cfg *config.Config, restConfig *rest.Config) ([]schema.GroupVersionKind, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var kinds []schema.GroupVersionKind
+	for _, gvk := range []schema.GroupVersionKind{
+		builder.GatewayGroupVersion.WithKind(builder.HTTPRouteKind),
+		builder.IstioGroupVersion.WithKind(builder.VirtualServiceKind),
+		builder.TraefikGroupVersion(cfg).WithKind(builder.IngressRouteKind),
+		builder.OpenShiftRouteGroupVersion.WithKind(builder.OpenShiftRouteKind),
+	} {
+		served, err := controllers.IsGroupVersionServed(dc, gvk.GroupVersion())
+		if err != nil {
+			return nil, err
+		}
+		if served {
+			kinds = append(kinds, gvk)
+		}
+	}
+
+	return kinds, nil
+}
+
+// loadConfig loads the config file, if any, keeping the values of the flags set on the command
+// line, and validates the result.
+func loadConfig(fs *flag.FlagSet, cfg *config.Config, configFile string) error {
+	if configFile != "" {
+		explicitFlags := map[string]string{}
+		fs.Visit(func(f *flag.Flag) {
+			explicitFlags[f.Name] = f.Value.String()
+		})
+
+		if err := cfg.LoadFile(configFile); err != nil {
+			return err
+		}
+
+		for name, value := range explicitFlags {
+			_ = fs.Set(name, value)
+		}
+	}
+
+	return cfg.Validate()
+}
+
+func splitNamespaces(value string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	return namespaces
+}
@@ -0,0 +1,360 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package builder renders the objects the reconciler generates for a service. The builders
+// only read their arguments, so the same conversion runs in the reconciler, the plan and export
+// subcommands and tests without a client.
+package builder
+
+import (
+	"fmt"
+
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha3"
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	cmeta1 "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// ManagedLabels returns the labels marking the objects generated for the service.
+func ManagedLabels(service corev1.Service) map[string]string {
+	return map[string]string{
+		config.ManagedByLabel:        config.ManagedByLabelValue,
+		config.ServiceNameLabel:      service.Name,
+		config.ServiceNamespaceLabel: service.Namespace,
+	}
+}
+
+// Ingress returns the Ingress generated for the service.
+func Ingress(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) v1beta1.Ingress {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+	annotations := c.IngressAnnotations(service.ObjectMeta.Annotations, policy)
+	if !c.ManageCertificates && c.CertificateBackend != config.CertificateBackendACME {
+		// let ingress-shim create the certificate
+		annotations[config.ClusterIssuerAnnotation] = c.CreateClusterIssuerName(service.Name)
+	}
+	if service.ObjectMeta.Annotations[config.RetainSecretAnnotation] == "true" {
+		annotations[config.RetainSecretAnnotation] = "true"
+	}
+	for key, value := range ExternalDNSAnnotations(c, service) {
+		annotations[key] = value
+	}
+
+	ingress := v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            c.CreateIngressName(service.Name),
+			Namespace:       service.Namespace,
+			Labels:          ManagedLabels(service),
+			Annotations:     annotations,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))},
+		},
+		Spec: v1beta1.IngressSpec{
+			TLS: []v1beta1.IngressTLS{
+				{
+					Hosts:      []string{domain},
+					SecretName: c.ServiceTLSSecretName(service),
+				},
+			},
+			Rules: []v1beta1.IngressRule{
+				{
+					Host: domain,
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{
+								{
+									Path: "/",
+									Backend: v1beta1.IngressBackend{
+										ServiceName: service.Name,
+										ServicePort: intstr.FromInt(80),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return ingress
+}
+
+// ClusterIssuer returns the ClusterIssuer generated for the service.
+func ClusterIssuer(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) (v1alpha3.ClusterIssuer, error) {
+	issuerConfig, err := IssuerConfig(c, &service, policy)
+	if err != nil {
+		return v1alpha3.ClusterIssuer{}, err
+	}
+
+	return v1alpha3.ClusterIssuer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   c.CreateClusterIssuerName(service.Name),
+			Labels: ManagedLabels(service),
+		},
+		Spec: v1alpha3.IssuerSpec{
+			IssuerConfig: issuerConfig,
+		},
+	}, nil
+}
+
+// CertificateNamespace returns the namespace of the Certificate and TLS secret of the service:
+// the namespace of the Istio ingress gateway for services exposed through Istio, as the gateway
+// only reads secrets of its own namespace, the namespace of the service otherwise.
+func CertificateNamespace(service corev1.Service, policy *webappv1.CustomIngressManager) string {
+	if istio := RouteIstio(policy); istio != nil {
+		return IstioNamespace(istio)
+	}
+
+	return service.Namespace
+}
+
+// certificateBaseName returns the name the Certificate and TLS secret names are derived from,
+// qualified with the namespace of the service when they are created in another namespace.
+func certificateBaseName(service corev1.Service, policy *webappv1.CustomIngressManager) string {
+	if CertificateNamespace(service, policy) != service.Namespace {
+		return service.Namespace + "-" + service.Name
+	}
+
+	return service.Name
+}
+
+// CertificateName returns the name of the Certificate of the service.
+func CertificateName(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) string {
+	return c.CreateCertificateName(certificateBaseName(service, policy))
+}
+
+// TLSSecretName returns the name of the TLS secret of the service.
+func TLSSecretName(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) string {
+	if UsesIngress(policy) {
+		return c.ServiceTLSSecretName(service)
+	}
+
+	return c.CreateTLSSecretName(certificateBaseName(service, policy))
+}
+
+// UsesIngress reports whether the policy exposes its services with Ingresses.
+func UsesIngress(policy *webappv1.CustomIngressManager) bool {
+	return RouteGateway(policy) == nil && RouteIstio(policy) == nil && RouteTraefik(policy) == nil && RouteOpenShift(policy) == nil
+}
+
+// ManagesCertificate reports whether the Certificate of the service is created by us. Only
+// services exposed with an Ingress can leave it to ingress-shim.
+func ManagesCertificate(c *config.Config, policy *webappv1.CustomIngressManager) bool {
+	return c.ManageCertificates || !UsesIngress(policy)
+}
+
+// Certificate returns the Certificate generated for the service.
+func Certificate(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) v1alpha3.Certificate {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+	options, _ := c.CertificateOptions(service.ObjectMeta.Annotations)
+	certificate := v1alpha3.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      CertificateName(c, service, policy),
+			Namespace: CertificateNamespace(service, policy),
+			Labels:    ManagedLabels(service),
+		},
+		Spec: v1alpha3.CertificateSpec{
+			CommonName:   domain,
+			DNSNames:     []string{domain},
+			SecretName:   TLSSecretName(c, service, policy),
+			Duration:     options.Duration,
+			RenewBefore:  options.RenewBefore,
+			KeyAlgorithm: v1alpha3.KeyAlgorithm(options.KeyAlgorithm),
+			KeySize:      options.KeySize,
+			KeyEncoding:  v1alpha3.KeyEncoding(options.KeyEncoding),
+			IssuerRef: cmeta1.ObjectReference{
+				Name: c.CreateClusterIssuerName(service.Name),
+				Kind: v1alpha3.ClusterIssuerKind,
+			},
+		},
+	}
+
+	// v1alpha3 has no rotation policy, it is carried by an annotation the v1 translation moves
+	// to spec.privateKey.rotationPolicy
+	if options.RotationPolicy != "" {
+		certificate.Annotations = map[string]string{config.RotationPolicyAnnotation: options.RotationPolicy}
+	}
+
+	// owner references can not cross namespaces, the certificate is deleted with the service
+	// by the reconciler then
+	if certificate.Namespace == service.Namespace {
+		certificate.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))}
+	}
+
+	return certificate
+}
+
+// IssuerConfig returns the cert-manager issuer configuration for the service.
+func IssuerConfig(c *config.Config, service *corev1.Service, policy *webappv1.CustomIngressManager) (v1alpha3.IssuerConfig, error) {
+	switch c.IssuanceMode(service, policy) {
+	case config.IssuanceModeVault, config.IssuanceModeVenafi:
+		return PolicyIssuerConfig(c, policy)
+	case config.IssuanceModeCA:
+		return v1alpha3.IssuerConfig{
+			CA: &v1alpha3.CAIssuer{
+				SecretName: c.RootCASecretName,
+			},
+		}, nil
+	case config.IssuanceModeSelfSigned:
+		return v1alpha3.IssuerConfig{
+			SelfSigned: &v1alpha3.SelfSignedIssuer{},
+		}, nil
+	}
+
+	email, _ := c.Email(service.ObjectMeta.Annotations)
+
+	// Not setting the Class or Name field will cause cert-manager to create new ingress
+	// resources that do not specify a class to solve challenges, which means all Ingress
+	// controllers should act on the ingresses. The Istio ingress gateway only serves its class,
+	// Traefik serves its class whether it is restricted to it or not.
+	solverIngress := &cmacme.ACMEChallengeSolverHTTP01Ingress{}
+	if RouteIstio(policy) != nil {
+		class := IstioIngressClass
+		solverIngress.Class = &class
+	} else if RouteTraefik(policy) != nil {
+		class := TraefikIngressClass
+		solverIngress.Class = &class
+	}
+
+	return v1alpha3.IssuerConfig{
+		ACME: &cmacme.ACMEIssuer{
+			Server: c.ACMEServerURL(service.ObjectMeta.Labels[c.EnvironmentLabel]),
+			Email:  email,
+			PrivateKey: cmeta1.SecretKeySelector{
+				LocalObjectReference: cmeta1.LocalObjectReference{
+					Name: c.CreateSecretName(service.Namespace),
+				},
+			},
+			Solvers: []cmacme.ACMEChallengeSolver{
+				{
+					HTTP01: &cmacme.ACMEChallengeSolverHTTP01{
+						Ingress: solverIngress,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// PolicyIssuerConfig converts the issuer of a valid policy to the cert-manager issuer
+// configuration, with the credentials and CA bundle of the issuer server of the config.
+func PolicyIssuerConfig(c *config.Config, policy *webappv1.CustomIngressManager) (v1alpha3.IssuerConfig, error) {
+	if problems := config.ValidatePolicy(policy); len(problems) > 0 {
+		return v1alpha3.IssuerConfig{}, fmt.Errorf("invalid policy %s: %v", policy.Name, problems)
+	}
+
+	server := c.PolicyIssuerServer(policy)
+	if server == nil {
+		return v1alpha3.IssuerConfig{}, fmt.Errorf("policy %s: the issuer server is not configured", policy.Name)
+	}
+
+	if vault := policy.Spec.Issuer.Vault; vault != nil {
+		auth := v1alpha3.VaultAuth{}
+		switch {
+		case server.VaultAuth.TokenSecretRef != nil:
+			auth.TokenSecretRef = secretKeySelector(*server.VaultAuth.TokenSecretRef)
+		case server.VaultAuth.AppRole != nil:
+			auth.AppRole = &v1alpha3.VaultAppRole{
+				Path:      server.VaultAuth.AppRole.Path,
+				RoleId:    server.VaultAuth.AppRole.RoleID,
+				SecretRef: *secretKeySelector(server.VaultAuth.AppRole.SecretRef),
+			}
+		case server.VaultAuth.Kubernetes != nil:
+			auth.Kubernetes = &v1alpha3.VaultKubernetesAuth{
+				Path:      server.VaultAuth.Kubernetes.MountPath,
+				Role:      server.VaultAuth.Kubernetes.Role,
+				SecretRef: *secretKeySelector(server.VaultAuth.Kubernetes.SecretRef),
+			}
+		}
+
+		return v1alpha3.IssuerConfig{
+			Vault: &v1alpha3.VaultIssuer{
+				Server:   vault.Server,
+				Path:     vault.Path,
+				CABundle: server.CABundle,
+				Auth:     auth,
+			},
+		}, nil
+	}
+
+	venafi := policy.Spec.Issuer.Venafi
+	issuer := &v1alpha3.VenafiIssuer{
+		Zone: venafi.Zone,
+	}
+	if venafi.TPP != nil {
+		issuer.TPP = &v1alpha3.VenafiTPP{
+			URL:            venafi.TPP.URL,
+			CredentialsRef: cmeta1.LocalObjectReference{Name: server.VenafiTPPCredentialsSecretName},
+			CABundle:       server.CABundle,
+		}
+	} else {
+		issuer.Cloud = &v1alpha3.VenafiCloud{
+			URL:               venafi.Cloud.URL,
+			APITokenSecretRef: *secretKeySelector(*server.VenafiCloudAPITokenSecretRef),
+		}
+	}
+
+	return v1alpha3.IssuerConfig{Venafi: issuer}, nil
+}
+
+func secretKeySelector(ref webappv1.SecretKeyReference) *cmeta1.SecretKeySelector {
+	return &cmeta1.SecretKeySelector{
+		LocalObjectReference: cmeta1.LocalObjectReference{Name: ref.Name},
+		Key:                  ref.Key,
+	}
+}
+
+// RootCA returns the self-signed ClusterIssuer and the CA Certificate of the CA issuance mode.
+func RootCA(c *config.Config) (v1alpha3.ClusterIssuer, v1alpha3.Certificate) {
+	labels := map[string]string{config.ManagedByLabel: config.ManagedByLabelValue}
+
+	selfSignedIssuer := v1alpha3.ClusterIssuer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   c.RootCAIssuerName,
+			Labels: labels,
+		},
+		Spec: v1alpha3.IssuerSpec{
+			IssuerConfig: v1alpha3.IssuerConfig{
+				SelfSigned: &v1alpha3.SelfSignedIssuer{},
+			},
+		},
+	}
+
+	rootCA := v1alpha3.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.RootCASecretName,
+			Namespace: c.ClusterResourceNamespace,
+			Labels:    labels,
+		},
+		Spec: v1alpha3.CertificateSpec{
+			CommonName: c.RootCACommonName,
+			IsCA:       true,
+			SecretName: c.RootCASecretName,
+			Duration:   &metav1.Duration{Duration: config.RootCADuration},
+			IssuerRef: cmeta1.ObjectReference{
+				Name: c.RootCAIssuerName,
+				Kind: v1alpha3.ClusterIssuerKind,
+			},
+		},
+	}
+
+	return selfSignedIssuer, rootCA
+}
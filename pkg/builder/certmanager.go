@@ -0,0 +1,260 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"customingressmanager/pkg/config"
+)
+
+// The builders produce v1alpha3 objects. The functions below translate them to and from the
+// cert-manager version served by the cluster, so the rest of the operator only deals with
+// v1alpha3.
+
+// certManagerV1RenamedFields maps the v1alpha3 spec fields of a kind to their v1 path.
+var certManagerV1RenamedFields = map[string]map[string][]string{
+	v1alpha3.CertificateKind: {
+		"keyAlgorithm": {"privateKey", "algorithm"},
+		"keySize":      {"privateKey", "size"},
+		"keyEncoding":  {"privateKey", "encoding"},
+		"uriSANs":      {"uris"},
+		"emailSANs":    {"emailAddresses"},
+	},
+	v1alpha3.CertificateRequestKind: {
+		"csr": {"request"},
+	},
+}
+
+// certManagerV1UpperCaseFields are the enum fields spelled in upper case by v1, e.g. RSA and PKCS1.
+var certManagerV1UpperCaseFields = map[string]bool{
+	"keyAlgorithm": true,
+	"keyEncoding":  true,
+}
+
+// certManagerV1EnumValues are the enum values v1 does not spell in upper case.
+var certManagerV1EnumValues = map[string]string{
+	config.Ed25519KeyAlgorithm: "Ed25519",
+}
+
+// CertManagerGroupVersion returns the group version of the cert-manager API version.
+func CertManagerGroupVersion(version string) schema.GroupVersion {
+	if version == "" {
+		version = config.CertManagerAPIVersionV1Alpha3
+	}
+
+	return schema.GroupVersion{Group: v1alpha3.SchemeGroupVersion.Group, Version: version}
+}
+
+// CertManagerKind returns the kind of a v1alpha3 cert-manager object or list. ok is false for
+// objects of other groups.
+func CertManagerKind(obj runtime.Object) (kind string, ok bool) {
+	switch obj.(type) {
+	case *v1alpha3.Certificate:
+		return v1alpha3.CertificateKind, true
+	case *v1alpha3.CertificateList:
+		return v1alpha3.CertificateKind + "List", true
+	case *v1alpha3.CertificateRequest:
+		return v1alpha3.CertificateRequestKind, true
+	case *v1alpha3.CertificateRequestList:
+		return v1alpha3.CertificateRequestKind + "List", true
+	case *v1alpha3.Issuer:
+		return v1alpha3.IssuerKind, true
+	case *v1alpha3.IssuerList:
+		return v1alpha3.IssuerKind + "List", true
+	case *v1alpha3.ClusterIssuer:
+		return v1alpha3.ClusterIssuerKind, true
+	case *v1alpha3.ClusterIssuerList:
+		return v1alpha3.ClusterIssuerKind + "List", true
+	}
+
+	return "", false
+}
+
+// ToCertManagerVersion converts a v1alpha3 cert-manager object to an unstructured object of the
+// given API version.
+func ToCertManagerVersion(obj runtime.Object, version string) (*unstructured.Unstructured, error) {
+	kind, ok := CertManagerKind(obj)
+	if !ok || strings.HasSuffix(kind, "List") {
+		return nil, fmt.Errorf("%T is not a cert-manager object", obj)
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{Object: content}
+	if version == config.CertManagerAPIVersionV1 {
+		if spec, ok := content["spec"].(map[string]interface{}); ok {
+			if rotationPolicy, ok := u.GetAnnotations()[config.RotationPolicyAnnotation]; ok && kind == v1alpha3.CertificateKind {
+				annotations := u.GetAnnotations()
+				delete(annotations, config.RotationPolicyAnnotation)
+				if len(annotations) == 0 {
+					annotations = nil
+				}
+				u.SetAnnotations(annotations)
+				if err := unstructured.SetNestedField(spec, rotationPolicy, "privateKey", "rotationPolicy"); err != nil {
+					return nil, err
+				}
+			}
+			for from, to := range certManagerV1RenamedFields[kind] {
+				value, ok := spec[from]
+				if !ok {
+					continue
+				}
+				delete(spec, from)
+
+				if s, ok := value.(string); ok && certManagerV1UpperCaseFields[from] {
+					if v1, ok := certManagerV1EnumValues[s]; ok {
+						value = v1
+					} else {
+						value = strings.ToUpper(s)
+					}
+				}
+				if err := unstructured.SetNestedField(spec, value, to...); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	u.SetGroupVersionKind(CertManagerGroupVersion(version).WithKind(kind))
+
+	return u, nil
+}
+
+// FromCertManagerVersion converts an unstructured cert-manager object of any supported API
+// version into the v1alpha3 object obj.
+func FromCertManagerVersion(u *unstructured.Unstructured, obj runtime.Object) error {
+	kind, ok := CertManagerKind(obj)
+	if !ok || strings.HasSuffix(kind, "List") {
+		return fmt.Errorf("%T is not a cert-manager object", obj)
+	}
+
+	content := runtime.DeepCopyJSON(u.Object)
+	if u.GroupVersionKind().Version == config.CertManagerAPIVersionV1 {
+		if spec, ok := content["spec"].(map[string]interface{}); ok {
+			if rotationPolicy, ok, _ := unstructured.NestedString(spec, "privateKey", "rotationPolicy"); ok && kind == v1alpha3.CertificateKind {
+				unstructured.RemoveNestedField(spec, "privateKey", "rotationPolicy")
+				if err := unstructured.SetNestedField(content, rotationPolicy, "metadata", "annotations", config.RotationPolicyAnnotation); err != nil {
+					return err
+				}
+			}
+			for to, from := range certManagerV1RenamedFields[kind] {
+				value, ok, err := unstructured.NestedFieldNoCopy(spec, from...)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				unstructured.RemoveNestedField(spec, from...)
+
+				if s, ok := value.(string); ok && certManagerV1UpperCaseFields[to] {
+					value = strings.ToLower(s)
+				}
+				spec[to] = value
+			}
+			if privateKey, ok := spec["privateKey"].(map[string]interface{}); ok && len(privateKey) == 0 {
+				delete(spec, "privateKey")
+			}
+		}
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, obj); err != nil {
+		return err
+	}
+	// typed objects read from the API server carry no type meta either
+	obj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+
+	return nil
+}
+
+// MergeCertManagerVersion converts the v1alpha3 cert-manager object obj to the API version of
+// existing, keeping the fields of existing that have no v1alpha3 counterpart, e.g. v1 only spec
+// fields and status set by cert-manager. Writing the plain translation would drop them.
+func MergeCertManagerVersion(obj runtime.Object, existing *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	u, err := ToCertManagerVersion(obj, existing.GroupVersionKind().Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// the fields of existing that survive a round trip through v1alpha3 are the mapped ones
+	known := obj.DeepCopyObject()
+	if err := FromCertManagerVersion(existing, known); err != nil {
+		return nil, err
+	}
+	mapped, err := ToCertManagerVersion(known, existing.GroupVersionKind().Version)
+	if err != nil {
+		return nil, err
+	}
+
+	mergeUnmappedFields(u.Object, runtime.DeepCopyJSON(existing.Object), mapped.Object)
+
+	return u, nil
+}
+
+// mergeUnmappedFields copies the fields of existing missing from mapped into dst. Objects are
+// merged recursively, lists item by item as long as they keep their length.
+func mergeUnmappedFields(dst, existing, mapped map[string]interface{}) {
+	for key, value := range existing {
+		if _, set := dst[key]; !set {
+			if _, ok := mapped[key]; !ok {
+				dst[key] = value
+			}
+			continue
+		}
+		mergeUnmappedValue(dst[key], value, mapped[key])
+	}
+}
+
+func mergeUnmappedValue(dst, existing, mapped interface{}) {
+	switch existing := existing.(type) {
+	case map[string]interface{}:
+		// a missing mapped object maps none of the fields
+		dst, ok := dst.(map[string]interface{})
+		mapped, _ := mapped.(map[string]interface{})
+		if ok {
+			mergeUnmappedFields(dst, existing, mapped)
+		}
+	case []interface{}:
+		dst, ok := dst.([]interface{})
+		mapped, mappedOK := mapped.([]interface{})
+		if !ok || !mappedOK || len(dst) != len(existing) || len(mapped) != len(existing) {
+			return
+		}
+		for i := range existing {
+			mergeUnmappedValue(dst[i], existing[i], mapped[i])
+		}
+	}
+}
+
+// CertManagerObject returns obj in the given cert-manager API version. Objects of other groups
+// and v1alpha3 objects are returned unchanged.
+func CertManagerObject(obj runtime.Object, version string) (runtime.Object, error) {
+	if _, ok := CertManagerKind(obj); !ok || version == "" || version == config.CertManagerAPIVersionV1Alpha3 {
+		return obj, nil
+	}
+
+	return ToCertManagerVersion(obj, version)
+}
@@ -0,0 +1,191 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"customingressmanager/pkg/config"
+)
+
+func TestToCertManagerVersion(t *testing.T) {
+	c := config.DefaultConfig()
+	certificate := Certificate(c, testService(map[string]string{
+		config.DomainAnnotation:         "example.com",
+		config.KeyAlgorithmAnnotation:   "ecdsa",
+		config.KeySizeAnnotation:        "384",
+		config.RotationPolicyAnnotation: config.RotationPolicyAlways,
+	}), nil)
+	certificate.Spec.KeyEncoding = v1alpha3.PKCS8
+	certificate.Spec.EmailSANs = []string{"admin@example.com"}
+
+	tests := []struct {
+		name    string
+		version string
+		fields  map[string]interface{}
+		absent  []string
+	}{
+		{
+			name:    "V1Alpha3",
+			version: config.CertManagerAPIVersionV1Alpha3,
+			fields: map[string]interface{}{
+				"apiVersion":        "cert-manager.io/v1alpha3",
+				"spec.keyAlgorithm": "ecdsa",
+				"spec.keySize":      int64(384),
+				"spec.keyEncoding":  "pkcs8",
+			},
+		},
+		{
+			name:    "V1",
+			version: config.CertManagerAPIVersionV1,
+			fields: map[string]interface{}{
+				"apiVersion":                     "cert-manager.io/v1",
+				"spec.privateKey.algorithm":      "ECDSA",
+				"spec.privateKey.size":           int64(384),
+				"spec.privateKey.encoding":       "PKCS8",
+				"spec.privateKey.rotationPolicy": "Always",
+				"spec.emailAddresses":            []interface{}{"admin@example.com"},
+				"spec.issuerRef.kind":            "ClusterIssuer",
+				"spec.secretName":                "testsvc-tls",
+			},
+			absent: []string{"spec.keyAlgorithm", "spec.keySize", "spec.keyEncoding", "spec.emailSANs", "metadata.annotations"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := ToCertManagerVersion(certificate.DeepCopy(), tt.version)
+			if err != nil {
+				t.Fatalf("ToCertManagerVersion() error = %v", err)
+			}
+			for path, want := range tt.fields {
+				got, _, _ := unstructured.NestedFieldNoCopy(u.Object, strings.Split(path, ".")...)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("ToCertManagerVersion() %s = %v, want %v", path, got, want)
+				}
+			}
+			for _, path := range tt.absent {
+				if _, found, _ := unstructured.NestedFieldNoCopy(u.Object, strings.Split(path, ".")...); found {
+					t.Errorf("ToCertManagerVersion() %s is set", path)
+				}
+			}
+
+			var got v1alpha3.Certificate
+			if err := FromCertManagerVersion(u, &got); err != nil {
+				t.Fatalf("FromCertManagerVersion() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Spec, certificate.Spec) {
+				t.Errorf("FromCertManagerVersion() = %+v, want %+v", got.Spec, certificate.Spec)
+			}
+			if !reflect.DeepEqual(got.Annotations, certificate.Annotations) {
+				t.Errorf("FromCertManagerVersion() annotations = %v, want %v", got.Annotations, certificate.Annotations)
+			}
+		})
+	}
+}
+
+func TestToCertManagerVersion_Ed25519(t *testing.T) {
+	certificate := Certificate(config.DefaultConfig(), testService(map[string]string{
+		config.DomainAnnotation:       "example.com",
+		config.KeyAlgorithmAnnotation: "ed25519",
+	}), nil)
+
+	u, err := ToCertManagerVersion(certificate.DeepCopy(), config.CertManagerAPIVersionV1)
+	if err != nil {
+		t.Fatalf("ToCertManagerVersion() error = %v", err)
+	}
+	if got, _, _ := unstructured.NestedString(u.Object, "spec", "privateKey", "algorithm"); got != "Ed25519" {
+		t.Errorf("ToCertManagerVersion() spec.privateKey.algorithm = %q, want Ed25519", got)
+	}
+
+	var got v1alpha3.Certificate
+	if err := FromCertManagerVersion(u, &got); err != nil {
+		t.Fatalf("FromCertManagerVersion() error = %v", err)
+	}
+	if got.Spec.KeyAlgorithm != config.Ed25519KeyAlgorithm {
+		t.Errorf("FromCertManagerVersion() keyAlgorithm = %q, want %q", got.Spec.KeyAlgorithm, config.Ed25519KeyAlgorithm)
+	}
+}
+
+func TestCertManagerObject(t *testing.T) {
+	ingress := Ingress(config.DefaultConfig(), testService(nil), nil)
+	if got, _ := CertManagerObject(&ingress, config.CertManagerAPIVersionV1); got != &ingress {
+		t.Errorf("CertManagerObject() = %T, want the ingress unchanged", got)
+	}
+
+	certificate := Certificate(config.DefaultConfig(), testService(nil), nil)
+	if got, _ := CertManagerObject(&certificate, ""); got != &certificate {
+		t.Errorf("CertManagerObject() = %T, want the v1alpha3 certificate unchanged", got)
+	}
+	if got, _ := CertManagerObject(&certificate, config.CertManagerAPIVersionV1); got.GetObjectKind().GroupVersionKind().Version != config.CertManagerAPIVersionV1 {
+		t.Errorf("CertManagerObject() = %v, want a v1 certificate", got.GetObjectKind().GroupVersionKind())
+	}
+}
+
+func TestMergeCertManagerVersion(t *testing.T) {
+	certificate := Certificate(config.DefaultConfig(), testService(map[string]string{config.DomainAnnotation: "example.com"}), nil)
+	stored, err := ToCertManagerVersion(certificate.DeepCopy(), config.CertManagerAPIVersionV1)
+	if err != nil {
+		t.Fatalf("ToCertManagerVersion() error = %v", err)
+	}
+	// fields without a v1alpha3 counterpart, set by users or by cert-manager
+	extra := []struct {
+		path  []string
+		value interface{}
+	}{
+		{path: []string{"spec", "revisionHistoryLimit"}, value: int64(3)},
+		{path: []string{"spec", "secretTemplate", "labels", "team"}, value: "web"},
+		{path: []string{"spec", "additionalOutputFormats"}, value: []interface{}{map[string]interface{}{"type": "CombinedPEM"}}},
+		{path: []string{"status", "failedIssuanceAttempts"}, value: int64(2)},
+	}
+	for _, field := range extra {
+		if err := unstructured.SetNestedField(stored.Object, field.value, field.path...); err != nil {
+			t.Fatalf("SetNestedField(%v) error = %v", field.path, err)
+		}
+	}
+
+	var existing v1alpha3.Certificate
+	if err := FromCertManagerVersion(stored, &existing); err != nil {
+		t.Fatalf("FromCertManagerVersion() error = %v", err)
+	}
+	existing.Spec.DNSNames = []string{"www.example.com"}
+	existing.Spec.KeySize = 4096
+
+	u, err := MergeCertManagerVersion(&existing, stored)
+	if err != nil {
+		t.Fatalf("MergeCertManagerVersion() error = %v", err)
+	}
+	for _, field := range extra {
+		got, _, _ := unstructured.NestedFieldNoCopy(u.Object, field.path...)
+		if !reflect.DeepEqual(got, field.value) {
+			t.Errorf("MergeCertManagerVersion() %v = %v, want %v", field.path, got, field.value)
+		}
+	}
+	if got, _, _ := unstructured.NestedStringSlice(u.Object, "spec", "dnsNames"); !reflect.DeepEqual(got, []string{"www.example.com"}) {
+		t.Errorf("MergeCertManagerVersion() spec.dnsNames = %v, want the updated names", got)
+	}
+	if got, _, _ := unstructured.NestedInt64(u.Object, "spec", "privateKey", "size"); got != 4096 {
+		t.Errorf("MergeCertManagerVersion() spec.privateKey.size = %d, want 4096", got)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(u.Object, "spec", "keySize"); found {
+		t.Errorf("MergeCertManagerVersion() spec.keySize is set")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"customingressmanager/pkg/config"
+)
+
+// ExternalDNSGroupVersion is the API version of the external-dns DNSEndpoints.
+var ExternalDNSGroupVersion = schema.GroupVersion{Group: "externaldns.k8s.io", Version: "v1alpha1"}
+
+// DNSEndpointKind is the kind of the external-dns DNSEndpoints.
+const DNSEndpointKind = "DNSEndpoint"
+
+// ExternalDNSAnnotations returns the external-dns annotations of the object exposing the
+// service, nil unless the annotations mode is enabled.
+func ExternalDNSAnnotations(c *config.Config, service corev1.Service) map[string]string {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+	options, _ := c.DNSOptions(service.ObjectMeta.Annotations)
+
+	return c.ExternalDNSObjectAnnotations(domain, options)
+}
+
+// routeAnnotations returns the annotations of the unstructured object exposing the service.
+func routeAnnotations(c *config.Config, service corev1.Service) map[string]string {
+	annotations := ExternalDNSAnnotations(c, service)
+	if service.ObjectMeta.Annotations[config.RetainSecretAnnotation] == "true" {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[config.RetainSecretAnnotation] = "true"
+	}
+
+	return annotations
+}
+
+// DNSEndpoint returns the DNSEndpoint pointing the domain of the service at the targets, with
+// an A, AAAA or CNAME record depending on the kind of the targets.
+func DNSEndpoint(c *config.Config, service corev1.Service, targets []string, ttl int64) *unstructured.Unstructured {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	recordTargets := map[string][]interface{}{}
+	for _, target := range targets {
+		recordType := "CNAME"
+		if ip := net.ParseIP(target); ip != nil && ip.To4() != nil {
+			recordType = "A"
+		} else if ip != nil {
+			recordType = "AAAA"
+		}
+		recordTargets[recordType] = append(recordTargets[recordType], target)
+	}
+
+	var endpoints []interface{}
+	for _, recordType := range []string{"A", "AAAA", "CNAME"} {
+		if len(recordTargets[recordType]) == 0 {
+			continue
+		}
+
+		endpoint := map[string]interface{}{
+			"dnsName":    domain,
+			"recordType": recordType,
+			"targets":    recordTargets[recordType],
+		}
+		if ttl > 0 {
+			endpoint["recordTTL"] = ttl
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	endpoint := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"endpoints": endpoints},
+	}}
+	endpoint.SetGroupVersionKind(ExternalDNSGroupVersion.WithKind(DNSEndpointKind))
+	endpoint.SetName(c.CreateDNSEndpointName(service.Name))
+	endpoint.SetNamespace(service.Namespace)
+	endpoint.SetLabels(ManagedLabels(service))
+	endpoint.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))})
+
+	return endpoint
+}
@@ -0,0 +1,181 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// The Gateway API objects are built unstructured, the Gateway API module needs a newer
+// Kubernetes client than the operator is built with.
+
+var (
+	// GatewayGroupVersion is the Gateway API version of the Gateways and HTTPRoutes.
+	GatewayGroupVersion = schema.GroupVersion{Group: "gateway.networking.k8s.io", Version: "v1"}
+	// ReferenceGrantGroupVersion is the Gateway API version of the ReferenceGrants.
+	ReferenceGrantGroupVersion = schema.GroupVersion{Group: "gateway.networking.k8s.io", Version: "v1beta1"}
+)
+
+// Kinds of the Gateway API objects.
+const (
+	GatewayKind        = "Gateway"
+	HTTPRouteKind      = "HTTPRoute"
+	ReferenceGrantKind = "ReferenceGrant"
+)
+
+// RouteGateway returns the Gateway the policy attaches the services to, nil if the services are
+// exposed with an Ingress.
+func RouteGateway(policy *webappv1.CustomIngressManager) *webappv1.GatewayRoutePolicy {
+	if policy == nil || policy.Spec.Route == nil {
+		return nil
+	}
+
+	return policy.Spec.Route.Gateway
+}
+
+// GatewayNamespace returns the namespace of the Gateway of the service.
+func GatewayNamespace(service corev1.Service, gateway *webappv1.GatewayRoutePolicy) string {
+	if gateway.Namespace != "" {
+		return gateway.Namespace
+	}
+
+	return service.Namespace
+}
+
+// ListenerName returns the name of the Gateway listener managed for the service.
+func ListenerName(namespace, name string) string {
+	return namespace + "-" + name
+}
+
+// HTTPRoute returns the HTTPRoute generated for the service, attached to the gateway.
+func HTTPRoute(c *config.Config, service corev1.Service, gateway *webappv1.GatewayRoutePolicy) *unstructured.Unstructured {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	parentRef := map[string]interface{}{
+		"group":     GatewayGroupVersion.Group,
+		"kind":      GatewayKind,
+		"name":      gateway.Name,
+		"namespace": GatewayNamespace(service, gateway),
+	}
+	if gateway.ManageListener {
+		parentRef["sectionName"] = ListenerName(service.Namespace, service.Name)
+	} else if gateway.SectionName != "" {
+		parentRef["sectionName"] = gateway.SectionName
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{parentRef},
+			"hostnames":  []interface{}{domain},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"matches": []interface{}{
+						map[string]interface{}{
+							"path": map[string]interface{}{"type": "PathPrefix", "value": "/"},
+						},
+					},
+					"backendRefs": []interface{}{
+						map[string]interface{}{"name": service.Name, "port": int64(80)},
+					},
+				},
+			},
+		},
+	}}
+	route.SetGroupVersionKind(GatewayGroupVersion.WithKind(HTTPRouteKind))
+	route.SetName(c.CreateRouteName(service.Name))
+	route.SetNamespace(service.Namespace)
+	route.SetLabels(ManagedLabels(service))
+	route.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))})
+	if annotations := routeAnnotations(c, service); len(annotations) > 0 {
+		route.SetAnnotations(annotations)
+	}
+
+	return route
+}
+
+// GatewayListener returns the HTTPS listener added to the Gateway for the service, terminating
+// TLS with the certificate secret of the service.
+func GatewayListener(c *config.Config, service corev1.Service) map[string]interface{} {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	return map[string]interface{}{
+		"name":     ListenerName(service.Namespace, service.Name),
+		"hostname": domain,
+		"port":     int64(443),
+		"protocol": "HTTPS",
+		"tls": map[string]interface{}{
+			"mode": "Terminate",
+			"certificateRefs": []interface{}{
+				map[string]interface{}{
+					"group":     "",
+					"kind":      "Secret",
+					"name":      c.CreateTLSSecretName(service.Name),
+					"namespace": service.Namespace,
+				},
+			},
+		},
+		"allowedRoutes": map[string]interface{}{
+			"namespaces": map[string]interface{}{
+				"from": "Selector",
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": service.Namespace},
+				},
+			},
+		},
+	}
+}
+
+// ReferenceGrant returns the ReferenceGrant allowing the Gateway to read the certificate secret
+// of the service, or nil if the Gateway is in the namespace of the service.
+func ReferenceGrant(c *config.Config, service corev1.Service, gateway *webappv1.GatewayRoutePolicy) *unstructured.Unstructured {
+	gatewayNamespace := GatewayNamespace(service, gateway)
+	if gatewayNamespace == service.Namespace {
+		return nil
+	}
+
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"from": []interface{}{
+				map[string]interface{}{
+					"group":     GatewayGroupVersion.Group,
+					"kind":      GatewayKind,
+					"namespace": gatewayNamespace,
+				},
+			},
+			"to": []interface{}{
+				map[string]interface{}{
+					"group": "",
+					"kind":  "Secret",
+					"name":  c.CreateTLSSecretName(service.Name),
+				},
+			},
+		},
+	}}
+	grant.SetGroupVersionKind(ReferenceGrantGroupVersion.WithKind(ReferenceGrantKind))
+	grant.SetName(c.CreateTLSSecretName(service.Name))
+	grant.SetNamespace(service.Namespace)
+	grant.SetLabels(ManagedLabels(service))
+	grant.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))})
+
+	return grant
+}
@@ -0,0 +1,143 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// The Istio objects are built unstructured like the Gateway API ones.
+
+// IstioGroupVersion is the Istio networking API version of the Gateways and VirtualServices.
+var IstioGroupVersion = schema.GroupVersion{Group: "networking.istio.io", Version: "v1beta1"}
+
+// Kinds of the Istio objects.
+const (
+	IstioGatewayKind   = "Gateway"
+	VirtualServiceKind = "VirtualService"
+)
+
+const (
+	// DefaultIstioNamespace is the namespace of the Istio ingress gateway if the policy sets none.
+	DefaultIstioNamespace = "istio-system"
+	// IstioIngressClass is the ingress class served by the Istio ingress gateway, it is used by
+	// the cert-manager HTTP-01 solver of Istio services.
+	IstioIngressClass = "istio"
+)
+
+// RouteIstio returns the Istio settings of the policy, nil if the services are not exposed
+// through Istio.
+func RouteIstio(policy *webappv1.CustomIngressManager) *webappv1.IstioRoutePolicy {
+	if policy == nil || policy.Spec.Route == nil {
+		return nil
+	}
+
+	return policy.Spec.Route.Istio
+}
+
+// IstioNamespace returns the namespace of the Istio ingress gateway.
+func IstioNamespace(istio *webappv1.IstioRoutePolicy) string {
+	if istio.Namespace != "" {
+		return istio.Namespace
+	}
+
+	return DefaultIstioNamespace
+}
+
+// IstioGatewayName returns the name of the Istio Gateway of the service, unique in the namespace
+// of the ingress gateway.
+func IstioGatewayName(service corev1.Service) string {
+	return service.Namespace + "-" + service.Name
+}
+
+// IstioGateway returns the Istio Gateway terminating TLS for the service with the certificate
+// secret in the namespace of the ingress gateway.
+func IstioGateway(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) *unstructured.Unstructured {
+	istio := RouteIstio(policy)
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	selector := map[string]interface{}{"istio": "ingressgateway"}
+	if len(istio.Selector) > 0 {
+		selector = map[string]interface{}{}
+		for key, value := range istio.Selector {
+			selector[key] = value
+		}
+	}
+
+	gateway := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"selector": selector,
+			"servers": []interface{}{
+				map[string]interface{}{
+					"port":  map[string]interface{}{"number": int64(443), "name": "https", "protocol": "HTTPS"},
+					"hosts": []interface{}{domain},
+					"tls": map[string]interface{}{
+						"mode":           "SIMPLE",
+						"credentialName": TLSSecretName(c, service, policy),
+					},
+				},
+			},
+		},
+	}}
+	gateway.SetGroupVersionKind(IstioGroupVersion.WithKind(IstioGatewayKind))
+	gateway.SetName(IstioGatewayName(service))
+	gateway.SetNamespace(IstioNamespace(istio))
+	gateway.SetLabels(ManagedLabels(service))
+	if annotations := routeAnnotations(c, service); len(annotations) > 0 {
+		gateway.SetAnnotations(annotations)
+	}
+
+	return gateway
+}
+
+// VirtualService returns the VirtualService routing the domain of the service from its Istio
+// Gateway to the service.
+func VirtualService(c *config.Config, service corev1.Service, policy *webappv1.CustomIngressManager) *unstructured.Unstructured {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	virtualService := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"hosts":    []interface{}{domain},
+			"gateways": []interface{}{IstioNamespace(RouteIstio(policy)) + "/" + IstioGatewayName(service)},
+			"http": []interface{}{
+				map[string]interface{}{
+					"route": []interface{}{
+						map[string]interface{}{
+							"destination": map[string]interface{}{
+								"host": service.Name + "." + service.Namespace + ".svc.cluster.local",
+								"port": map[string]interface{}{"number": int64(80)},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+	virtualService.SetGroupVersionKind(IstioGroupVersion.WithKind(VirtualServiceKind))
+	virtualService.SetName(c.CreateRouteName(service.Name))
+	virtualService.SetNamespace(service.Namespace)
+	virtualService.SetLabels(ManagedLabels(service))
+	virtualService.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))})
+
+	return virtualService
+}
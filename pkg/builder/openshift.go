@@ -0,0 +1,100 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// OpenShiftRouteGroupVersion is the API version of the OpenShift Routes.
+var OpenShiftRouteGroupVersion = schema.GroupVersion{Group: "route.openshift.io", Version: "v1"}
+
+// OpenShiftRouteKind is the kind of the OpenShift Routes.
+const OpenShiftRouteKind = "Route"
+
+// DefaultInsecureEdgeTerminationPolicy redirects plain HTTP requests to HTTPS if the policy
+// sets nothing else.
+const DefaultInsecureEdgeTerminationPolicy = "Redirect"
+
+// RouteOpenShift returns the OpenShift settings of the policy, nil if the services are not
+// exposed with Routes.
+func RouteOpenShift(policy *webappv1.CustomIngressManager) *webappv1.OpenShiftRoutePolicy {
+	if policy == nil || policy.Spec.Route == nil {
+		return nil
+	}
+
+	return policy.Spec.Route.OpenShift
+}
+
+// OpenShiftRoute returns the edge terminated Route of the service. The certificate and key are
+// copied from the TLS secret, the router serves its default certificate until it is issued.
+func OpenShiftRoute(c *config.Config, service corev1.Service, openShift *webappv1.OpenShiftRoutePolicy, secret *corev1.Secret) *unstructured.Unstructured {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	insecure := openShift.InsecureEdgeTerminationPolicy
+	if insecure == "" {
+		insecure = DefaultInsecureEdgeTerminationPolicy
+	}
+	tls := map[string]interface{}{
+		"termination":                   "edge",
+		"insecureEdgeTerminationPolicy": insecure,
+	}
+	if secret != nil && len(secret.Data[corev1.TLSCertKey]) > 0 && len(secret.Data[corev1.TLSPrivateKeyKey]) > 0 {
+		tls["certificate"] = string(secret.Data[corev1.TLSCertKey])
+		tls["key"] = string(secret.Data[corev1.TLSPrivateKeyKey])
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"host": domain,
+			"to":   map[string]interface{}{"kind": "Service", "name": service.Name, "weight": int64(100)},
+			"tls":  tls,
+		},
+	}}
+	if targetPort := routeTargetPort(service); targetPort != "" {
+		route.Object["spec"].(map[string]interface{})["port"] = map[string]interface{}{"targetPort": targetPort}
+	}
+	route.SetGroupVersionKind(OpenShiftRouteGroupVersion.WithKind(OpenShiftRouteKind))
+	route.SetName(c.CreateRouteName(service.Name))
+	route.SetNamespace(service.Namespace)
+	route.SetLabels(ManagedLabels(service))
+	route.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))})
+	if annotations := routeAnnotations(c, service); len(annotations) > 0 {
+		route.SetAnnotations(annotations)
+	}
+
+	return route
+}
+
+// routeTargetPort returns the name of port 80 of the service, Routes select endpoint ports by
+// the name of the service port. Unnamed ports are the only port of the service, the Route
+// needs no port then.
+func routeTargetPort(service corev1.Service) string {
+	for _, port := range service.Spec.Ports {
+		if port.Port == 80 {
+			return port.Name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,104 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	webappv1 "customingressmanager/api/v1"
+	"customingressmanager/pkg/config"
+)
+
+// TraefikVersion is the version of the Traefik IngressRoutes, the same in both API groups.
+const TraefikVersion = "v1alpha1"
+
+// TraefikGroupVersion returns the group version of the IngressRoutes, traefik.io unless the
+// config selects traefik.containo.us.
+func TraefikGroupVersion(c *config.Config) schema.GroupVersion {
+	group := c.TraefikAPIGroup
+	if group == "" {
+		group = config.TraefikAPIGroupIO
+	}
+
+	return schema.GroupVersion{Group: group, Version: TraefikVersion}
+}
+
+// IngressRouteKind is the kind of the Traefik IngressRoutes.
+const IngressRouteKind = "IngressRoute"
+
+const (
+	// DefaultTraefikEntryPoint is the entry point of the IngressRoutes if the policy sets none.
+	DefaultTraefikEntryPoint = "websecure"
+	// TraefikIngressClass is the ingress class served by Traefik, it is used by the cert-manager
+	// HTTP-01 solver of Traefik services.
+	TraefikIngressClass = "traefik"
+)
+
+// RouteTraefik returns the Traefik settings of the policy, nil if the services are not exposed
+// with IngressRoutes.
+func RouteTraefik(policy *webappv1.CustomIngressManager) *webappv1.TraefikRoutePolicy {
+	if policy == nil || policy.Spec.Route == nil {
+		return nil
+	}
+
+	return policy.Spec.Route.Traefik
+}
+
+// IngressRoute returns the Traefik IngressRoute terminating TLS for the service with its
+// certificate secret.
+func IngressRoute(c *config.Config, service corev1.Service, traefik *webappv1.TraefikRoutePolicy) *unstructured.Unstructured {
+	domain, _, _ := c.Domain(service.ObjectMeta.Annotations)
+
+	entryPoints := []interface{}{DefaultTraefikEntryPoint}
+	if len(traefik.EntryPoints) > 0 {
+		entryPoints = nil
+		for _, entryPoint := range traefik.EntryPoints {
+			entryPoints = append(entryPoints, entryPoint)
+		}
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"entryPoints": entryPoints,
+			"routes": []interface{}{
+				map[string]interface{}{
+					"kind":  "Rule",
+					"match": fmt.Sprintf("Host(`%s`)", domain),
+					"services": []interface{}{
+						map[string]interface{}{"name": service.Name, "port": int64(80)},
+					},
+				},
+			},
+			"tls": map[string]interface{}{"secretName": c.CreateTLSSecretName(service.Name)},
+		},
+	}}
+	route.SetGroupVersionKind(TraefikGroupVersion(c).WithKind(IngressRouteKind))
+	route.SetName(c.CreateRouteName(service.Name))
+	route.SetNamespace(service.Namespace)
+	route.SetLabels(ManagedLabels(service))
+	route.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&service, corev1.SchemeGroupVersion.WithKind("Service"))})
+	if annotations := routeAnnotations(c, service); len(annotations) > 0 {
+		route.SetAnnotations(annotations)
+	}
+
+	return route
+}
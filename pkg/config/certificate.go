@@ -0,0 +1,125 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	KeyAlgorithmAnnotation = "feladat.banzaicloud.io/key-algorithm"
+	KeySizeAnnotation      = "feladat.banzaicloud.io/key-size"
+	DurationAnnotation     = "feladat.banzaicloud.io/duration"
+	RenewBeforeAnnotation  = "feladat.banzaicloud.io/renew-before"
+	// RotationPolicyAnnotation sets the private key rotation policy of the certificate of a
+	// service. The Certificate built for the service carries it too, cert-manager v1alpha3 has
+	// no field for it.
+	RotationPolicyAnnotation = "feladat.banzaicloud.io/rotation-policy"
+)
+
+const (
+	// RotationPolicyNever keeps the private key of a certificate on renewal.
+	RotationPolicyNever = "Never"
+	// RotationPolicyAlways generates a new private key on every renewal.
+	RotationPolicyAlways = "Always"
+)
+
+// CertificateOptions are the private key and lifetime settings of a certificate.
+type CertificateOptions struct {
+	KeyAlgorithm   string
+	KeySize        int
+	KeyEncoding    string
+	RotationPolicy string
+	Duration       *metav1.Duration
+	RenewBefore    *metav1.Duration
+}
+
+// CertificateOptions returns the configured certificate options overridden by the service
+// annotations, with the reasons the result is invalid, if any.
+func (c *Config) CertificateOptions(annotations map[string]string) (CertificateOptions, []string) {
+	options := CertificateOptions{
+		KeyAlgorithm:   c.CertificateKeyAlgorithm,
+		KeySize:        c.CertificateKeySize,
+		KeyEncoding:    c.CertificateKeyEncoding,
+		RotationPolicy: c.CertificateRotationPolicy,
+		Duration:       c.CertificateDuration,
+		RenewBefore:    c.CertificateRenewBefore,
+	}
+
+	var problems []string
+
+	if value, ok := annotations[KeyAlgorithmAnnotation]; ok {
+		options.KeyAlgorithm = strings.ToLower(value)
+		if options.KeyAlgorithm != c.CertificateKeyAlgorithm {
+			// the configured key size belongs to the configured algorithm
+			options.KeySize = 0
+		}
+	}
+
+	if value, ok := annotations[KeySizeAnnotation]; ok {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q: must be a number", KeySizeAnnotation, value))
+		}
+		options.KeySize = size
+	}
+
+	if value, ok := annotations[RotationPolicyAnnotation]; ok {
+		options.RotationPolicy = value
+	}
+
+	for key, target := range map[string]**metav1.Duration{
+		DurationAnnotation:    &options.Duration,
+		RenewBeforeAnnotation: &options.RenewBefore,
+	} {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q: must be a duration like 2160h", key, value))
+
+			continue
+		}
+		*target = &metav1.Duration{Duration: duration}
+	}
+
+	problems = append(problems, c.validateKeyOptions(options.KeyAlgorithm, options.KeySize, options.KeyEncoding)...)
+	problems = append(problems, ValidateRotationPolicy(options.RotationPolicy, c.CertManagerAPIVersion)...)
+	problems = append(problems, ValidateDurations(options.Duration, options.RenewBefore)...)
+	sort.Strings(problems)
+
+	return options, problems
+}
+
+// RenewBeforeDuration returns the renew before duration, the cert-manager default if unset.
+func (o CertificateOptions) RenewBeforeDuration() time.Duration {
+	if o.RenewBefore != nil {
+		return o.RenewBefore.Duration
+	}
+
+	return v1alpha3.DefaultRenewBefore
+}
@@ -0,0 +1,757 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	v1alpha3 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	webappv1 "customingressmanager/api/v1"
+)
+
+const (
+	Ed25519KeyAlgorithm      = "ed25519"
+	LetsEncryptProductionURL = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStagingURL    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	ProductionEnvironment    = "production"
+	RootCADuration           = 10 * 365 * 24 * time.Hour
+)
+
+const (
+	DomainAnnotation        = "feladat.banzaicloud.io/domain"
+	EmailAnnotation         = "feladat.banzaicloud.io/email"
+	LegacyDomainAnnotation  = "domain"
+	LegacyEmailAnnotation   = "email"
+	CustomIngressLabel      = "feladat.banzaicloud.io/ingress"
+	CustomIngressLabelValue = "secure"
+	EnvironmentLabel        = "environment"
+	ClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+	RetainSecretAnnotation  = "feladat.banzaicloud.io/retain-secret"
+	// TLSSecretAnnotation pins the TLS secret of a service exposed with an Ingress. Services
+	// exposed before the secret was named after the service keep the namespace wide one.
+	TLSSecretAnnotation = "feladat.banzaicloud.io/tls-secret"
+	// ManagedAnnotationsAnnotation lists the ingress annotations set by us, so annotations
+	// added by other controllers are kept on update.
+	ManagedAnnotationsAnnotation = "feladat.banzaicloud.io/managed-annotations"
+	ManagedByLabel               = "app.kubernetes.io/managed-by"
+	ManagedByLabelValue          = "customingressmanager"
+	ServiceNameLabel             = "feladat.banzaicloud.io/service-name"
+	ServiceNamespaceLabel        = "feladat.banzaicloud.io/service-namespace"
+)
+
+const (
+	// CertificateBackendCertManager issues certificates through cert-manager resources.
+	CertificateBackendCertManager = "cert-manager"
+	// CertificateBackendACME issues certificates with the built-in ACME client.
+	CertificateBackendACME = "acme"
+)
+
+const (
+	// CertManagerAPIVersionV1Alpha3 is the cert-manager API served up to cert-manager 0.16.
+	CertManagerAPIVersionV1Alpha3 = "v1alpha3"
+	// CertManagerAPIVersionV1 is the cert-manager API served since cert-manager 1.0.
+	CertManagerAPIVersionV1 = "v1"
+)
+
+const (
+	// TraefikAPIGroupIO is the API group of the Traefik CRDs since Traefik 2.10.
+	TraefikAPIGroupIO = "traefik.io"
+	// TraefikAPIGroupContainous is the API group of the Traefik CRDs up to Traefik 2.9, served
+	// next to traefik.io until Traefik 3.
+	TraefikAPIGroupContainous = "traefik.containo.us"
+)
+
+// Config holds the label and annotation keys, ACME servers and name suffixes used by the reconciler.
+// It is read from a YAML file and can be overridden by command-line flags.
+type Config struct {
+	IngressLabel            string `json:"ingressLabel"`
+	IngressLabelValue       string `json:"ingressLabelValue"`
+	EnvironmentLabel        string `json:"environmentLabel"`
+	ProductionEnvironment   string `json:"productionEnvironment"`
+	DomainAnnotation        string `json:"domainAnnotation"`
+	EmailAnnotation         string `json:"emailAnnotation"`
+	LegacyDomainAnnotation  string `json:"legacyDomainAnnotation"`
+	LegacyEmailAnnotation   string `json:"legacyEmailAnnotation"`
+	ACMEProductionURL       string `json:"acmeProductionURL"`
+	ACMEStagingURL          string `json:"acmeStagingURL"`
+	IngressNameSuffix       string `json:"ingressNameSuffix"`
+	ClusterIssuerNameSuffix string `json:"clusterIssuerNameSuffix"`
+	SecretNameSuffix        string `json:"secretNameSuffix"`
+	TLSSecretNameSuffix     string `json:"tlsSecretNameSuffix"`
+	CertificateNameSuffix   string `json:"certificateNameSuffix"`
+	RouteNameSuffix         string `json:"routeNameSuffix"`
+	DNSEndpointNameSuffix   string `json:"dnsEndpointNameSuffix"`
+	// Service annotations starting with IngressAnnotationPrefix are copied onto the ingress
+	// with the prefix replaced by IngressAnnotationTargetPrefix.
+	IngressAnnotationPrefix       string `json:"ingressAnnotationPrefix"`
+	IngressAnnotationTargetPrefix string `json:"ingressAnnotationTargetPrefix"`
+	// IngressAnnotationAllowlist lists the names, without the prefix, of the service annotations
+	// copied onto the ingress. Empty copies every name except the snippets, which inject
+	// configuration into the shared ingress controller.
+	IngressAnnotationAllowlist []string `json:"ingressAnnotationAllowlist,omitempty"`
+	// DefaultIngressAnnotations are set on every ingress, service annotations override them.
+	DefaultIngressAnnotations map[string]string `json:"defaultIngressAnnotations,omitempty"`
+	// ManageCertificates makes the reconciler create the cert-manager Certificate itself instead of
+	// annotating the ingress for ingress-shim.
+	ManageCertificates      bool             `json:"manageCertificates"`
+	CertificateDuration     *metav1.Duration `json:"certificateDuration,omitempty"`
+	CertificateRenewBefore  *metav1.Duration `json:"certificateRenewBefore,omitempty"`
+	CertificateKeyAlgorithm string           `json:"certificateKeyAlgorithm,omitempty"`
+	CertificateKeySize      int              `json:"certificateKeySize,omitempty"`
+	CertificateKeyEncoding  string           `json:"certificateKeyEncoding,omitempty"`
+	// CertificateRotationPolicy is the private key rotation policy of the certificates, Never or
+	// Always. Only the cert-manager v1 API supports it.
+	CertificateRotationPolicy string `json:"certificateRotationPolicy,omitempty"`
+	// DefaultIssuanceMode is used for services without an issuance mode annotation or environment.
+	DefaultIssuanceMode string `json:"defaultIssuanceMode"`
+	// ClusterResourceNamespace is the cluster resource namespace of cert-manager, the root CA
+	// secret of the CA issuance mode is stored there.
+	ClusterResourceNamespace string `json:"clusterResourceNamespace"`
+	// IssuerServers are the Vault and Venafi servers CustomIngressManager policies may issue
+	// from, with their credentials. Policies naming other servers are rejected.
+	IssuerServers    []IssuerServer `json:"issuerServers,omitempty"`
+	RootCAIssuerName string         `json:"rootCAIssuerName"`
+	RootCASecretName string         `json:"rootCASecretName"`
+	RootCACommonName string         `json:"rootCACommonName"`
+	// CADomains are the domains, with their subdomains, the root CA issues certificates for.
+	// {namespace} is replaced by the namespace of the service.
+	CADomains []string `json:"caDomains,omitempty"`
+	// CertificateBackend selects who issues the certificates: cert-manager, or the built-in ACME
+	// client on clusters without cert-manager.
+	CertificateBackend string `json:"certificateBackend"`
+	// ACMESolverImage runs the HTTP-01 challenge solver of the built-in ACME client, it needs a
+	// shell with busybox httpd.
+	ACMESolverImage string `json:"acmeSolverImage"`
+	// ACMEAccountSecretNameSuffix is appended to the namespace for the secret holding the account
+	// key of the built-in ACME client.
+	ACMEAccountSecretNameSuffix string `json:"acmeAccountSecretNameSuffix"`
+	// CertManagerAPIVersion is the cert-manager API version the objects are written in. Empty
+	// selects the newest version served by the cluster.
+	CertManagerAPIVersion string `json:"certManagerAPIVersion,omitempty"`
+	// ExternalDNS publishes the domains of the services through external-dns: annotations on the
+	// objects exposing them, or DNSEndpoints. Empty leaves DNS to the user.
+	ExternalDNS string `json:"externalDNS,omitempty"`
+	// ExternalDNSTarget is a comma separated list of record targets, the load balancer of the
+	// ingress controller by default.
+	ExternalDNSTarget string `json:"externalDNSTarget,omitempty"`
+	// ExternalDNSTTL is the TTL of the records in seconds, 0 uses the external-dns default.
+	ExternalDNSTTL int64 `json:"externalDNSTTL,omitempty"`
+	// DNSTargetAllowlist lists the IP addresses, CIDRs and host names, with their subdomains,
+	// the dns-target annotation of a service may point at. Empty rejects the annotation.
+	DNSTargetAllowlist []string `json:"dnsTargetAllowlist,omitempty"`
+	// DNSPreflight holds the first production ACME certificate of a service until its domain
+	// resolves to the load balancer, failed validations count against the rate limits.
+	DNSPreflight bool `json:"dnsPreflight"`
+	// DNSResolver is the host:port of the DNS server queried by the preflight check. Empty uses
+	// the system resolver.
+	DNSResolver string `json:"dnsResolver,omitempty"`
+	// DNSPreflightInterval is the time between two preflight checks of a waiting service.
+	DNSPreflightInterval *metav1.Duration `json:"dnsPreflightInterval,omitempty"`
+	// IstioGatewayService is the LoadBalancer Service of the Istio ingress gateway in the
+	// gateway namespace of the policy, its address is the load balancer of Istio routes.
+	IstioGatewayService string `json:"istioGatewayService"`
+	// TraefikService is the namespace/name of the LoadBalancer Service of Traefik, its address
+	// is the load balancer of Traefik routes. Empty leaves the address unknown.
+	TraefikService string `json:"traefikService,omitempty"`
+	// TraefikAPIGroup is the API group the IngressRoutes are written in, traefik.io or
+	// traefik.containo.us. Empty detects the group served by the cluster.
+	TraefikAPIGroup string `json:"traefikAPIGroup,omitempty"`
+	// RateLimitDomainBudget is the number of new production ACME certificates allowed per
+	// registered domain in a week, Let's Encrypt allows 50. 0 disables the budget.
+	RateLimitDomainBudget int `json:"rateLimitDomainBudget,omitempty"`
+	// RateLimitAccountBudget is the number of production ACME orders allowed per account in three
+	// hours, Let's Encrypt allows 300. 0 disables the budget.
+	RateLimitAccountBudget int `json:"rateLimitAccountBudget,omitempty"`
+	// RateLimitLedger is the ConfigMap in the cluster resource namespace recording the orders
+	// accounted against the budgets.
+	RateLimitLedger string `json:"rateLimitLedger"`
+	// Promotion issues a staging certificate for services switched to production first, the
+	// production ACME server is only used once it was issued.
+	Promotion bool `json:"promotion"`
+	// DomainDenylist lists domains, with their subdomains, no certificate is requested for.
+	DomainDenylist []string `json:"domainDenylist,omitempty"`
+	// CAACheck holds ACME orders of domains whose CAA records do not authorize any of the
+	// CAAIdentities.
+	CAACheck bool `json:"caaCheck"`
+	// CAAIdentities is a comma separated list of the CAA issuer domains of the ACME servers.
+	CAAIdentities string `json:"caaIdentities"`
+}
+
+// DefaultConfig returns the configuration matching the built-in constants.
+func DefaultConfig() *Config {
+	return &Config{
+		IngressLabel:                  CustomIngressLabel,
+		IngressLabelValue:             CustomIngressLabelValue,
+		EnvironmentLabel:              EnvironmentLabel,
+		ProductionEnvironment:         ProductionEnvironment,
+		DomainAnnotation:              DomainAnnotation,
+		EmailAnnotation:               EmailAnnotation,
+		LegacyDomainAnnotation:        LegacyDomainAnnotation,
+		LegacyEmailAnnotation:         LegacyEmailAnnotation,
+		ACMEProductionURL:             LetsEncryptProductionURL,
+		ACMEStagingURL:                LetsEncryptStagingURL,
+		IngressNameSuffix:             "-ingress",
+		ClusterIssuerNameSuffix:       "-lets-encrypt-staging",
+		SecretNameSuffix:              "-secret",
+		TLSSecretNameSuffix:           "-tls",
+		CertificateNameSuffix:         "-certificate",
+		RouteNameSuffix:               "-route",
+		DNSEndpointNameSuffix:         "-dns",
+		IngressAnnotationPrefix:       "ingress.feladat.banzaicloud.io/",
+		IngressAnnotationTargetPrefix: "nginx.ingress.kubernetes.io/",
+		DefaultIssuanceMode:           IssuanceModeACME,
+		ClusterResourceNamespace:      "cert-manager",
+		RootCAIssuerName:              "customingressmanager-selfsigned",
+		RootCASecretName:              "customingressmanager-root-ca",
+		RootCACommonName:              "customingressmanager root CA",
+		CADomains:                     []string{CADomainNamespace + ".svc", CADomainNamespace + ".svc.cluster.local"},
+		CertificateBackend:            CertificateBackendCertManager,
+		ACMESolverImage:               "busybox:1.31",
+		ACMEAccountSecretNameSuffix:   "-acme-account",
+		RateLimitLedger:               "customingressmanager-rate-limits",
+		IstioGatewayService:           "istio-ingressgateway",
+		CAAIdentities:                 DefaultCAAIdentities,
+	}
+}
+
+// BindFlags registers a flag for every config field, using the current values as defaults.
+func (c *Config) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.IngressLabel, "ingress-label", c.IngressLabel, "Label key marking services to manage.")
+	fs.StringVar(&c.IngressLabelValue, "ingress-label-value", c.IngressLabelValue, "Required value of the ingress label.")
+	fs.StringVar(&c.EnvironmentLabel, "environment-label", c.EnvironmentLabel, "Label key selecting the certificate environment.")
+	fs.StringVar(&c.ProductionEnvironment, "production-environment", c.ProductionEnvironment, "Environment label value selecting the production ACME server.")
+	fs.StringVar(&c.DomainAnnotation, "domain-annotation", c.DomainAnnotation, "Annotation key holding the domain of the service.")
+	fs.StringVar(&c.EmailAnnotation, "email-annotation", c.EmailAnnotation, "Annotation key holding the ACME account email.")
+	fs.StringVar(&c.LegacyDomainAnnotation, "legacy-domain-annotation", c.LegacyDomainAnnotation, "Deprecated domain annotation key still read as a fallback. Empty disables the fallback.")
+	fs.StringVar(&c.LegacyEmailAnnotation, "legacy-email-annotation", c.LegacyEmailAnnotation, "Deprecated email annotation key still read as a fallback. Empty disables the fallback.")
+	fs.StringVar(&c.ACMEProductionURL, "acme-production-url", c.ACMEProductionURL, "Directory URL of the production ACME server.")
+	fs.StringVar(&c.ACMEStagingURL, "acme-staging-url", c.ACMEStagingURL, "Directory URL of the staging ACME server.")
+	fs.StringVar(&c.IngressNameSuffix, "ingress-name-suffix", c.IngressNameSuffix, "Suffix appended to the service name for the ingress.")
+	fs.StringVar(&c.ClusterIssuerNameSuffix, "cluster-issuer-name-suffix", c.ClusterIssuerNameSuffix, "Suffix appended to the service name for the cluster issuer.")
+	fs.StringVar(&c.SecretNameSuffix, "secret-name-suffix", c.SecretNameSuffix, "Suffix appended to the namespace for the ACME account key secret.")
+	fs.StringVar(&c.TLSSecretNameSuffix, "tls-secret-name-suffix", c.TLSSecretNameSuffix, "Suffix appended to the service name for the TLS secret.")
+	fs.StringVar(&c.CertificateNameSuffix, "certificate-name-suffix", c.CertificateNameSuffix, "Suffix appended to the service name for the certificate.")
+	fs.StringVar(&c.RouteNameSuffix, "route-name-suffix", c.RouteNameSuffix, "Suffix appended to the service name for the Gateway API HTTPRoute.")
+	fs.StringVar(&c.DNSEndpointNameSuffix, "dns-endpoint-name-suffix", c.DNSEndpointNameSuffix, "Suffix appended to the service name for the external-dns DNSEndpoint.")
+	fs.StringVar(&c.IngressAnnotationPrefix, "ingress-annotation-prefix", c.IngressAnnotationPrefix, "Service annotations with this prefix are copied onto the ingress. Empty disables copying.")
+	fs.StringVar(&c.IngressAnnotationTargetPrefix, "ingress-annotation-target-prefix", c.IngressAnnotationTargetPrefix, "Prefix replacing the ingress annotation prefix on the copied annotations.")
+	fs.BoolVar(&c.ManageCertificates, "manage-certificates", c.ManageCertificates, "Create cert-manager Certificates directly instead of relying on ingress-shim. Off by default, turning it on re-issues the certificates of existing services.")
+	fs.StringVar(&c.CertificateKeyAlgorithm, "certificate-key-algorithm", c.CertificateKeyAlgorithm, "Private key algorithm of the certificates (rsa, ecdsa or ed25519, the latter cert-manager v1 only). Empty uses the cert-manager default.")
+	fs.IntVar(&c.CertificateKeySize, "certificate-key-size", c.CertificateKeySize, "Private key size of the certificates. 0 uses the cert-manager default.")
+	fs.StringVar(&c.DefaultIssuanceMode, "default-issuance-mode", c.DefaultIssuanceMode, "Issuance mode of services without an issuance mode annotation (acme, ca or selfsigned).")
+	fs.StringVar(&c.ClusterResourceNamespace, "cluster-resource-namespace", c.ClusterResourceNamespace, "Cluster resource namespace of cert-manager, the root CA secret is stored there.")
+	fs.StringVar(&c.CertificateKeyEncoding, "certificate-key-encoding", c.CertificateKeyEncoding, "Private key encoding of the certificates (pkcs1 or pkcs8). Empty uses the cert-manager default.")
+	fs.StringVar(&c.CertificateRotationPolicy, "certificate-rotation-policy", c.CertificateRotationPolicy, "Private key rotation policy of the certificates (Never or Always), cert-manager v1 only. Empty uses the cert-manager default.")
+	fs.StringVar(&c.CertificateBackend, "certificate-backend", c.CertificateBackend, "Certificate backend: cert-manager, or acme for the built-in ACME client without cert-manager.")
+	fs.StringVar(&c.ACMESolverImage, "acme-solver-image", c.ACMESolverImage, "Image of the HTTP-01 challenge solver pod of the built-in ACME client.")
+	fs.StringVar(&c.ACMEAccountSecretNameSuffix, "acme-account-secret-name-suffix", c.ACMEAccountSecretNameSuffix, "Suffix appended to the namespace for the account key secret of the built-in ACME client.")
+	fs.StringVar(&c.CertManagerAPIVersion, "cert-manager-api-version", c.CertManagerAPIVersion, "cert-manager API version to use (v1alpha3 or v1). Empty detects the version served by the cluster.")
+	fs.StringVar(&c.ExternalDNS, "external-dns", c.ExternalDNS, "Publish the domains through external-dns: annotations or dnsendpoint. Empty disables it.")
+	fs.StringVar(&c.ExternalDNSTarget, "external-dns-target", c.ExternalDNSTarget, "Comma separated DNS record targets. Empty uses the load balancer of the ingress.")
+	fs.Int64Var(&c.ExternalDNSTTL, "external-dns-ttl", c.ExternalDNSTTL, "TTL of the DNS records in seconds. 0 uses the external-dns default.")
+	fs.BoolVar(&c.DNSPreflight, "dns-preflight", c.DNSPreflight, "Wait until the domain resolves to the load balancer before requesting a production ACME certificate.")
+	fs.StringVar(&c.DNSResolver, "dns-resolver", c.DNSResolver, "host:port of the DNS server used by the preflight check. Empty uses the system resolver.")
+	fs.StringVar(&c.IstioGatewayService, "istio-gateway-service", c.IstioGatewayService, "LoadBalancer Service of the Istio ingress gateway in the gateway namespace, its address is checked by the DNS preflight.")
+	fs.StringVar(&c.TraefikService, "traefik-service", c.TraefikService, "namespace/name of the LoadBalancer Service of Traefik, its address is checked by the DNS preflight.")
+	fs.StringVar(&c.TraefikAPIGroup, "traefik-api-group", c.TraefikAPIGroup, "API group of the Traefik IngressRoutes (traefik.io or traefik.containo.us). Empty detects the group served by the cluster.")
+	fs.BoolVar(&c.Promotion, "promotion", c.Promotion, "Issue a staging certificate before the production one for services labelled production.")
+	fs.BoolVar(&c.CAACheck, "caa-check", c.CAACheck, "Hold ACME orders of domains whose CAA records do not authorize the ACME server.")
+	fs.StringVar(&c.CAAIdentities, "caa-identities", c.CAAIdentities, "Comma separated CAA issuer domains of the ACME servers.")
+	fs.IntVar(&c.RateLimitDomainBudget, "rate-limit-domain-budget", c.RateLimitDomainBudget, "New production ACME certificates allowed per registered domain in a week. 0 disables the budget.")
+	fs.IntVar(&c.RateLimitAccountBudget, "rate-limit-account-budget", c.RateLimitAccountBudget, "Production ACME orders allowed per account in three hours. 0 disables the budget.")
+	fs.StringVar(&c.RateLimitLedger, "rate-limit-ledger", c.RateLimitLedger, "ConfigMap in the cluster resource namespace recording the orders accounted against the rate limit budgets.")
+}
+
+// LoadFile overrides the fields set in the given YAML file. Unknown fields are rejected.
+func (c *Config) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks that the keys are valid label/annotation keys, the ACME servers are
+// https URLs and the suffixes produce valid object names.
+func (c *Config) Validate() error {
+	var problems []string
+
+	for name, key := range map[string]string{
+		"ingressLabel":     c.IngressLabel,
+		"environmentLabel": c.EnvironmentLabel,
+		"domainAnnotation": c.DomainAnnotation,
+		"emailAnnotation":  c.EmailAnnotation,
+	} {
+		for _, msg := range validation.IsQualifiedName(key) {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", name, key, msg))
+		}
+	}
+
+	for name, key := range map[string]string{
+		"legacyDomainAnnotation": c.LegacyDomainAnnotation,
+		"legacyEmailAnnotation":  c.LegacyEmailAnnotation,
+	} {
+		if key == "" {
+			continue
+		}
+
+		for _, msg := range validation.IsQualifiedName(key) {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", name, key, msg))
+		}
+	}
+
+	for name, prefix := range map[string]string{
+		"ingressAnnotationPrefix":       c.IngressAnnotationPrefix,
+		"ingressAnnotationTargetPrefix": c.IngressAnnotationTargetPrefix,
+	} {
+		if prefix == "" {
+			continue
+		}
+
+		if !strings.HasSuffix(prefix, "/") {
+			problems = append(problems, fmt.Sprintf("%s %q: must end with /", name, prefix))
+
+			continue
+		}
+
+		for _, msg := range validation.IsQualifiedName(prefix + "a") {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", name, prefix, msg))
+		}
+	}
+
+	for _, name := range c.IngressAnnotationAllowlist {
+		for _, msg := range validation.IsQualifiedName(c.IngressAnnotationTargetPrefix + name) {
+			problems = append(problems, fmt.Sprintf("ingressAnnotationAllowlist %q: %s", name, msg))
+		}
+	}
+
+	for key := range c.DefaultIngressAnnotations {
+		for _, msg := range validation.IsQualifiedName(key) {
+			problems = append(problems, fmt.Sprintf("defaultIngressAnnotations %q: %s", key, msg))
+		}
+	}
+
+	for _, msg := range validation.IsValidLabelValue(c.IngressLabelValue) {
+		problems = append(problems, fmt.Sprintf("ingressLabelValue %q: %s", c.IngressLabelValue, msg))
+	}
+
+	for name, rawURL := range map[string]string{
+		"acmeProductionURL": c.ACMEProductionURL,
+		"acmeStagingURL":    c.ACMEStagingURL,
+	} {
+		if u, err := url.Parse(rawURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s %q: must be an https URL", name, rawURL))
+		}
+	}
+
+	for name, suffix := range map[string]string{
+		"ingressNameSuffix":           c.IngressNameSuffix,
+		"clusterIssuerNameSuffix":     c.ClusterIssuerNameSuffix,
+		"secretNameSuffix":            c.SecretNameSuffix,
+		"tlsSecretNameSuffix":         c.TLSSecretNameSuffix,
+		"certificateNameSuffix":       c.CertificateNameSuffix,
+		"routeNameSuffix":             c.RouteNameSuffix,
+		"dnsEndpointNameSuffix":       c.DNSEndpointNameSuffix,
+		"acmeAccountSecretNameSuffix": c.ACMEAccountSecretNameSuffix,
+	} {
+		if suffix == "" {
+			problems = append(problems, fmt.Sprintf("%s: must not be empty", name))
+
+			continue
+		}
+
+		for _, msg := range validation.IsDNS1123Subdomain("a" + suffix) {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", name, suffix, msg))
+		}
+	}
+
+	if c.ACMEAccountSecretNameSuffix == c.SecretNameSuffix {
+		problems = append(problems, fmt.Sprintf("acmeAccountSecretNameSuffix %q: must differ from secretNameSuffix", c.ACMEAccountSecretNameSuffix))
+	}
+
+	problems = append(problems, validateIssuerServers(c.IssuerServers)...)
+
+	if !IsValidIssuanceMode(c.DefaultIssuanceMode) {
+		problems = append(problems, fmt.Sprintf("defaultIssuanceMode %q: must be acme, ca or selfsigned", c.DefaultIssuanceMode))
+	}
+
+	for name, value := range map[string]string{
+		"clusterResourceNamespace": c.ClusterResourceNamespace,
+		"rootCAIssuerName":         c.RootCAIssuerName,
+		"rootCASecretName":         c.RootCASecretName,
+		"rateLimitLedger":          c.RateLimitLedger,
+		"istioGatewayService":      c.IstioGatewayService,
+	} {
+		for _, msg := range validation.IsDNS1123Subdomain(value) {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", name, value, msg))
+		}
+	}
+
+	switch c.CertificateBackend {
+	case CertificateBackendCertManager:
+	case CertificateBackendACME:
+		if c.DefaultIssuanceMode != IssuanceModeACME {
+			problems = append(problems, fmt.Sprintf("defaultIssuanceMode %q: the acme certificate backend only supports acme", c.DefaultIssuanceMode))
+		}
+		if c.ACMESolverImage == "" {
+			problems = append(problems, "acmeSolverImage: must not be empty")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("certificateBackend %q: must be cert-manager or acme", c.CertificateBackend))
+	}
+
+	switch c.CertManagerAPIVersion {
+	case "", CertManagerAPIVersionV1Alpha3, CertManagerAPIVersionV1:
+	default:
+		problems = append(problems, fmt.Sprintf("certManagerAPIVersion %q: must be v1alpha3 or v1", c.CertManagerAPIVersion))
+	}
+
+	switch c.ExternalDNS {
+	case "", ExternalDNSAnnotations, ExternalDNSEndpoint:
+	default:
+		problems = append(problems, fmt.Sprintf("externalDNS %q: must be annotations or dnsendpoint", c.ExternalDNS))
+	}
+
+	problems = append(problems, ValidateDNSOptions(DNSOptions{Targets: splitTargets(c.ExternalDNSTarget), TTL: c.ExternalDNSTTL})...)
+
+	if c.DNSResolver != "" {
+		if _, port, err := net.SplitHostPort(c.DNSResolver); err != nil || port == "" {
+			problems = append(problems, fmt.Sprintf("dnsResolver %q: must be host:port", c.DNSResolver))
+		}
+	}
+
+	if c.TraefikService != "" {
+		if namespace, name := c.TraefikServiceName(); namespace == "" || len(validation.IsDNS1123Label(namespace)) > 0 || len(validation.IsDNS1123Subdomain(name)) > 0 {
+			problems = append(problems, fmt.Sprintf("traefikService %q: must be namespace/name", c.TraefikService))
+		}
+	}
+
+	switch c.TraefikAPIGroup {
+	case "", TraefikAPIGroupIO, TraefikAPIGroupContainous:
+	default:
+		problems = append(problems, fmt.Sprintf("traefikAPIGroup %q: must be traefik.io or traefik.containo.us", c.TraefikAPIGroup))
+	}
+
+	if c.DNSPreflightInterval != nil && c.DNSPreflightInterval.Duration <= 0 {
+		problems = append(problems, fmt.Sprintf("dnsPreflightInterval %s: must be positive", c.DNSPreflightInterval.Duration))
+	}
+
+	for _, allowed := range c.DNSTargetAllowlist {
+		if _, _, err := net.ParseCIDR(allowed); err == nil || net.ParseIP(allowed) != nil {
+			continue
+		}
+
+		for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(allowed)) {
+			problems = append(problems, fmt.Sprintf("dnsTargetAllowlist %q: %s", allowed, msg))
+		}
+	}
+
+	for _, allowed := range c.CADomains {
+		for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(strings.Replace(allowed, CADomainNamespace, "namespace", -1))) {
+			problems = append(problems, fmt.Sprintf("caDomains %q: %s", allowed, msg))
+		}
+	}
+
+	for _, denied := range c.DomainDenylist {
+		for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(denied)) {
+			problems = append(problems, fmt.Sprintf("domainDenylist %q: %s", denied, msg))
+		}
+	}
+
+	if c.CAACheck && len(c.CAAIdentityList()) == 0 {
+		problems = append(problems, "caaIdentities: must not be empty with caaCheck")
+	}
+
+	for name, budget := range map[string]int{
+		"rateLimitDomainBudget":  c.RateLimitDomainBudget,
+		"rateLimitAccountBudget": c.RateLimitAccountBudget,
+	} {
+		if budget < 0 {
+			problems = append(problems, fmt.Sprintf("%s %d: must not be negative", name, budget))
+		}
+	}
+
+	problems = append(problems, c.validateKeyOptions(c.CertificateKeyAlgorithm, c.CertificateKeySize, c.CertificateKeyEncoding)...)
+	problems = append(problems, ValidateRotationPolicy(c.CertificateRotationPolicy, c.CertManagerAPIVersion)...)
+	problems = append(problems, ValidateDurations(c.CertificateDuration, c.CertificateRenewBefore)...)
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// ACMEServerURL returns the production or staging ACME server for the environment label value.
+func (c *Config) ACMEServerURL(environment string) string {
+	if environment == c.ProductionEnvironment {
+		return c.ACMEProductionURL
+	}
+
+	return c.ACMEStagingURL
+}
+
+// Domain returns the domain annotation value, internationalized domain names normalized to
+// lowercase A-labels, see NormalizeDomain. legacy is true if the value was read from the
+// deprecated key because the current one is not set. A missing annotation or a value that can
+// not be normalized is an error, value is the annotation value as is then.
+func (c *Config) Domain(annotations map[string]string) (value string, legacy bool, err error) {
+	value, legacy = lookupAnnotation(annotations, c.DomainAnnotation, c.LegacyDomainAnnotation)
+	if value == "" {
+		return "", legacy, fmt.Errorf("domain annotation missing")
+	}
+
+	normalized, err := NormalizeDomain(value)
+	if err != nil {
+		return value, legacy, fmt.Errorf("domain %q: not a valid internationalized domain name: %v", value, err)
+	}
+
+	return normalized, legacy, nil
+}
+
+// Email returns the email annotation value. legacy is true if the value was read from the
+// deprecated key because the current one is not set.
+func (c *Config) Email(annotations map[string]string) (value string, legacy bool) {
+	return lookupAnnotation(annotations, c.EmailAnnotation, c.LegacyEmailAnnotation)
+}
+
+func lookupAnnotation(annotations map[string]string, key, legacyKey string) (string, bool) {
+	if value, ok := annotations[key]; ok {
+		return value, false
+	}
+
+	if legacyKey != "" {
+		if value, ok := annotations[legacyKey]; ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// IngressAnnotations returns the default ingress annotations of the config, overridden by those
+// of the policy, if any, and then by the prefixed annotations of the service. Service
+// annotations not allowed by the config are left out.
+func (c *Config) IngressAnnotations(serviceAnnotations map[string]string, policy *webappv1.CustomIngressManager) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range c.DefaultIngressAnnotations {
+		annotations[key] = value
+	}
+	if policy != nil {
+		for key, value := range policy.Spec.DefaultIngressAnnotations {
+			annotations[key] = value
+		}
+	}
+
+	for key, value := range serviceAnnotations {
+		if name, ok := c.ingressAnnotationName(key); ok && c.IsAllowedIngressAnnotation(name) {
+			annotations[c.IngressAnnotationTargetPrefix+name] = value
+		}
+	}
+
+	return annotations
+}
+
+// DeniedIngressAnnotations returns the prefixed annotations of the service which are not copied
+// onto the ingress, sorted.
+func (c *Config) DeniedIngressAnnotations(serviceAnnotations map[string]string) []string {
+	var denied []string
+	for key := range serviceAnnotations {
+		if name, ok := c.ingressAnnotationName(key); ok && !c.IsAllowedIngressAnnotation(name) {
+			denied = append(denied, key)
+		}
+	}
+	sort.Strings(denied)
+
+	return denied
+}
+
+// IsAllowedIngressAnnotation reports whether the service annotation of the name, without the
+// prefix, may be copied onto the ingress. Snippets are only allowed by the allowlist.
+func (c *Config) IsAllowedIngressAnnotation(name string) bool {
+	if len(c.IngressAnnotationAllowlist) == 0 {
+		return !strings.HasSuffix(name, "snippet")
+	}
+
+	for _, allowed := range c.IngressAnnotationAllowlist {
+		if name == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ingressAnnotationName returns the name of a prefixed service annotation without the prefix.
+func (c *Config) ingressAnnotationName(key string) (string, bool) {
+	if c.IngressAnnotationPrefix == "" {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(key, c.IngressAnnotationPrefix)
+
+	return name, name != key && name != ""
+}
+
+// validateKeyOptions checks the private key options against the cert-manager API version in use
+// and the certificate backend.
+func (c *Config) validateKeyOptions(algorithm string, size int, encoding string) []string {
+	problems := ValidateKeyOptions(algorithm, size, encoding, c.CertManagerAPIVersion)
+	if c.CertificateBackend == CertificateBackendACME && algorithm == Ed25519KeyAlgorithm {
+		problems = append(problems, fmt.Sprintf("key algorithm %q: not supported by the built-in ACME client", algorithm))
+	}
+
+	return problems
+}
+
+// ValidateKeyOptions checks the private key options against the values accepted by the
+// cert-manager API version. Ed25519 is accepted while the version is not detected yet.
+func ValidateKeyOptions(algorithm string, size int, encoding, version string) []string {
+	var problems []string
+
+	switch v1alpha3.KeyAlgorithm(algorithm) {
+	case "", v1alpha3.RSAKeyAlgorithm:
+		if size != 0 && (size < 2048 || size > 8192) {
+			problems = append(problems, fmt.Sprintf("key size %d: must be between 2048 and 8192 for rsa", size))
+		}
+	case v1alpha3.ECDSAKeyAlgorithm:
+		if size != 0 && size != 256 && size != 384 && size != 521 {
+			problems = append(problems, fmt.Sprintf("key size %d: must be 256, 384 or 521 for ecdsa", size))
+		}
+	case Ed25519KeyAlgorithm:
+		if version == CertManagerAPIVersionV1Alpha3 {
+			problems = append(problems, fmt.Sprintf("key algorithm %q: not supported by the cert-manager v1alpha3 API", algorithm))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("key algorithm %q: must be rsa, ecdsa or ed25519", algorithm))
+	}
+
+	switch v1alpha3.KeyEncoding(encoding) {
+	case "", v1alpha3.PKCS1, v1alpha3.PKCS8:
+	default:
+		problems = append(problems, fmt.Sprintf("key encoding %q: must be pkcs1 or pkcs8", encoding))
+	}
+
+	return problems
+}
+
+// ValidateRotationPolicy checks the private key rotation policy against the cert-manager API
+// version. The policy is accepted while the version is not detected yet.
+func ValidateRotationPolicy(policy, version string) []string {
+	switch policy {
+	case "":
+		return nil
+	case RotationPolicyNever, RotationPolicyAlways:
+		if version == CertManagerAPIVersionV1Alpha3 {
+			return []string{fmt.Sprintf("rotation policy %q: not supported by the cert-manager v1alpha3 API", policy)}
+		}
+
+		return nil
+	}
+
+	return []string{fmt.Sprintf("rotation policy %q: must be Never or Always", policy)}
+}
+
+// ValidateDurations checks the certificate duration and renewBefore. Either may be nil.
+func ValidateDurations(duration, renewBefore *metav1.Duration) []string {
+	var problems []string
+
+	if duration != nil && duration.Duration < v1alpha3.MinimumCertificateDuration {
+		problems = append(problems, fmt.Sprintf("duration %s: must be at least %s", duration.Duration, v1alpha3.MinimumCertificateDuration))
+	}
+
+	if renewBefore != nil && renewBefore.Duration < v1alpha3.MinimumRenewBefore {
+		problems = append(problems, fmt.Sprintf("renewBefore %s: must be at least %s", renewBefore.Duration, v1alpha3.MinimumRenewBefore))
+	}
+
+	if duration != nil && renewBefore != nil && renewBefore.Duration >= duration.Duration {
+		problems = append(problems, fmt.Sprintf("renewBefore %s: must be shorter than the duration %s", renewBefore.Duration, duration.Duration))
+	}
+
+	return problems
+}
+
+func (c *Config) CreateIngressName(name string) string {
+	return name + c.IngressNameSuffix
+}
+
+func (c *Config) CreateClusterIssuerName(name string) string {
+	return name + c.ClusterIssuerNameSuffix
+}
+
+func (c *Config) CreateSecretName(name string) string {
+	return name + c.SecretNameSuffix
+}
+
+// TraefikServiceName returns the namespace and name of the Traefik Service, empty if not set.
+func (c *Config) TraefikServiceName() (string, string) {
+	parts := strings.SplitN(c.TraefikService, "/", 2)
+	if len(parts) != 2 {
+		return "", c.TraefikService
+	}
+
+	return parts[0], parts[1]
+}
+
+func (c *Config) CreateACMEAccountSecretName(namespace string) string {
+	return namespace + c.ACMEAccountSecretNameSuffix
+}
+
+func (c *Config) CreateTLSSecretName(name string) string {
+	return name + c.TLSSecretNameSuffix
+}
+
+// ServiceTLSSecretName returns the TLS secret of a service exposed with an Ingress: the one
+// pinned by the TLS secret annotation, if any.
+func (c *Config) ServiceTLSSecretName(service corev1.Service) string {
+	if name := service.ObjectMeta.Annotations[TLSSecretAnnotation]; name != "" {
+		return name
+	}
+
+	return c.CreateTLSSecretName(service.Name)
+}
+
+func (c *Config) CreateCertificateName(name string) string {
+	return name + c.CertificateNameSuffix
+}
+
+func (c *Config) CreateRouteName(name string) string {
+	return name + c.RouteNameSuffix
+}
+
+func (c *Config) CreateDNSEndpointName(name string) string {
+	return name + c.DNSEndpointNameSuffix
+}
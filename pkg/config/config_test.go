@@ -0,0 +1,699 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	webappv1 "customingressmanager/api/v1"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{
+			name:    "Default",
+			modify:  func(c *Config) {},
+			wantErr: false,
+		},
+		{
+			name:    "PrefixedKeys",
+			modify:  func(c *Config) { c.DomainAnnotation = "example.com/domain" },
+			wantErr: false,
+		},
+		{
+			name:    "InvalidLabelKey",
+			modify:  func(c *Config) { c.IngressLabel = "not a label" },
+			wantErr: true,
+		},
+		{
+			name:    "InsecureACMEServer",
+			modify:  func(c *Config) { c.ACMEStagingURL = "http://localhost:14000/dir" },
+			wantErr: true,
+		},
+		{
+			name: "IssuerServer",
+			modify: func(c *Config) {
+				c.IssuerServers = []IssuerServer{{URL: "https://tpp.example.com/vedsdk", VenafiTPPCredentialsSecretName: "tpp-credentials"}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "InsecureIssuerServer",
+			modify: func(c *Config) {
+				c.IssuerServers = []IssuerServer{{URL: "http://tpp.example.com/vedsdk", VenafiTPPCredentialsSecretName: "tpp-credentials"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "IssuerServerWithoutCredentials",
+			modify: func(c *Config) {
+				c.IssuerServers = []IssuerServer{{URL: "https://vault.example.com", VaultAuth: &webappv1.VaultAuthPolicy{}}}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "EmptySuffix",
+			modify:  func(c *Config) { c.IngressNameSuffix = "" },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidSuffix",
+			modify:  func(c *Config) { c.SecretNameSuffix = "_Secret" },
+			wantErr: true,
+		},
+		{
+			name:    "ACMEAccountSecretIsTLSSecret",
+			modify:  func(c *Config) { c.ACMEAccountSecretNameSuffix = c.SecretNameSuffix },
+			wantErr: true,
+		},
+		{
+			name: "ECDSAKey",
+			modify: func(c *Config) {
+				c.CertificateKeyAlgorithm = "ecdsa"
+				c.CertificateKeySize = 384
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidECDSAKeySize",
+			modify: func(c *Config) {
+				c.CertificateKeyAlgorithm = "ecdsa"
+				c.CertificateKeySize = 2048
+			},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownKeyAlgorithm",
+			modify:  func(c *Config) { c.CertificateKeyAlgorithm = "dsa" },
+			wantErr: true,
+		},
+		{
+			name: "RenewBeforeLongerThanDuration",
+			modify: func(c *Config) {
+				c.CertificateDuration = &metav1.Duration{Duration: 24 * time.Hour}
+				c.CertificateRenewBefore = &metav1.Duration{Duration: 48 * time.Hour}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "ACMEBackend",
+			modify:  func(c *Config) { c.CertificateBackend = CertificateBackendACME },
+			wantErr: false,
+		},
+		{
+			name: "ACMEBackendWithCAMode",
+			modify: func(c *Config) {
+				c.CertificateBackend = CertificateBackendACME
+				c.DefaultIssuanceMode = IssuanceModeCA
+			},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownBackend",
+			modify:  func(c *Config) { c.CertificateBackend = "vault" },
+			wantErr: true,
+		},
+		{
+			name:    "CertManagerV1",
+			modify:  func(c *Config) { c.CertManagerAPIVersion = CertManagerAPIVersionV1 },
+			wantErr: false,
+		},
+		{
+			name: "RotationPolicyV1",
+			modify: func(c *Config) {
+				c.CertManagerAPIVersion = CertManagerAPIVersionV1
+				c.CertificateRotationPolicy = RotationPolicyAlways
+			},
+			wantErr: false,
+		},
+		{
+			name: "RotationPolicyV1Alpha3",
+			modify: func(c *Config) {
+				c.CertManagerAPIVersion = CertManagerAPIVersionV1Alpha3
+				c.CertificateRotationPolicy = RotationPolicyAlways
+			},
+			wantErr: true,
+		},
+		{
+			name: "Ed25519V1",
+			modify: func(c *Config) {
+				c.CertManagerAPIVersion = CertManagerAPIVersionV1
+				c.CertificateKeyAlgorithm = Ed25519KeyAlgorithm
+			},
+			wantErr: false,
+		},
+		{
+			name: "Ed25519V1Alpha3",
+			modify: func(c *Config) {
+				c.CertManagerAPIVersion = CertManagerAPIVersionV1Alpha3
+				c.CertificateKeyAlgorithm = Ed25519KeyAlgorithm
+			},
+			wantErr: true,
+		},
+		{
+			name: "Ed25519ACME",
+			modify: func(c *Config) {
+				c.CertificateBackend = CertificateBackendACME
+				c.CertificateKeyAlgorithm = Ed25519KeyAlgorithm
+			},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownCertManagerAPIVersion",
+			modify:  func(c *Config) { c.CertManagerAPIVersion = "v1alpha2" },
+			wantErr: true,
+		},
+		{
+			name: "ExternalDNSEndpoint",
+			modify: func(c *Config) {
+				c.ExternalDNS = ExternalDNSEndpoint
+				c.ExternalDNSTarget = "203.0.113.10, lb.example.com"
+				c.ExternalDNSTTL = 300
+			},
+			wantErr: false,
+		},
+		{
+			name:    "UnknownExternalDNSMode",
+			modify:  func(c *Config) { c.ExternalDNS = "route53" },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidExternalDNSTarget",
+			modify:  func(c *Config) { c.ExternalDNSTarget = "not a host" },
+			wantErr: true,
+		},
+		{
+			name: "DNSPreflight",
+			modify: func(c *Config) {
+				c.DNSPreflight = true
+				c.DNSResolver = "1.1.1.1:53"
+			},
+			wantErr: false,
+		},
+		{
+			name:    "InvalidDNSResolver",
+			modify:  func(c *Config) { c.DNSResolver = "1.1.1.1" },
+			wantErr: true,
+		},
+		{
+			name:    "IngressAnnotationAllowlist",
+			modify:  func(c *Config) { c.IngressAnnotationAllowlist = []string{"proxy-body-size"} },
+			wantErr: false,
+		},
+		{
+			name:    "InvalidIngressAnnotationAllowlist",
+			modify:  func(c *Config) { c.IngressAnnotationAllowlist = []string{"proxy body size"} },
+			wantErr: true,
+		},
+		{
+			name:    "DNSTargetAllowlist",
+			modify:  func(c *Config) { c.DNSTargetAllowlist = []string{"203.0.113.0/24", "2001:db8::1", "lb.example.com"} },
+			wantErr: false,
+		},
+		{
+			name:    "InvalidDNSTargetAllowlist",
+			modify:  func(c *Config) { c.DNSTargetAllowlist = []string{"203.0.113.0/33"} },
+			wantErr: true,
+		},
+		{
+			name:    "TraefikService",
+			modify:  func(c *Config) { c.TraefikService = "traefik/traefik" },
+			wantErr: false,
+		},
+		{
+			name:    "TraefikServiceWithoutNamespace",
+			modify:  func(c *Config) { c.TraefikService = "traefik" },
+			wantErr: true,
+		},
+		{
+			name:    "EmptyIstioGatewayService",
+			modify:  func(c *Config) { c.IstioGatewayService = "" },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidDNSPreflightInterval",
+			modify:  func(c *Config) { c.DNSPreflightInterval = &metav1.Duration{} },
+			wantErr: true,
+		},
+		{
+			name: "RateLimitBudgets",
+			modify: func(c *Config) {
+				c.RateLimitDomainBudget = 40
+				c.RateLimitAccountBudget = 250
+			},
+			wantErr: false,
+		},
+		{
+			name:    "InvalidCADomains",
+			modify:  func(c *Config) { c.CADomains = []string{"{namespace}.svc", "*.corp"} },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidDomainDenylist",
+			modify:  func(c *Config) { c.DomainDenylist = []string{"*.corp"} },
+			wantErr: true,
+		},
+		{
+			name: "CAACheckWithoutIdentities",
+			modify: func(c *Config) {
+				c.CAACheck = true
+				c.CAAIdentities = " "
+			},
+			wantErr: true,
+		},
+		{
+			name:    "NegativeRateLimitBudget",
+			modify:  func(c *Config) { c.RateLimitDomainBudget = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "InvalidRateLimitLedger",
+			modify:  func(c *Config) { c.RateLimitLedger = "" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.modify(c)
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		want    func(c *Config)
+		wantErr bool
+	}{
+		{
+			name:    "PartialOverride",
+			content: "domainAnnotation: example.com/domain\ningressNameSuffix: -web\n",
+			want: func(c *Config) {
+				c.DomainAnnotation = "example.com/domain"
+				c.IngressNameSuffix = "-web"
+			},
+			wantErr: false,
+		},
+		{
+			name:    "UnknownField",
+			content: "domainAnotation: example.com/domain\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got := DefaultConfig()
+			err := got.LoadFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.LoadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			want := DefaultConfig()
+			tt.want(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Config.LoadFile() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestConfig_IngressAnnotations(t *testing.T) {
+	tests := []struct {
+		name               string
+		defaults           map[string]string
+		policyDefaults     map[string]string
+		allowlist          []string
+		serviceAnnotations map[string]string
+		want               map[string]string
+		wantDenied         []string
+	}{
+		{
+			name:               "NoAnnotations",
+			serviceAnnotations: map[string]string{"feladat.banzaicloud.io/domain": "test.com"},
+			want:               map[string]string{},
+		},
+		{
+			name:     "PrefixedAnnotationsOverrideDefaults",
+			defaults: map[string]string{"nginx.ingress.kubernetes.io/ssl-redirect": "true", "nginx.ingress.kubernetes.io/proxy-body-size": "1m"},
+			serviceAnnotations: map[string]string{
+				"feladat.banzaicloud.io/domain":                  "test.com",
+				"ingress.feladat.banzaicloud.io/proxy-body-size": "8m",
+				"ingress.feladat.banzaicloud.io/auth-type":       "basic",
+			},
+			want: map[string]string{
+				"nginx.ingress.kubernetes.io/ssl-redirect":    "true",
+				"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+				"nginx.ingress.kubernetes.io/auth-type":       "basic",
+			},
+		},
+		{
+			name:           "PolicyDefaultsBetweenDefaultsAndService",
+			defaults:       map[string]string{"kubernetes.io/ingress.class": "nginx", "nginx.ingress.kubernetes.io/ssl-redirect": "true", "nginx.ingress.kubernetes.io/proxy-body-size": "1m"},
+			policyDefaults: map[string]string{"kubernetes.io/ingress.class": "nginx-internal", "nginx.ingress.kubernetes.io/proxy-body-size": "4m"},
+			serviceAnnotations: map[string]string{
+				"ingress.feladat.banzaicloud.io/proxy-body-size": "8m",
+			},
+			want: map[string]string{
+				"kubernetes.io/ingress.class":                 "nginx-internal",
+				"nginx.ingress.kubernetes.io/ssl-redirect":    "true",
+				"nginx.ingress.kubernetes.io/proxy-body-size": "8m",
+			},
+		},
+		{
+			name: "SnippetsDenied",
+			serviceAnnotations: map[string]string{
+				"ingress.feladat.banzaicloud.io/ssl-redirect":          "true",
+				"ingress.feladat.banzaicloud.io/configuration-snippet": "more_set_headers \"X: y\";",
+				"ingress.feladat.banzaicloud.io/server-snippet":        "location / {}",
+			},
+			want: map[string]string{"nginx.ingress.kubernetes.io/ssl-redirect": "true"},
+			wantDenied: []string{
+				"ingress.feladat.banzaicloud.io/configuration-snippet",
+				"ingress.feladat.banzaicloud.io/server-snippet",
+			},
+		},
+		{
+			name:      "Allowlist",
+			allowlist: []string{"proxy-body-size", "configuration-snippet"},
+			serviceAnnotations: map[string]string{
+				"ingress.feladat.banzaicloud.io/proxy-body-size":       "8m",
+				"ingress.feladat.banzaicloud.io/configuration-snippet": "more_set_headers \"X: y\";",
+				"ingress.feladat.banzaicloud.io/auth-url":              "http://auth.internal",
+			},
+			want: map[string]string{
+				"nginx.ingress.kubernetes.io/proxy-body-size":       "8m",
+				"nginx.ingress.kubernetes.io/configuration-snippet": "more_set_headers \"X: y\";",
+			},
+			wantDenied: []string{"ingress.feladat.banzaicloud.io/auth-url"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.DefaultIngressAnnotations = tt.defaults
+			c.IngressAnnotationAllowlist = tt.allowlist
+			policy := &webappv1.CustomIngressManager{Spec: webappv1.CustomIngressManagerSpec{DefaultIngressAnnotations: tt.policyDefaults}}
+			if got := c.IngressAnnotations(tt.serviceAnnotations, policy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.IngressAnnotations() = %v, want %v", got, tt.want)
+			}
+			if got := c.DeniedIngressAnnotations(tt.serviceAnnotations); !reflect.DeepEqual(got, tt.wantDenied) {
+				t.Errorf("Config.DeniedIngressAnnotations() = %v, want %v", got, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestConfig_DNSOptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		mode            string
+		annotations     map[string]string
+		want            DNSOptions
+		wantProblems    bool
+		wantAnnotations map[string]string
+	}{
+		{
+			name:        "Defaults",
+			mode:        ExternalDNSAnnotations,
+			annotations: map[string]string{},
+			want:        DNSOptions{Targets: []string{"lb.example.com"}, TTL: 60},
+			wantAnnotations: map[string]string{
+				ExternalDNSHostnameAnnotation: "test.com",
+				ExternalDNSTargetAnnotation:   "lb.example.com",
+				ExternalDNSTTLAnnotation:      "60",
+			},
+		},
+		{
+			name:            "AnnotationsOverride",
+			mode:            ExternalDNSAnnotations,
+			annotations:     map[string]string{DNSTargetAnnotation: "203.0.113.10,2001:db8::1", DNSTTLAnnotation: "0"},
+			want:            DNSOptions{Targets: []string{"203.0.113.10", "2001:db8::1"}},
+			wantAnnotations: map[string]string{ExternalDNSHostnameAnnotation: "test.com", ExternalDNSTargetAnnotation: "203.0.113.10,2001:db8::1"},
+		},
+		{
+			name:         "InvalidTTL",
+			mode:         ExternalDNSEndpoint,
+			annotations:  map[string]string{DNSTTLAnnotation: "5m"},
+			want:         DNSOptions{Targets: []string{"lb.example.com"}},
+			wantProblems: true,
+		},
+		{
+			name:            "AllowedHostTarget",
+			mode:            ExternalDNSAnnotations,
+			annotations:     map[string]string{DNSTargetAnnotation: "eu.lb.example.net"},
+			want:            DNSOptions{Targets: []string{"eu.lb.example.net"}, TTL: 60},
+			wantAnnotations: map[string]string{ExternalDNSHostnameAnnotation: "test.com", ExternalDNSTargetAnnotation: "eu.lb.example.net", ExternalDNSTTLAnnotation: "60"},
+		},
+		{
+			name:         "TargetNotAllowed",
+			mode:         ExternalDNSEndpoint,
+			annotations:  map[string]string{DNSTargetAnnotation: "198.51.100.1,lb.attacker.com"},
+			want:         DNSOptions{Targets: []string{"lb.example.com"}, TTL: 60},
+			wantProblems: true,
+		},
+		{
+			name:         "InvalidTarget",
+			mode:         ExternalDNSEndpoint,
+			annotations:  map[string]string{DNSTargetAnnotation: "lb_1"},
+			want:         DNSOptions{Targets: []string{"lb.example.com"}, TTL: 60},
+			wantProblems: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.ExternalDNS = tt.mode
+			c.ExternalDNSTarget = "lb.example.com"
+			c.ExternalDNSTTL = 60
+			c.DNSTargetAllowlist = []string{"203.0.113.0/24", "2001:db8::1", "lb.example.net"}
+			got, problems := c.DNSOptions(tt.annotations)
+			if (len(problems) > 0) != tt.wantProblems {
+				t.Fatalf("Config.DNSOptions() problems = %v, wantProblems %v", problems, tt.wantProblems)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.DNSOptions() = %+v, want %+v", got, tt.want)
+			}
+			if got := c.ExternalDNSObjectAnnotations("test.com", got); !reflect.DeepEqual(got, tt.wantAnnotations) {
+				t.Errorf("Config.ExternalDNSObjectAnnotations() = %v, want %v", got, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{domain: "test.com", want: "test.com"},
+		{domain: "www.shop.example.com", want: "example.com"},
+		{domain: "shop.example.co.uk", want: "example.co.uk"},
+		{domain: "Shop.Example.COM.", want: "example.com"},
+		{domain: "app.herokuapp.com", want: "app.herokuapp.com"},
+		{domain: "co.uk", want: "co.uk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := RegisteredDomain(tt.domain); got != tt.want {
+				t.Errorf("RegisteredDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_PromotedService(t *testing.T) {
+	c := DefaultConfig()
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{EnvironmentLabel: ProductionEnvironment},
+			Annotations: map[string]string{PromotionPhaseAnnotation: PromotionPhaseStaging},
+		},
+	}
+
+	if got := c.PromotedService(service); got.Labels[EnvironmentLabel] != PromotionStagingEnvironment {
+		t.Errorf("Config.PromotedService() environment = %q, want %q", got.Labels[EnvironmentLabel], PromotionStagingEnvironment)
+	}
+	if service.Labels[EnvironmentLabel] != ProductionEnvironment {
+		t.Errorf("Config.PromotedService() modified the service")
+	}
+
+	service.Annotations[PromotionPhaseAnnotation] = PromotionPhaseProduction
+	if got := c.PromotedService(service); got.Labels[EnvironmentLabel] != ProductionEnvironment {
+		t.Errorf("Config.PromotedService() environment = %q, want %q", got.Labels[EnvironmentLabel], ProductionEnvironment)
+	}
+}
+
+func TestConfig_Domain(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		wantLegacy  bool
+		wantErr     string
+	}{
+		{name: "ASCII", annotations: map[string]string{DomainAnnotation: "Test.com"}, want: "test.com"},
+		{name: "Internationalized", annotations: map[string]string{DomainAnnotation: "Bücher.de"}, want: "xn--bcher-kva.de"},
+		{name: "Legacy", annotations: map[string]string{"domain": "test.com"}, want: "test.com", wantLegacy: true},
+		{name: "Missing", annotations: map[string]string{}, wantErr: "domain annotation missing"},
+		{name: "Empty", annotations: map[string]string{DomainAnnotation: ""}, wantErr: "domain annotation missing"},
+		{name: "Invalid", annotations: map[string]string{DomainAnnotation: "xn--a.de"}, want: "xn--a.de", wantErr: `domain "xn--a.de": not a valid internationalized domain name`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, legacy, err := DefaultConfig().Domain(tt.annotations)
+			if (err != nil) != (tt.wantErr != "") || err != nil && !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("Config.Domain() error = %v, want %q", err, tt.wantErr)
+			}
+			if got != tt.want || legacy != tt.wantLegacy {
+				t.Errorf("Config.Domain() = %v, %v, want %v, %v", got, legacy, tt.want, tt.wantLegacy)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateDomain(t *testing.T) {
+	tests := []struct {
+		domain       string
+		public       bool
+		wantProblems bool
+	}{
+		{domain: "shop.example.co.uk", public: true},
+		{domain: "test.com", public: true},
+		{domain: "co.uk", public: true, wantProblems: true},
+		{domain: "com", public: true, wantProblems: true},
+		{domain: "herokuapp.com", public: true, wantProblems: true},
+		{domain: "localhost", public: true, wantProblems: true},
+		{domain: "printer.local", public: true, wantProblems: true},
+		{domain: "app.home.arpa", public: true, wantProblems: true},
+		{domain: "203.0.113.10", public: true, wantProblems: true},
+		{domain: "2001:db8::1", public: false, wantProblems: true},
+		{domain: "api.corp.test.com", public: true, wantProblems: true},
+		{domain: "testsvc.default.svc.cluster.local", public: false},
+		{domain: "api.corp.test.com", public: false, wantProblems: true},
+		{domain: "not a domain", public: false, wantProblems: true},
+		{domain: "bücher.de", public: true},
+		{domain: "xn--bcher-kva.de", public: true},
+		{domain: "Straße.de", public: true},
+		{domain: "münchen.рф", public: true},
+		{domain: "bücher.example", public: true, wantProblems: true},
+		{domain: "xn--a.de", public: true, wantProblems: true},
+		{domain: "bücher.default.svc.cluster.local", public: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			c := DefaultConfig()
+			c.DomainDenylist = []string{"corp.test.com"}
+			if problems := c.ValidateDomain(tt.domain, tt.public); (len(problems) > 0) != tt.wantProblems {
+				t.Errorf("Config.ValidateDomain() = %v, wantProblems %v", problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestConfig_IsAllowedCADomain(t *testing.T) {
+	tests := []struct {
+		domain    string
+		namespace string
+		want      bool
+	}{
+		{domain: "testsvc.default.svc.cluster.local", namespace: "default", want: true},
+		{domain: "testsvc.default.svc", namespace: "default", want: true},
+		{domain: "TestSvc.Default.SVC.cluster.local.", namespace: "default", want: true},
+		{domain: "testsvc.other.svc.cluster.local", namespace: "default"},
+		{domain: "default.svc.cluster.local.evil.com", namespace: "default"},
+		{domain: "api.default.corp", namespace: "default", want: true},
+		{domain: "api.other.corp", namespace: "default"},
+		{domain: "shared.corp", namespace: "default", want: true},
+		{domain: "api.corp", namespace: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			c := DefaultConfig()
+			c.CADomains = append(c.CADomains, "{namespace}.corp", "shared.corp")
+			if got := c.IsAllowedCADomain(tt.domain, tt.namespace); got != tt.want {
+				t.Errorf("Config.IsAllowedCADomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain      string
+		want        string
+		wantUnicode string
+		wantErr     bool
+	}{
+		{domain: "test.com", want: "test.com", wantUnicode: "test.com"},
+		{domain: "Shop.Test.COM", want: "shop.test.com", wantUnicode: "shop.test.com"},
+		{domain: "bücher.de", want: "xn--bcher-kva.de", wantUnicode: "bücher.de"},
+		{domain: "BÜCHER.de", want: "xn--bcher-kva.de", wantUnicode: "bücher.de"},
+		{domain: "xn--bcher-kva.de", want: "xn--bcher-kva.de", wantUnicode: "bücher.de"},
+		{domain: "straße.de", want: "xn--strae-oqa.de", wantUnicode: "straße.de"},
+		{domain: "bad_label.com", wantErr: true},
+		{domain: "-bücher.de", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			got, err := NormalizeDomain(tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeDomain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeDomain() = %v, want %v", got, tt.want)
+			}
+			if unicode := UnicodeDomain(got); unicode != tt.wantUnicode {
+				t.Errorf("UnicodeDomain() = %v, want %v", unicode, tt.wantUnicode)
+			}
+		})
+	}
+}
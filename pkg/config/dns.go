@@ -0,0 +1,173 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// External DNS modes.
+const (
+	// ExternalDNSAnnotations annotates the objects exposing the services for external-dns.
+	ExternalDNSAnnotations = "annotations"
+	// ExternalDNSEndpoint creates a DNSEndpoint per service, read by the crd source of
+	// external-dns.
+	ExternalDNSEndpoint = "dnsendpoint"
+)
+
+const (
+	DNSTargetAnnotation = "feladat.banzaicloud.io/dns-target"
+	DNSTTLAnnotation    = "feladat.banzaicloud.io/dns-ttl"
+
+	ExternalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	ExternalDNSTargetAnnotation   = "external-dns.alpha.kubernetes.io/target"
+	ExternalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+)
+
+// DefaultDNSPreflightInterval is the time between two preflight checks if the config sets none.
+const DefaultDNSPreflightInterval = time.Minute
+
+// DNSOptions are the DNS record settings of a service.
+type DNSOptions struct {
+	// Targets override the addresses external-dns would read from the exposing object.
+	Targets []string
+	// TTL of the record in seconds, 0 uses the external-dns default.
+	TTL int64
+}
+
+// DNSOptions returns the configured DNS options overridden by the service annotations, with the
+// reasons the result is invalid, if any. Targets of the annotation outside the allowlist are
+// left out.
+func (c *Config) DNSOptions(annotations map[string]string) (DNSOptions, []string) {
+	options := DNSOptions{
+		Targets: splitTargets(c.ExternalDNSTarget),
+		TTL:     c.ExternalDNSTTL,
+	}
+
+	var problems []string
+
+	if value, ok := annotations[DNSTargetAnnotation]; ok {
+		var targets []string
+		for _, target := range splitTargets(value) {
+			if c.IsAllowedDNSTarget(target) {
+				targets = append(targets, target)
+			} else {
+				problems = append(problems, fmt.Sprintf("%s %q: %s is not in the dns target allowlist", DNSTargetAnnotation, value, target))
+			}
+		}
+		if len(targets) > 0 {
+			options.Targets = targets
+		}
+	}
+
+	if value, ok := annotations[DNSTTLAnnotation]; ok {
+		ttl, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q: must be a number", DNSTTLAnnotation, value))
+		}
+		options.TTL = ttl
+	}
+
+	return options, append(problems, ValidateDNSOptions(options)...)
+}
+
+// IsAllowedDNSTarget reports whether the dns-target annotation may point at the target.
+func (c *Config) IsAllowedDNSTarget(target string) bool {
+	ip := net.ParseIP(target)
+
+	for _, allowed := range c.DNSTargetAllowlist {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil {
+			if ip != nil && allowedIP.Equal(ip) {
+				return true
+			}
+		} else if ip == nil && IsSubdomain(target, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateDNSOptions checks that the targets are IP addresses or host names and the TTL is not
+// negative.
+func ValidateDNSOptions(options DNSOptions) []string {
+	var problems []string
+
+	for _, target := range options.Targets {
+		if net.ParseIP(target) != nil {
+			continue
+		}
+
+		for _, msg := range validation.IsDNS1123Subdomain(target) {
+			problems = append(problems, fmt.Sprintf("dns target %q: %s", target, msg))
+		}
+	}
+
+	if options.TTL < 0 {
+		problems = append(problems, fmt.Sprintf("dns ttl %d: must not be negative", options.TTL))
+	}
+
+	return problems
+}
+
+// ExternalDNSObjectAnnotations returns the external-dns annotations of the object exposing the
+// domain, nil unless the annotations mode is enabled.
+func (c *Config) ExternalDNSObjectAnnotations(domain string, options DNSOptions) map[string]string {
+	if c.ExternalDNS != ExternalDNSAnnotations {
+		return nil
+	}
+
+	annotations := map[string]string{ExternalDNSHostnameAnnotation: domain}
+	if len(options.Targets) > 0 {
+		annotations[ExternalDNSTargetAnnotation] = strings.Join(options.Targets, ",")
+	}
+	if options.TTL > 0 {
+		annotations[ExternalDNSTTLAnnotation] = strconv.FormatInt(options.TTL, 10)
+	}
+
+	return annotations
+}
+
+func splitTargets(value string) []string {
+	var targets []string
+	for _, target := range strings.Split(value, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
+
+// PreflightInterval returns the time between two preflight checks of a waiting service.
+func (c *Config) PreflightInterval() time.Duration {
+	if c.DNSPreflightInterval != nil {
+		return c.DNSPreflightInterval.Duration
+	}
+
+	return DefaultDNSPreflightInterval
+}
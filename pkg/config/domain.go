@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	isd "github.com/jbenet/go-is-domain"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ReservedDomains are special-use names (RFC 6761, 6762, 7686 and 8375) public CAs do not issue
+// certificates for. Their subdomains are reserved too.
+var ReservedDomains = []string{"localhost", "local", "test", "invalid", "example", "onion", "internal", "home.arpa"}
+
+// DefaultCAAIdentities is the CAA issuer domain of Let's Encrypt.
+const DefaultCAAIdentities = "letsencrypt.org"
+
+// domainProfile maps internationalized domain names for lookup (RFC 5891 section 5) without the
+// transitional IDNA2003 mappings, so ß or ς are kept instead of being replaced.
+var domainProfile = idna.New(idna.MapForLookup(), idna.BidiRule())
+
+// NormalizeDomain returns the domain in the lowercase ASCII form used in DNS and certificates:
+// the labels with non-ASCII characters are converted to A-labels (punycode), bücher.example
+// becomes xn--bcher-kva.example.
+func NormalizeDomain(domain string) (string, error) {
+	return domainProfile.ToASCII(domain)
+}
+
+// UnicodeDomain returns the domain with its A-labels converted back to Unicode for display, the
+// domain itself if it is not a valid internationalized domain name.
+func UnicodeDomain(domain string) string {
+	if unicode, err := domainProfile.ToUnicode(domain); err == nil {
+		return unicode
+	}
+
+	return domain
+}
+
+// DisplayDomain returns the domain for messages: internationalized domain names in their Unicode
+// form followed by the A-label form in parentheses, other domains as they are.
+func DisplayDomain(domain string) string {
+	if unicode := UnicodeDomain(domain); unicode != domain {
+		return unicode + " (" + domain + ")"
+	}
+
+	return domain
+}
+
+// ValidateDomain returns the reasons no certificate can be issued for the domain, if any.
+// Internationalized domain names are checked in their A-label form. IP addresses and names on
+// the denylist are rejected. Publicly trusted certificates additionally
+// need a registrable domain under a public top-level domain: reserved names and public suffixes
+// are rejected. Other certificates only need a valid DNS name, like internal .svc.cluster.local
+// names.
+func (c *Config) ValidateDomain(domain string, public bool) []string {
+	if net.ParseIP(strings.TrimSuffix(domain, ".")) != nil {
+		return []string{fmt.Sprintf("domain %q: IP addresses are not supported", domain)}
+	}
+
+	name, err := NormalizeDomain(domain)
+	if err != nil {
+		return []string{fmt.Sprintf("domain %q: not a valid internationalized domain name: %v", domain, err)}
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	for _, denied := range c.DomainDenylist {
+		if IsSubdomain(name, denied) {
+			return []string{fmt.Sprintf("domain %q: matches %q of the domain denylist", domain, denied)}
+		}
+	}
+
+	if !public {
+		var problems []string
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			problems = append(problems, fmt.Sprintf("domain %q: %s", domain, msg))
+		}
+
+		return problems
+	}
+
+	for _, reserved := range ReservedDomains {
+		if IsSubdomain(name, reserved) {
+			return []string{fmt.Sprintf("domain %q: %s is reserved for special use", domain, reserved)}
+		}
+	}
+
+	if suffix, _ := publicsuffix.PublicSuffix(name); suffix == name {
+		return []string{fmt.Sprintf("domain %q: is a public suffix, not a registrable domain", domain)}
+	}
+
+	if !isd.IsDomain(name) {
+		return []string{fmt.Sprintf("domain %q: not a valid domain name under a public top-level domain", domain)}
+	}
+
+	return nil
+}
+
+// IsSubdomain reports whether name is parent or one of its subdomains. Both are compared case
+// insensitively.
+func IsSubdomain(name, parent string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	parent = strings.TrimSuffix(strings.ToLower(parent), ".")
+
+	return name == parent || strings.HasSuffix(name, "."+parent)
+}
+
+// CAAIdentityList returns the configured CAA issuer domains.
+func (c *Config) CAAIdentityList() []string {
+	return splitTargets(c.CAAIdentities)
+}
@@ -0,0 +1,280 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	webappv1 "customingressmanager/api/v1"
+)
+
+const (
+	IssuanceModeAnnotation = "feladat.banzaicloud.io/issuance-mode"
+
+	// IssuanceModeACME issues certificates from the production or staging ACME server.
+	IssuanceModeACME = "acme"
+	// IssuanceModeCA issues certificates from the private root CA bootstrapped by the operator.
+	IssuanceModeCA = "ca"
+	// IssuanceModeSelfSigned issues self-signed certificates.
+	IssuanceModeSelfSigned = "selfsigned"
+	// IssuanceModeVault issues certificates from HashiCorp Vault, selected by the policy.
+	IssuanceModeVault = "vault"
+	// IssuanceModeVenafi issues certificates from Venafi, selected by the policy.
+	IssuanceModeVenafi = "venafi"
+	// CADomainNamespace is replaced by the namespace of the service in the CA domains.
+	CADomainNamespace = "{namespace}"
+)
+
+// IssuanceMode returns the issuance mode of the service. A backend selected by the policy
+// wins, then the mode annotation, then an environment label value naming a mode, then the
+// configured default.
+func (c *Config) IssuanceMode(service *corev1.Service, policy *webappv1.CustomIngressManager) string {
+	if mode := PolicyIssuanceMode(policy); mode != "" {
+		return mode
+	}
+
+	if mode, ok := service.ObjectMeta.Annotations[IssuanceModeAnnotation]; ok {
+		return mode
+	}
+
+	switch environment := service.ObjectMeta.Labels[c.EnvironmentLabel]; environment {
+	case IssuanceModeCA, IssuanceModeSelfSigned:
+		return environment
+	}
+
+	return c.DefaultIssuanceMode
+}
+
+// UsesProductionACME reports whether the certificate of the service is ordered from the
+// production ACME server.
+func (c *Config) UsesProductionACME(service *corev1.Service, policy *webappv1.CustomIngressManager) bool {
+	return c.IssuanceMode(service, policy) == IssuanceModeACME && service.ObjectMeta.Labels[c.EnvironmentLabel] == c.ProductionEnvironment
+}
+
+// IsAllowedCADomain reports whether the root CA may issue a certificate for the domain to a
+// service of the namespace: the domain has to be one of the CA domains of the namespace or one of
+// their subdomains. The root CA is trusted cluster-wide, so by default a namespace only gets
+// certificates for its own Services, e.g. testsvc.default.svc.cluster.local in default.
+func (c *Config) IsAllowedCADomain(domain, namespace string) bool {
+	name, err := NormalizeDomain(domain)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range c.CADomains {
+		if IsSubdomain(name, strings.Replace(allowed, CADomainNamespace, namespace, -1)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsValidIssuanceMode reports whether mode is one of the issuance modes selectable without a policy.
+func IsValidIssuanceMode(mode string) bool {
+	switch mode {
+	case IssuanceModeACME, IssuanceModeCA, IssuanceModeSelfSigned:
+		return true
+	}
+
+	return false
+}
+
+// PolicyIssuanceMode returns the issuance mode selected by the policy, empty if the policy
+// leaves it to the service.
+func PolicyIssuanceMode(policy *webappv1.CustomIngressManager) string {
+	if policy == nil || policy.Spec.Issuer == nil {
+		return ""
+	}
+
+	switch {
+	case policy.Spec.Issuer.Vault != nil:
+		return IssuanceModeVault
+	case policy.Spec.Issuer.Venafi != nil:
+		return IssuanceModeVenafi
+	}
+
+	return ""
+}
+
+// ValidatePolicy returns the reasons the issuer or route of the policy can not be used, if any.
+func ValidatePolicy(policy *webappv1.CustomIngressManager) []string {
+	if policy == nil {
+		return nil
+	}
+
+	return append(validateIssuerPolicy(policy.Spec.Issuer), validateRoutePolicy(policy.Spec.Route)...)
+}
+
+func validateIssuerPolicy(issuer *webappv1.IssuerPolicy) []string {
+	if issuer == nil {
+		return nil
+	}
+
+	var problems []string
+
+	if (issuer.Vault == nil) == (issuer.Venafi == nil) {
+		problems = append(problems, "exactly one of issuer.vault and issuer.venafi must be set")
+	}
+
+	if vault := issuer.Vault; vault != nil {
+		if vault.Server == "" || vault.Path == "" {
+			problems = append(problems, "issuer.vault.server and issuer.vault.path are required")
+		}
+
+	}
+
+	if venafi := issuer.Venafi; venafi != nil {
+		if venafi.Zone == "" {
+			problems = append(problems, "issuer.venafi.zone is required")
+		}
+
+		if (venafi.TPP == nil) == (venafi.Cloud == nil) {
+			problems = append(problems, "exactly one of issuer.venafi.tpp and issuer.venafi.cloud must be set")
+		}
+	}
+
+	return problems
+}
+
+// VenafiCloudURL is the public Venafi Cloud API, used by policies not naming one.
+const VenafiCloudURL = "https://api.venafi.cloud/v1"
+
+// IssuerServer is a Vault or Venafi server the policies may issue from, with the credentials
+// cert-manager authenticates with. Policies only name the server, so a tenant can neither send
+// the credentials to a server of its own nor reference other secrets of the cluster resource
+// namespace. Exactly one of the credentials must be set, it selects the kind of the server.
+type IssuerServer struct {
+	// URL is the Vault address, the TPP URL or the Venafi Cloud API URL as named by the policies.
+	URL string `json:"url"`
+	// CABundle is a PEM encoded CA bundle used to validate the server certificate.
+	CABundle []byte `json:"caBundle,omitempty"`
+	// VaultAuth authenticates with a Vault server.
+	VaultAuth *webappv1.VaultAuthPolicy `json:"vaultAuth,omitempty"`
+	// VenafiTPPCredentialsSecretName is the secret holding the username and password keys of a TPP.
+	VenafiTPPCredentialsSecretName string `json:"venafiTPPCredentialsSecretName,omitempty"`
+	// VenafiCloudAPITokenSecretRef references the secret holding the Venafi Cloud API token.
+	VenafiCloudAPITokenSecretRef *webappv1.SecretKeyReference `json:"venafiCloudAPITokenSecretRef,omitempty"`
+}
+
+// PolicyIssuerServer returns the issuer server of the configuration the policy issues from,
+// nil if the policy names a server not configured for its kind of issuer.
+func (c *Config) PolicyIssuerServer(policy *webappv1.CustomIngressManager) *IssuerServer {
+	if policy == nil || policy.Spec.Issuer == nil {
+		return nil
+	}
+
+	issuer := policy.Spec.Issuer
+	for i := range c.IssuerServers {
+		server := &c.IssuerServers[i]
+		switch {
+		case issuer.Vault != nil:
+			if server.VaultAuth != nil && server.URL == issuer.Vault.Server {
+				return server
+			}
+		case issuer.Venafi != nil && issuer.Venafi.TPP != nil:
+			if server.VenafiTPPCredentialsSecretName != "" && server.URL == issuer.Venafi.TPP.URL {
+				return server
+			}
+		case issuer.Venafi != nil && issuer.Venafi.Cloud != nil:
+			url := issuer.Venafi.Cloud.URL
+			if url == "" {
+				url = VenafiCloudURL
+			}
+			if server.VenafiCloudAPITokenSecretRef != nil && server.URL == url {
+				return server
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateIssuerServers(servers []IssuerServer) []string {
+	var problems []string
+
+	for i, server := range servers {
+		name := fmt.Sprintf("issuerServers[%d]", i)
+		if !strings.HasPrefix(server.URL, "https://") {
+			problems = append(problems, fmt.Sprintf("%s.url %q: must be an https URL", name, server.URL))
+		}
+
+		credentials := 0
+		for _, set := range []bool{server.VaultAuth != nil, server.VenafiTPPCredentialsSecretName != "", server.VenafiCloudAPITokenSecretRef != nil} {
+			if set {
+				credentials++
+			}
+		}
+		if credentials != 1 {
+			problems = append(problems, name+": exactly one of vaultAuth, venafiTPPCredentialsSecretName and venafiCloudAPITokenSecretRef must be set")
+		}
+
+		if auth := server.VaultAuth; auth != nil {
+			methods := 0
+			for _, set := range []bool{auth.TokenSecretRef != nil, auth.AppRole != nil, auth.Kubernetes != nil} {
+				if set {
+					methods++
+				}
+			}
+			if methods != 1 {
+				problems = append(problems, name+": exactly one of vaultAuth.tokenSecretRef, appRole and kubernetes must be set")
+			}
+		}
+	}
+
+	return problems
+}
+
+func validateRoutePolicy(route *webappv1.RoutePolicy) []string {
+	if route == nil {
+		return nil
+	}
+
+	var problems []string
+
+	set := 0
+	for _, selected := range []bool{route.Gateway != nil, route.Istio != nil, route.Traefik != nil, route.OpenShift != nil} {
+		if selected {
+			set++
+		}
+	}
+	if set > 1 {
+		problems = append(problems, "at most one of route.gateway, route.istio, route.traefik and route.openShift can be set")
+	}
+
+	if gateway := route.Gateway; gateway != nil {
+		if gateway.Name == "" {
+			problems = append(problems, "route.gateway.name is required")
+		}
+
+		if gateway.ManageListener && gateway.SectionName != "" {
+			problems = append(problems, "route.gateway.sectionName must be empty when the listener is managed")
+		}
+	}
+
+	if istio := route.Istio; istio != nil && istio.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(istio.Namespace) {
+			problems = append(problems, fmt.Sprintf("route.istio.namespace %q: %s", istio.Namespace, msg))
+		}
+	}
+
+	return problems
+}
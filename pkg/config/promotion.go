@@ -0,0 +1,74 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	webappv1 "customingressmanager/api/v1"
+)
+
+// Phases of the staging to production promotion of a service.
+const (
+	// PromotionPhaseStaging issues a staging certificate for the service.
+	PromotionPhaseStaging = "Staging"
+	// PromotionPhaseProduction issues the production certificate after the staging one was issued.
+	PromotionPhaseProduction = "Production"
+	// PromotionPhasePromoted is reached once the production certificate is issued.
+	PromotionPhasePromoted = "Promoted"
+)
+
+const (
+	// PromotionPhaseAnnotation records the promotion phase on the service.
+	PromotionPhaseAnnotation = "feladat.banzaicloud.io/promotion-phase"
+	// PromotionStagingSerialAnnotation records the serial number of the staging certificate, the
+	// production certificate is told apart by it.
+	PromotionStagingSerialAnnotation = "feladat.banzaicloud.io/promotion-staging-serial"
+	// PromotionDomainAnnotation records the domain the promotion is for, a promotion of another
+	// domain starts over.
+	PromotionDomainAnnotation = "feladat.banzaicloud.io/promotion-domain"
+	// PromotionReissueAnnotation records when the re-issuance of the staging certificate was last
+	// forced after the switch to the production ACME server.
+	PromotionReissueAnnotation = "feladat.banzaicloud.io/promotion-reissued-at"
+	// PromotionConditionAnnotation records the Promoted condition of the service, with the time
+	// it entered the current phase.
+	PromotionConditionAnnotation = "feladat.banzaicloud.io/promotion-condition"
+	// PromotionStagingEnvironment is the environment the certificate of a service in the staging
+	// phase is issued in.
+	PromotionStagingEnvironment = "staging"
+)
+
+// Promotes reports whether the production certificate of the service is preceded by a staging one.
+func (c *Config) Promotes(service *corev1.Service, policy *webappv1.CustomIngressManager) bool {
+	return c.Promotion && c.UsesProductionACME(service, policy)
+}
+
+// PromotedService returns the service as its certificate is issued: in the staging environment
+// during the staging phase of the promotion, unchanged otherwise.
+func (c *Config) PromotedService(service corev1.Service) corev1.Service {
+	if service.ObjectMeta.Annotations[PromotionPhaseAnnotation] != PromotionPhaseStaging {
+		return service
+	}
+
+	staging := *service.DeepCopy()
+	if staging.ObjectMeta.Labels == nil {
+		staging.ObjectMeta.Labels = map[string]string{}
+	}
+	staging.ObjectMeta.Labels[c.EnvironmentLabel] = PromotionStagingEnvironment
+
+	return staging
+}
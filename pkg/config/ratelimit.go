@@ -0,0 +1,48 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Windows of the Let's Encrypt rate limits the budgets are accounted in.
+const (
+	// RegisteredDomainRateLimitWindow is the window of the certificates per registered domain limit.
+	RegisteredDomainRateLimitWindow = 7 * 24 * time.Hour
+	// AccountRateLimitWindow is the window of the new orders per account limit.
+	AccountRateLimitWindow = 3 * time.Hour
+)
+
+// RegisteredDomain returns the domain one label below its public suffix, e.g. example.co.uk for
+// www.example.co.uk. Domains the public suffix list can not split are returned unchanged.
+func RegisteredDomain(domain string) string {
+	registered, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(strings.ToLower(domain), "."))
+	if err != nil {
+		return domain
+	}
+
+	return registered
+}
+
+// RateLimitBudgeting reports whether production ACME orders are accounted against a budget.
+func (c *Config) RateLimitBudgeting() bool {
+	return c.RateLimitDomainBudget > 0 || c.RateLimitAccountBudget > 0
+}